@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+)
+
+//=============================================================================
+
+// -dedupe-against makes an earlier batch count as already seen, so only
+// what's new in this one gets thumbnailed: inputs duplicating one of its
+// images are skipped and reported as present, naming the earlier copy.
+// It's either the earlier batch's directory, whose images are all read
+// (and decoded too, in phash mode) before the run starts, or that run's
+// manifest, which already has the checksums and is much quicker. Only a
+// run under the same -hash will do; phashes aren't in manifests at all.
+
+var dedupeAgainst = flag.String("dedupe-against", "", "a directory of earlier images, or an earlier run's manifest (.csv or .json), to skip duplicates of as already present")
+
+// Every earlier path loaded, to tell its duplicates from the run's own.
+var priorPaths = make(map[string]bool)
+
+// loadPrior remembers path's images, or its manifest's checksums.
+func loadPrior(path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        if thumbnailer.DedupeMode == "phash" {
+            return errors.New("Manifests have no phashes, use -dedupe-against with a directory for -dedupe-mode phash")
+        }
+        return loadPriorManifest(path)
+    }
+
+    return filepath.Walk(path, func (path string, info os.FileInfo, err error) error {
+        if err != nil || info.IsDir() || !isImagePath(path, info.Size()) {
+            return err
+        }
+        if err := thumbnailer.Remember(path); err != nil {
+            slog.Warn("Can't dedupe against", "path", path, "err", err)
+            return nil
+        }
+        priorPaths[path] = true
+        return nil
+    })
+}
+
+// loadPriorManifest reads the input and checksum of each record in a
+// manifest written by -manifest, as CSV or, for a .json, JSON.
+func loadPriorManifest(path string) error {
+    fp, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer fp.Close()
+
+    var records []manifestRecord
+    if strings.HasSuffix(strings.ToLower(path), ".json") {
+        if err := json.NewDecoder(fp).Decode(&records); err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+    } else if records, err = readManifestCSV(fp); err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+
+    size := 2 * thumbnail.HASHES[thumbnailer.Hash]().Size()
+    for _, record := range records {
+        // Failed and missing inputs were never read.
+        if record.Checksum == "" {
+            continue
+        }
+        if len(record.Checksum) != size {
+            return fmt.Errorf("%s: Checksum %s of %s isn't %s, expected the same -hash as this run", path, record.Checksum, record.Input, thumbnailer.Hash)
+        }
+        thumbnailer.RememberChecksum(record.Checksum, record.Input)
+        priorPaths[record.Input] = true
+    }
+    return nil
+}
+
+// readManifestCSV reads just the input and checksum columns, found by
+// name, so manifests from before a column was added still load.
+func readManifestCSV(r io.Reader) ([]manifestRecord, error) {
+    reader := csv.NewReader(r)
+    header, err := reader.Read()
+    if err != nil {
+        return nil, err
+    }
+    input, checksum := slices.Index(header, "input"), slices.Index(header, "checksum")
+    if input < 0 || checksum < 0 {
+        return nil, errors.New("Not a manifest, expected input and checksum columns")
+    }
+
+    var records []manifestRecord
+    for {
+        row, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            return records, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, manifestRecord{Input: row[input], Checksum: row[checksum]})
+    }
+}
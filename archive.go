@@ -0,0 +1,210 @@
+package main
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "errors"
+    "flag"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+//=============================================================================
+
+// Millions of small files are slow to write and slower to copy anywhere.
+// With -archive, everything that would go under -o (thumbnails, sidecars,
+// montages) goes into one archive beside it instead, named -o plus .tar,
+// .tar.gz or .zip, each entry at its path under -o; the -manifest goes in
+// at the end, under its own name. Workers still encode in parallel, but
+// archive writers take one entry at a time, so a single goroutine writes
+// them all, in the order they're handed over. Nothing can be taken out of
+// an archive, so each input's thumbnails are held back until it's done,
+// as with -ordered, and only added once none of them failed (or timed
+// out). The archive is new each run, so there's nothing for
+// -skip-existing to find, or to link to.
+
+var archiveFormat = flag.String("archive", "", "write everything for -o into one archive, -o plus the extension, instead: `tar`, tar.gz or zip (with -manifest inside)")
+
+var ARCHIVE_FORMATS = map[string]bool{
+    "tar": true,
+    "tar.gz": true,
+    "zip": true,
+}
+
+// Made in main with -archive.
+var archive *archiveWriter
+
+func validArchive() error {
+    switch {
+    case !ARCHIVE_FORMATS[*archiveFormat]:
+        return fmt.Errorf("Unknown archive format %q, expected tar, tar.gz or zip", *archiveFormat)
+    case isS3URI(*outputDir):
+        return errors.New("-archive needs a local -o")
+    case *inPlace:
+        return errors.New("Use -archive or -inplace, not both")
+    case *skipExisting:
+        return errors.New("Use -archive or -skip-existing, not both")
+    case *dupAction == "link":
+        return errors.New("-dup-action link can't link inside an -archive")
+    }
+    return nil
+}
+
+type archiveEntry struct {
+    name string
+    data []byte
+    done chan error
+}
+
+type archiveWriter struct {
+    fp      *os.File
+    gzip    *gzip.Writer // Only for tar.gz.
+    tar     *tar.Writer  // Nil for zip.
+    zip     *zip.Writer
+    entries chan archiveEntry
+    stopped chan struct{}
+
+    // Set by close, after which add fails.
+    closeMutex sync.Mutex
+    closed     bool
+}
+
+// openArchive creates path for a key of ARCHIVE_FORMATS and starts its
+// writer.
+func openArchive(path, format string) (*archiveWriter, error) {
+    fp, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+
+    a := &archiveWriter{fp: fp, entries: make(chan archiveEntry), stopped: make(chan struct{})}
+    switch format {
+    case "tar":
+        a.tar = tar.NewWriter(fp)
+    case "tar.gz":
+        a.gzip = gzip.NewWriter(fp)
+        a.tar = tar.NewWriter(a.gzip)
+    case "zip":
+        a.zip = zip.NewWriter(fp)
+    }
+
+    go func() {
+        defer close(a.stopped)
+        for entry := range a.entries {
+            entry.done <- a.write(entry.name, entry.data)
+        }
+    }()
+    return a, nil
+}
+
+func (a *archiveWriter) write(name string, data []byte) error {
+    now := time.Now()
+    if a.tar != nil {
+        header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+        if err := a.tar.WriteHeader(header); err != nil {
+            return err
+        }
+        _, err := a.tar.Write(data)
+        return err
+    }
+
+    // Images are compressed already; deflating them again barely helps.
+    header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: now}
+    w, err := a.zip.CreateHeader(header)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(data)
+    return err
+}
+
+// add writes an entry through the writer goroutine, once it gets to it.
+func (a *archiveWriter) add(name string, data []byte) error {
+    done := make(chan error, 1)
+    a.closeMutex.Lock()
+    if a.closed {
+        a.closeMutex.Unlock()
+        return fmt.Errorf("%s: archive already closed", name)
+    }
+    a.entries <- archiveEntry{filepath.ToSlash(name), data, done}
+    a.closeMutex.Unlock()
+    return <-done
+}
+
+// close waits for the writer to finish, then finishes the archive. Adds
+// after it fail.
+func (a *archiveWriter) close() error {
+    if a == nil {
+        return nil
+    }
+    a.closeMutex.Lock()
+    a.closed = true
+    close(a.entries)
+    a.closeMutex.Unlock()
+    <-a.stopped
+
+    var err error
+    if a.tar != nil {
+        err = a.tar.Close()
+    } else {
+        err = a.zip.Close()
+    }
+    if a.gzip != nil {
+        if gzErr := a.gzip.Close(); err == nil {
+            err = gzErr
+        }
+    }
+    if closeErr := a.fp.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// addManifest puts the finished -manifest in the archive.
+func (a *archiveWriter) addManifest(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    return a.add(filepath.Base(path), data)
+}
+
+//=============================================================================
+
+// archiveStorage puts whatever's written under -o into the archive, and
+// leaves everything else (inputs, a -quarantine) to uriStorage.
+type archiveStorage struct {
+    uriStorage
+}
+
+// inArchive is path's entry name, if it's under -o.
+func inArchive(path string) (string, bool) {
+    rel, err := relativePath(*outputDir, path)
+    return rel, err == nil
+}
+
+func (s archiveStorage) WriteFile(path string, data []byte) error {
+    if name, found := inArchive(path); found {
+        return archive.add(name, data)
+    }
+    return s.uriStorage.WriteFile(path, data)
+}
+
+func (s archiveStorage) Size(path string) (int64, error) {
+    if _, found := inArchive(path); found {
+        return 0, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+    }
+    return s.uriStorage.Size(path)
+}
+
+func (s archiveStorage) MkdirAll(dir string) error {
+    if _, found := inArchive(dir); found {
+        return nil
+    }
+    return s.uriStorage.MkdirAll(dir)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+// archived reads back each entry of the archive at path.
+func archived(t *testing.T, path, format string) map[string]string {
+    t.Helper()
+    entries := make(map[string]string)
+    if format == "zip" {
+        r, err := zip.OpenReader(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer r.Close()
+        for _, f := range r.File {
+            rc, err := f.Open()
+            if err != nil {
+                t.Fatal(err)
+            }
+            data, err := io.ReadAll(rc)
+            rc.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+            entries[f.Name] = string(data)
+        }
+        return entries
+    }
+
+    fp, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer fp.Close()
+    var r io.Reader = fp
+    if format == "tar.gz" {
+        if r, err = gzip.NewReader(fp); err != nil {
+            t.Fatal(err)
+        }
+    }
+    tr := tar.NewReader(r)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return entries
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := io.ReadAll(tr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        entries[header.Name] = string(data)
+    }
+}
+
+// What's added before close is in the archive, and an add after it (as
+// from an input -timeout gave up on) fails instead of panicking.
+func TestArchive(t *testing.T) {
+    for format := range ARCHIVE_FORMATS {
+        path := filepath.Join(t.TempDir(), "out." + format)
+        a, err := openArchive(path, format)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for name, data := range map[string]string{"a.png": "a", filepath.Join("x", "b.png"): "b"} {
+            if err := a.add(name, []byte(data)); err != nil {
+                t.Fatalf("%s: %v", format, err)
+            }
+        }
+        if err := a.close(); err != nil {
+            t.Fatalf("%s: %v", format, err)
+        }
+        if err := a.add("late.png", []byte("late")); err == nil {
+            t.Errorf("%s: added after close", format)
+        }
+
+        want := map[string]string{"a.png": "a", "x/b.png": "b"}
+        if got := archived(t, path, format); !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: got %v, want %v", format, got, want)
+        }
+    }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//=============================================================================
+
+// -config takes a JSON object keyed by flag name, e.g.
+//
+//     {"d": [256, 256], "anchors": ["center", "smart"], "format": "jpeg"}
+//
+// Each value goes through the flag's own Set, so it's parsed and checked
+// exactly like its command-line spelling. Flags given on the command line
+// win. Lists are joined with commas, which is what the list flags take.
+
+var configPath = flag.String("config", "", "JSON file of flag defaults, keyed by flag name (command-line flags override)")
+
+// configValue renders a JSON value the way it'd be typed after its flag.
+func configValue(raw interface{}) (string, error) {
+    switch v := raw.(type) {
+    case string:
+        return v, nil
+    case bool:
+        return strconv.FormatBool(v), nil
+    case json.Number:
+        return v.String(), nil
+    case []interface{}:
+        parts := make([]string, len(v))
+        for i, item := range v {
+            part, err := configValue(item)
+            if err != nil {
+                return "", err
+            }
+            parts[i] = part
+        }
+        return strings.Join(parts, ","), nil
+    }
+    return "", fmt.Errorf("Unsupported value %v", raw)
+}
+
+// loadConfig applies the -config file to every flag the command line
+// didn't set. Unknown keys are an error, so a typo can't silently do
+// nothing.
+func loadConfig(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+
+    var values map[string]interface{}
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if err := decoder.Decode(&values); err != nil {
+        return fmt.Errorf("Reading %s: %w", path, err)
+    }
+
+    // Sorted, so the first bad key reported is the same every run.
+    names := make([]string, 0, len(values))
+    for name := range values {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        if name == "config" || flag.Lookup(name) == nil {
+            return fmt.Errorf("Unknown config key %q in %s", name, path)
+        }
+        if isFlagSet(name) {
+            continue
+        }
+
+        value, err := configValue(values[name])
+        if err == nil {
+            err = flag.Set(name, value)
+        }
+        if err != nil {
+            return fmt.Errorf("Config key %q in %s: %w", name, path, err)
+        }
+    }
+    return nil
+}
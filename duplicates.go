@@ -0,0 +1,91 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "log/slog"
+    "os"
+    "path/filepath"
+)
+
+//=============================================================================
+
+// Duplicates always name their original in the manifest. -dup-action can
+// also report each pair as it's found, or give the duplicate outputs of
+// its own: symlinks to the original's thumbnails, so a dataset laid out by
+// input still has an entry for every input at no extra space. The links
+// are made as soon as the duplicate is seen, so they can briefly (or, if
+// the original fails, permanently) dangle.
+
+var dupAction = flag.String("dup-action", "skip", "what to do with duplicates: `skip`, log (report each pair), or link (symlink to the original's thumbnails)")
+
+var DUP_ACTIONS = map[string]bool{
+    "skip": true,
+    "log": true,
+    "link": true,
+}
+
+func validDupAction(action string) error {
+    if !DUP_ACTIONS[action] {
+        return fmt.Errorf("Unknown dup action %q, expected skip, log or link", action)
+    }
+    return nil
+}
+
+// linkDuplicate points a duplicate's outputs at its original's, filling in
+// result.Outputs with the links. It returns false if it didn't link.
+func linkDuplicate(result *thumbnail.Result, outputFile string) bool {
+    if isS3URI(outputFile) {
+        slog.Warn("Can't link duplicates in S3", "path", result.Input)
+        return false
+    }
+
+    originalFile, err := outputPath(result.Original)
+    if err == nil && *shards > 0 {
+        // An exact duplicate's checksum is its original's; a near one's
+        // isn't, so that has to be read.
+        checksum := result.Checksum
+        if *dedupeMode == "phash" {
+            checksum, err = thumbnailer.Checksum(result.Original)
+        }
+        originalFile = shardFile(result.Original, originalFile, checksum)
+    }
+    if err != nil {
+        slog.Error("Failed link", "path", result.Input, "err", err)
+        return false
+    }
+
+    dir := thumbnail.DirPath(outputFile)
+    links := thumbnailer.OutputPaths(dir, outputStem(outputFile), result.Format)
+    targets := thumbnailer.OutputPaths(thumbnail.DirPath(originalFile), outputStem(originalFile), result.Format)
+
+    if !*dryRun {
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            slog.Error("Failed link", "path", result.Input, "err", err)
+            return false
+        }
+    }
+
+    for i, link := range links {
+        // Relative, so the output tree can be moved as a whole.
+        target, err := filepath.Rel(dir, targets[i].Path)
+        if err != nil {
+            target = targets[i].Path
+        }
+
+        if *dryRun {
+            slog.Info("Would link", "path", link.Path, "to", target)
+        } else {
+            // A rerun may have picked the other copy as the original.
+            os.Remove(link.Path)
+            if err := os.Symlink(target, link.Path); err != nil {
+                slog.Error("Failed link", "path", link.Path, "err", err)
+                return false
+            }
+            slog.Debug("Linked", "path", link.Path, "to", target)
+        }
+        result.Outputs = append(result.Outputs, link)
+    }
+    return true
+}
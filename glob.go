@@ -0,0 +1,105 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "path"
+    "strings"
+)
+
+//=============================================================================
+
+// -glob and -exclude narrow the walk by path relative to -i (or the S3
+// prefix), with / separators. They're path.Match patterns plus a **
+// segment for any number of directories, so **/*.jpg is every JPEG and
+// *.jpg only the ones at the top. An input has to match one -glob, if
+// any are given, and no -exclude; a directory matching an -exclude isn't
+// descended at all, so raw/** (or just raw) prunes the whole subtree.
+// Both still go along with -ext, and neither applies to -stdin or -map.
+
+var globSpec    = flag.String("glob", "", "comma-separated patterns relative to -i, like **/*.jpg (** matches any number of directories); only matching inputs are walked")
+var excludeSpec = flag.String("exclude", "", "comma-separated patterns, as in -glob, of inputs or directories to leave out")
+
+// Split from the flags in main.
+var globs, excludes []string
+
+func setupGlobs() (err error) {
+    if globs, err = parseGlobs(*globSpec); err != nil {
+        return err
+    }
+    excludes, err = parseGlobs(*excludeSpec)
+    return err
+}
+
+func parseGlobs(spec string) ([]string, error) {
+    var patterns []string
+    for _, pattern := range strings.Split(spec, ",") {
+        pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+        if pattern == "" {
+            continue
+        }
+        for _, segment := range strings.Split(pattern, "/") {
+            if _, err := path.Match(segment, ""); err != nil {
+                return nil, fmt.Errorf("Bad pattern %q: %w", pattern, err)
+            }
+        }
+        patterns = append(patterns, pattern)
+    }
+    return patterns, nil
+}
+
+// globMatch is path.Match with ** segments matching zero or more whole
+// segments of name.
+func globMatch(pattern, name string) bool {
+    return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+    for len(pattern) > 0 {
+        if pattern[0] == "**" {
+            for i := 0; i <= len(name); i++ {
+                if matchSegments(pattern[1:], name[i:]) {
+                    return true
+                }
+            }
+            return false
+        }
+        if len(name) == 0 {
+            return false
+        }
+        if matched, _ := path.Match(pattern[0], name[0]); !matched {
+            return false
+        }
+        pattern, name = pattern[1:], name[1:]
+    }
+    return len(name) == 0
+}
+
+func matchesAny(patterns []string, name string) bool {
+    for _, pattern := range patterns {
+        if globMatch(pattern, name) {
+            return true
+        }
+    }
+    return false
+}
+
+// globSelected reports whether an input at rel (slash separated) passes
+// -glob and -exclude. Its directories are checked too, for listings like
+// S3's that never visit them.
+func globSelected(rel string) bool {
+    if len(globs) > 0 && !matchesAny(globs, rel) {
+        return false
+    }
+    for dir := rel; dir != "." && dir != "/"; dir = path.Dir(dir) {
+        if matchesAny(excludes, dir) {
+            return false
+        }
+    }
+    return true
+}
+
+// globExcludedDir reports whether -exclude prunes the directory at rel.
+func globExcludedDir(rel string) bool {
+    return rel != "." && rel != "" && matchesAny(excludes, rel)
+}
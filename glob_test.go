@@ -0,0 +1,90 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+func TestGlobMatch(t *testing.T) {
+    tests := []struct {
+        pattern string
+        yes, no []string
+    }{
+        // ** first: any depth, the top included.
+        {"**/*.jpg", []string{"a.jpg", "x/a.jpg", "x/y/z/a.jpg"}, []string{"a.png", "x/a.png", "x.jpg/a.png"}},
+        // In the middle: zero or more directories between.
+        {"raw/**/keep/*.jpg", []string{"raw/keep/a.jpg", "raw/x/keep/a.jpg", "raw/x/y/keep/a.jpg"}, []string{"raw/keep/x/a.jpg", "x/raw/keep/a.jpg", "raw/keeper/a.jpg"}},
+        // Last: the directory itself and everything under it.
+        {"raw/**", []string{"raw", "raw/a.jpg", "raw/x/y/a.jpg"}, []string{"rawer/a.jpg", "x/raw/a.jpg"}},
+        // Without **, segments match one for one.
+        {"*.jpg", []string{"a.jpg"}, []string{"x/a.jpg"}},
+        {"x/*/a.jpg", []string{"x/y/a.jpg"}, []string{"x/a.jpg", "x/y/z/a.jpg"}},
+        {"**", []string{"a.jpg", "x/y/a.jpg"}, nil},
+    }
+    for _, test := range tests {
+        for _, name := range test.yes {
+            if !globMatch(test.pattern, name) {
+                t.Errorf("%s doesn't match %s", test.pattern, name)
+            }
+        }
+        for _, name := range test.no {
+            if globMatch(test.pattern, name) {
+                t.Errorf("%s matches %s", test.pattern, name)
+            }
+        }
+    }
+}
+
+func TestParseGlobs(t *testing.T) {
+    got, err := parseGlobs(" **/*.jpg, /raw/ ,,")
+    if err != nil || !reflect.DeepEqual(got, []string{"**/*.jpg", "raw"}) {
+        t.Errorf("Got %q, %v", got, err)
+    }
+    if _, err := parseGlobs("x/[a"); err == nil {
+        t.Error("Bad pattern accepted")
+    }
+}
+
+// setGlobs sets -glob and -exclude as main would, for the rest of the
+// test.
+func setGlobs(t *testing.T, glob, exclude string) {
+    t.Helper()
+    setFlag(t, globSpec, glob)
+    setFlag(t, excludeSpec, exclude)
+    setFlag(t, &globs, nil)
+    setFlag(t, &excludes, nil)
+    if err := setupGlobs(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// -exclude prunes whole directories from the walk, whether it names the
+// directory or everything in it; -glob only picks among files.
+func TestWalkGlobs(t *testing.T) {
+    root := imageTree(t, "a.jpg", "b.png", "raw/c.jpg", "raw/x/d.jpg", "x/raw/e.jpg", "x/f.jpg")
+    tests := []struct {
+        glob, exclude string
+        want          []string
+        pruned        []string
+    }{
+        {"", "raw", []string{"a.jpg", "b.png", "x/f.jpg", "x/raw/e.jpg"}, []string{"raw"}},
+        {"", "raw/**", []string{"a.jpg", "b.png", "x/f.jpg", "x/raw/e.jpg"}, []string{"raw", "raw/x"}},
+        {"", "**/raw", []string{"a.jpg", "b.png", "x/f.jpg"}, []string{"raw", "x/raw"}},
+        {"**/*.jpg", "x", []string{"a.jpg", "raw/c.jpg", "raw/x/d.jpg"}, []string{"x"}},
+        {"*.jpg", "", []string{"a.jpg"}, nil},
+    }
+
+    for _, test := range tests {
+        setGlobs(t, test.glob, test.exclude)
+        if got := walked(t, root); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("-glob %q -exclude %q: got %v, want %v", test.glob, test.exclude, got, test.want)
+        }
+        for _, dir := range test.pruned {
+            if !globExcludedDir(dir) {
+                t.Errorf("-exclude %q doesn't prune %s", test.exclude, dir)
+            }
+        }
+    }
+}
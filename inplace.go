@@ -0,0 +1,42 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "path/filepath"
+    "strings"
+)
+
+//=============================================================================
+
+// -inplace writes each input's thumbnails into its own directory instead
+// of mirroring -i in -o, with -inplace-suffix after the stem so they stand
+// out: photo.thumb_center.png, or with -single photo.thumb.png. Anything
+// named like that is left out of the walk, so rerunning over the same tree
+// doesn't thumbnail the thumbnails.
+
+var inPlace     = flag.Bool("inplace", false, "write thumbnails next to their inputs, named with -inplace-suffix, instead of under -o")
+var placeSuffix = flag.String("inplace-suffix", ".thumb", "what -inplace puts after the input's name, e.g. photo.thumb_center.png")
+
+func validPlaceSuffix(suffix string) error {
+    if suffix == "" || strings.ContainsAny(suffix, `/\`) {
+        return fmt.Errorf("Bad -inplace-suffix %q, expected a non-empty name part", suffix)
+    }
+    return nil
+}
+
+// outputStem is what outputFile's thumbnails are named after.
+func outputStem(outputFile string) string {
+    if *inPlace {
+        return thumbnail.OutputStem(outputFile) + *placeSuffix
+    }
+    return thumbnail.OutputStem(outputFile)
+}
+
+// isPlacedThumb reports whether path is named like one of -inplace's
+// thumbnails.
+func isPlacedThumb(path string) bool {
+    name := filepath.Base(path)
+    return *inPlace && (strings.Contains(name, *placeSuffix + "_") || strings.Contains(name, *placeSuffix + "."))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+// -inplace thumbnails land beside their inputs, and a second run over the
+// same tree leaves them be.
+func TestInPlace(t *testing.T) {
+    in := filepath.Join(t.TempDir(), "in")
+    writeFile(t, in, "a.png", pngImage(t))
+    writeFile(t, in, "x/b.png", pngImage(t))
+    setFlag(t, inputDir, in)
+    setFlag(t, outputDir, in) // Just so run lists it.
+    setFlag(t, inPlace, true)
+    setFlag(t, single, true)
+    setFlag(t, deduplicate, false)
+
+    want := []string{"a.png", "a.thumb.png", "x/b.png", "x/b.thumb.png"}
+    for i := 1; i <= 2; i++ {
+        if got := run(t); !reflect.DeepEqual(got, want) {
+            t.Errorf("Run %d: got %v, want %v", i, got, want)
+        }
+    }
+    // Same names either way, as a.thumb.png's own thumbnail would be
+    // a.thumb.png again; the walk is what has to leave it out.
+    if got := walked(t, in); !reflect.DeepEqual(got, []string{"a.png", "x/b.png"}) {
+        t.Errorf("Walked %v", got)
+    }
+
+    // Without -single, the variant keys come after the suffix.
+    in = filepath.Join(t.TempDir(), "in")
+    writeFile(t, in, "a.png", pngImage(t))
+    *inputDir, *outputDir = in, in
+    *single = false
+    setFlag(t, placeSuffix, "-t")
+    want = []string{
+        "a-t_center.png", "a-t_center_flipped.png",
+        "a-t_left.png", "a-t_left_flipped.png",
+        "a-t_right.png", "a-t_right_flipped.png",
+        "a.png",
+    }
+    if got := run(t); !reflect.DeepEqual(got, want) {
+        t.Errorf("Got %v, want %v", got, want)
+    }
+}
+
+func TestIsPlacedThumb(t *testing.T) {
+    setFlag(t, inPlace, true)
+    tests := map[string]bool{
+        "x/a.thumb.png": true,
+        "x/a.thumb_center_flipped.png": true,
+        "x/a.png": false,
+        "x/a.thumbnail.png": false,
+        "x.thumb/a.png": false,
+    }
+    for path, want := range tests {
+        if got := isPlacedThumb(path); got != want {
+            t.Errorf("%s: got %v, want %v", path, got, want)
+        }
+    }
+    *inPlace = false
+    if isPlacedThumb("x/a.thumb.png") {
+        t.Error("Left out without -inplace")
+    }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "os"
+)
+
+//=============================================================================
+
+// Everything but the end-of-run summary goes through slog, on stderr. The
+// built-in handlers write each record in a single locked call, so lines
+// from concurrent workers never interleave.
+
+var logLevel  = flag.String("log-level", "info", "minimum log level: debug, `info`, warn or error (-v means debug)")
+var logFormat = flag.String("log-format", "text", "log format, `text` or json")
+
+var LOG_LEVELS = map[string]slog.Level{
+    "debug": slog.LevelDebug,
+    "info": slog.LevelInfo,
+    "warn": slog.LevelWarn,
+    "error": slog.LevelError,
+}
+
+// setupLogging installs the default logger from the flags.
+func setupLogging() error {
+    level, found := LOG_LEVELS[*logLevel]
+    if !found {
+        return fmt.Errorf("Unknown log level %q, expected debug, info, warn or error", *logLevel)
+    }
+    if *verbose && !isFlagSet("log-level") {
+        level = slog.LevelDebug
+    }
+
+    // Debug is what -v always meant. Keeping the two in step is how the
+    // progress bar knows to stay out of the way.
+    *verbose = level <= slog.LevelDebug
+
+    opts := &slog.HandlerOptions{Level: level}
+    var handler slog.Handler
+    switch *logFormat {
+    case "text":
+        handler = slog.NewTextHandler(os.Stderr, opts)
+    case "json":
+        handler = slog.NewJSONHandler(os.Stderr, opts)
+    default:
+        return fmt.Errorf("Unknown log format %q, expected text or json", *logFormat)
+    }
+
+    slog.SetDefault(slog.New(handler))
+    return nil
+}
+
+// fatal is log.Fatal for slog.
+func fatal(err error) {
+    slog.Error(err.Error())
+    os.Exit(1)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "image"
+    "os"
+    "strconv"
+    "sync"
+)
+
+//=============================================================================
+
+// The manifest maps every input to what became of it, so training
+// pipelines can trace a thumbnail back to its source (or find out why a
+// source has none). Each written thumbnail gets its own row; inputs that
+// produced nothing get a single row with the output columns empty. With
+// -sprite, each variant still gets a row, all naming the one sprite, and
+// its tile says where in it the variant is.
+//
+// The JSON flavor is one array of records, one per input, with outputs 
+// keyed by variant. It's only written on close, so records accumulate in
+// memory until then.
+
+var MANIFEST_HEADER = []string{
+    "input", "checksum", "format", "width", "height", "blurhash", "output", "anchor", "flipped", "status", "original", "dominant_color", "tile",
+}
+
+type manifestRecord struct {
+    Input    string            `json:"input"`
+    Checksum string            `json:"checksum"`
+    Format   string            `json:"format"`
+    Width    int               `json:"width"`
+    Height   int               `json:"height"`
+    BlurHash string            `json:"blurhash,omitempty"`
+    Outputs  map[string]string `json:"outputs"`
+    Colors   map[string]string `json:"dominant_colors,omitempty"` // Keyed like Outputs.
+    Tiles    map[string]string `json:"tiles,omitempty"`           // Keyed like Outputs, with -sprite.
+    Status   string            `json:"status"`
+    Original string            `json:"original,omitempty"` // Only for duplicates.
+}
+
+type manifestWriter struct {
+    mutex   sync.Mutex
+    fp      *os.File
+    csv     *csv.Writer      // Nil for JSON.
+    records []manifestRecord // Only for JSON.
+}
+
+// openManifest creates path for format csv or json.
+func openManifest(path string, format string) (*manifestWriter, error) {
+    if format != "csv" && format != "json" {
+        return nil, fmt.Errorf("Unknown manifest format %q, expected csv or json", format)
+    }
+
+    fp, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+
+    m := &manifestWriter{fp: fp}
+    if format == "csv" {
+        m.csv = csv.NewWriter(fp)
+        m.csv.Write(MANIFEST_HEADER)
+    } else {
+        m.records = []manifestRecord{} // So an empty run writes [], not null.
+    }
+
+    return m, nil
+}
+
+// record appends result's rows. Status is written, orphaned (written into
+// -orphan-dir), skipped, linked (a duplicate's outputs, with -dup-action
+// link), present (a duplicate of an earlier batch's, with -dedupe-against)
+// or failed, or planned in a dry run. Duplicates also name their original.
+func (m *manifestWriter) record(result *thumbnail.Result, status string) {
+    if m == nil || result == nil {
+        return
+    }
+
+    common := []string{
+        result.Input,
+        result.Checksum,
+        result.Format,
+        strconv.Itoa(result.Size.X),
+        strconv.Itoa(result.Size.Y),
+        result.BlurHash,
+    }
+
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    if m.csv == nil {
+        record := manifestRecord{
+            Input: result.Input,
+            Checksum: result.Checksum,
+            Format: result.Format,
+            Width: result.Size.X,
+            Height: result.Size.Y,
+            BlurHash: result.BlurHash,
+            Outputs: make(map[string]string),
+            Status: status,
+            Original: result.Original,
+        }
+        for _, o := range result.Outputs {
+            record.Outputs[o.Key()] = o.Path
+            if o.Color != "" {
+                if record.Colors == nil {
+                    record.Colors = make(map[string]string)
+                }
+                record.Colors[o.Key()] = o.Color
+            }
+            if !o.Tile.Empty() {
+                if record.Tiles == nil {
+                    record.Tiles = make(map[string]string)
+                }
+                record.Tiles[o.Key()] = tileGeometry(o.Tile)
+            }
+        }
+        m.records = append(m.records, record)
+        return
+    }
+
+    if len(result.Outputs) == 0 {
+        m.csv.Write(append(common, "", "", "", status, result.Original, "", ""))
+        return
+    }
+
+    for _, o := range result.Outputs {
+        m.csv.Write(append(common, o.Path, o.Name, strconv.FormatBool(o.Flipped), status, result.Original, o.Color, tileGeometry(o.Tile)))
+    }
+}
+
+// tileGeometry is a sprite tile as WxH+X+Y, like ImageMagick's -crop
+// takes, or empty for no tile.
+func tileGeometry(r image.Rectangle) string {
+    if r.Empty() {
+        return ""
+    }
+    return fmt.Sprintf("%dx%d+%d+%d", r.Dx(), r.Dy(), r.Min.X, r.Min.Y)
+}
+
+func (m *manifestWriter) close() error {
+    if m == nil {
+        return nil
+    }
+
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    var err error
+    if m.csv == nil {
+        encoder := json.NewEncoder(m.fp)
+        encoder.SetIndent("", "  ")
+        err = encoder.Encode(m.records)
+    } else {
+        m.csv.Flush()
+        err = m.csv.Error()
+    }
+
+    if closeErr := m.fp.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
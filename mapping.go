@@ -0,0 +1,76 @@
+package main
+
+import (
+    "encoding/csv"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+)
+
+//=============================================================================
+
+// -map replaces the walk with an explicit list of input,output pairs, one
+// per line, comma separated (or tab, for a .tsv). Each output path stands
+// in for what outputPath would mirror, so photo.jpg,thumbs/cat.png gets
+// thumbs/cat_center.png and friends. Inputs are used as given, with no -ext
+// or hidden-file filtering; ones that don't exist are reported and counted
+// as missing rather than queued. A first line of just the column names is
+// skipped.
+
+var mapPath = flag.String("map", "", "CSV (or .tsv) of `input,output` pairs to thumbnail instead of walking -i; outputs are named after each output path")
+
+// Loaded in main, in file order.
+var mappedInputs []string
+
+var mappedOutputs map[string]string
+
+func loadMapping(path string) error {
+    fp, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer fp.Close()
+
+    reader := csv.NewReader(fp)
+    if strings.HasSuffix(strings.ToLower(path), ".tsv") {
+        reader.Comma = '\t'
+    }
+    reader.FieldsPerRecord = 2
+    reader.TrimLeadingSpace = true
+
+    mappedOutputs = make(map[string]string)
+    for first := true; ; first = false {
+        record, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return err
+        }
+
+        input, output := record[0], record[1]
+        if first && strings.HasPrefix(input, "input") && strings.HasPrefix(output, "output") {
+            continue
+        }
+        line, _ := reader.FieldPos(0)
+        if input == "" || output == "" {
+            return fmt.Errorf("%s:%d: Empty path", path, line)
+        }
+        if _, found := mappedOutputs[input]; found {
+            return fmt.Errorf("%s:%d: %s is mapped twice", path, line, input)
+        }
+
+        if _, err := thumbnailer.Storage.Size(input); err != nil {
+            stats.add(&stats.missing)
+            slog.Warn("Missing", "path", input, "line", line, "err", err)
+            continue
+        }
+        mappedOutputs[input] = output
+        mappedInputs = append(mappedInputs, input)
+    }
+    return nil
+}
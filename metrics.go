@@ -0,0 +1,85 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+//=============================================================================
+
+// In debug mode, a sampler logs how full the queue between the walk and
+// the workers is, and each worker's rate. A queue that's mostly full means
+// the workers are the limit (CPU: -workers can't go much past the cores);
+// mostly empty means the inputs are (storage, or a slow S3 listing: more
+// -workers only helps if it's reads, not the walk). Workers each bump
+// their own counter, and the sampler only reads them, so this costs them
+// nothing to speak of.
+
+const metricsInterval = 5 * time.Second
+
+var queueSize = flag.Int("queue", 0, "input paths queued ahead of the workers (default 4 per worker)")
+
+// Indexed by worker.
+var workerDone []atomic.Int64
+
+// queueCapacity is -queue, or its default for -workers.
+func queueCapacity() int {
+    if *queueSize > 0 {
+        return *queueSize
+    }
+    return 4 * *nProcessors
+}
+
+// bottleneck guesses the limiting side from how full the queue is.
+func bottleneck(queued, capacity int) string {
+    switch {
+    case queued * 4 >= capacity * 3:
+        return "workers"
+    case queued * 4 <= capacity:
+        return "inputs"
+    }
+    return "neither"
+}
+
+// startMetrics samples every metricsInterval until the returned stop.
+func startMetrics() (stop func()) {
+    workerDone = make([]atomic.Int64, *nProcessors)
+    if !*verbose {
+        return func() {}
+    }
+
+    done := make(chan struct{})
+    go func() {
+        ticker := time.NewTicker(metricsInterval)
+        defer ticker.Stop()
+
+        last := make([]int64, len(workerDone))
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+            }
+
+            rates := make([]string, len(workerDone))
+            total := 0.0
+            for i := range workerDone {
+                n := workerDone[i].Load()
+                rate := float64(n - last[i]) / metricsInterval.Seconds()
+                rates[i] = fmt.Sprintf("%.1f", rate)
+                total += rate
+                last[i] = n
+            }
+
+            queued := len(filePaths)
+            slog.Debug("Throughput", "queued", queued, "capacity", cap(filePaths),
+                "bottleneck", bottleneck(queued, cap(filePaths)),
+                "files_per_sec", fmt.Sprintf("%.1f", total), "per_worker", strings.Join(rates, ","))
+        }
+    }()
+    return func() { close(done) }
+}
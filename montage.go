@@ -0,0 +1,70 @@
+package main
+
+import (
+    "github.com/jbn/thumbnailer/thumbnail"
+    "image"
+    "log/slog"
+    "sort"
+    "sync"
+)
+
+//=============================================================================
+
+// In montage mode nothing is written per input. Thumbnails are kept in
+// memory, grouped by the mirrored output directory, and each group is
+// tiled into one montage when the run ends. That's roughly 200KB per
+// 224x224 thumbnail held until then, so it suits review-sized packs more
+// than whole datasets.
+
+type montageCell struct {
+    input string
+    key   string
+    image image.Image
+}
+
+var montageMutex sync.Mutex
+
+var montageCells = make(map[string][]montageCell)
+
+func montagePath(dir string) string {
+    return thumbnail.JoinPath(dir, "montage" + thumbnail.FORMAT_EXTENSIONS[thumbnailer.Format])
+}
+
+// collectMontage files result's thumbnails under dir and points its
+// outputs at the montage they'll end up in.
+func collectMontage(dir string, result *thumbnail.Result) {
+    montageMutex.Lock()
+    defer montageMutex.Unlock()
+
+    for i, o := range result.Outputs {
+        if o.Image != nil {
+            montageCells[dir] = append(montageCells[dir], montageCell{result.Input, o.Key(), o.Image})
+        }
+        result.Outputs[i].Path = montagePath(dir)
+        result.Outputs[i].Image = nil
+    }
+}
+
+func writeMontages(cols int) {
+    for dir, cells := range montageCells {
+        // Workers finish in any order; sort so reruns tile identically.
+        sort.Slice(cells, func (i, j int) bool {
+            if cells[i].input != cells[j].input {
+                return cells[i].input < cells[j].input
+            }
+            return cells[i].key < cells[j].key
+        })
+
+        images := make([]image.Image, len(cells))
+        for i, c := range cells {
+            images[i] = c.image
+        }
+
+        path := montagePath(dir)
+        slog.Debug("Saving montage", "path", path)
+
+        if err := thumbnailer.Save(path, thumbnailer.Montage(images, cols)); err != nil {
+            slog.Error("Failed montage", "path", path, "err", err)
+        }
+    }
+}
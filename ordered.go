@@ -0,0 +1,79 @@
+package main
+
+import (
+    "flag"
+    "sync"
+)
+
+//=============================================================================
+
+// Workers finish in whatever order their files take, so by default that's
+// the order outputs land in. With -ordered they still thumbnail in
+// parallel, but each input is numbered as it's taken off the queue, and
+// its encoded outputs are held back until every input before it is
+// settled. Then they're written, logged and entered in the manifest, one
+// input at a time, in input order. What's held back is encoded files, not
+// pixels, but one slow input holds back everything behind it.
+
+var ordered = flag.Bool("ordered", false, "write outputs (and manifest rows) in input order, still thumbnailing in parallel")
+
+var receiveMutex sync.Mutex
+
+// Inputs taken off the queue so far, under receiveMutex.
+var received int
+
+// nextInput takes the next input off the queue along with its place in
+// input order, or returns false once the queue is closed and drained.
+func nextInput() (path string, index int, ok bool) {
+    receiveMutex.Lock()
+    defer receiveMutex.Unlock()
+
+    path, ok = <-filePaths
+    if !ok {
+        return "", 0, false
+    }
+    index = received
+    received += 1
+    return path, index, true
+}
+
+// A reorderBuffer runs each input's finish step in index order, whichever
+// order they're settled in.
+type reorderBuffer struct {
+    mutex    sync.Mutex
+    next     int
+    pending  map[int]func()
+    draining bool // One worker runs the steps that are ready; the rest move on.
+}
+
+var reorder = &reorderBuffer{pending: make(map[int]func())}
+
+// settle hands over input index's finish step, nil if it has none, and
+// runs every step whose turn has come.
+func (r *reorderBuffer) settle(index int, finish func()) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    r.pending[index] = finish
+    if r.draining {
+        return
+    }
+
+    r.draining = true
+    for {
+        step, found := r.pending[r.next]
+        if !found {
+            break
+        }
+        delete(r.pending, r.next)
+        r.next += 1
+
+        // Unlocked so other workers can settle theirs meanwhile.
+        if step != nil {
+            r.mutex.Unlock()
+            step()
+            r.mutex.Lock()
+        }
+    }
+    r.draining = false
+}
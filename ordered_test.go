@@ -0,0 +1,67 @@
+package main
+
+import (
+    "math/rand"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+//=============================================================================
+
+// However many workers settle inputs, in whatever order, each finish step
+// runs once, alone, in index order. Every third input has none.
+func TestReorderBuffer(t *testing.T) {
+    const inputs = 200
+    for _, workers := range []int{1, 4, 16} {
+        r := &reorderBuffer{pending: make(map[int]func())}
+        order := rand.New(rand.NewSource(int64(workers))).Perm(inputs)
+        queue := make(chan int, inputs)
+        for _, index := range order {
+            queue <- index
+        }
+        close(queue)
+
+        var ran []int
+        var running int32
+        var wg sync.WaitGroup
+        for w := 0; w < workers; w++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for index := range queue {
+                    time.Sleep(time.Duration(index % 7) * 10 * time.Microsecond)
+                    if index % 3 == 2 {
+                        r.settle(index, nil)
+                        continue
+                    }
+                    r.settle(index, func() {
+                        if atomic.AddInt32(&running, 1) != 1 {
+                            t.Errorf("%d workers: steps overlapped at %d", workers, index)
+                        }
+                        // Long enough that another worker would get in.
+                        time.Sleep(20 * time.Microsecond)
+                        ran = append(ran, index)
+                        atomic.AddInt32(&running, -1)
+                    })
+                }
+            }()
+        }
+        wg.Wait()
+
+        var want []int
+        for i := 0; i < inputs; i++ {
+            if i % 3 != 2 {
+                want = append(want, i)
+            }
+        }
+        if !reflect.DeepEqual(ran, want) {
+            t.Errorf("%d workers: ran %v", workers, ran)
+        }
+        if len(r.pending) != 0 || r.next != inputs {
+            t.Errorf("%d workers: %d left pending, next %d", workers, len(r.pending), r.next)
+        }
+    }
+}
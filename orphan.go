@@ -0,0 +1,60 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "path/filepath"
+    "sync"
+)
+
+//=============================================================================
+
+// An input with no place under -i (a -stdin path from elsewhere, or a
+// local one in an S3 run) has nowhere to go in the mirrored tree, and is
+// skipped. With -orphan-dir it's thumbnailed into that directory instead,
+// under its own name, or with -2, -3 and so on after the stem if another
+// orphan had it first; its manifest status is orphaned. Which of two
+// orphans with one name gets it plain is whichever a worker reaches first,
+// so that can differ between runs even without -s.
+
+var orphanDir = flag.String("orphan-dir", "", "thumbnail inputs from outside -i into this directory (or s3://bucket/prefix), named after them, instead of skipping them")
+
+var orphanMutex sync.Mutex
+
+// Where each orphan went, so asking again (as -dup-action link does for
+// originals) gets the same place, and the stems already taken there.
+var orphanPaths = make(map[string]string)
+
+var orphanStems = make(map[string]bool)
+
+// orphanPath is where inputPath's outputs go in -orphan-dir.
+func orphanPath(inputPath string) string {
+    orphanMutex.Lock()
+    defer orphanMutex.Unlock()
+
+    if path, found := orphanPaths[inputPath]; found {
+        return path
+    }
+
+    name := filepath.Base(inputPath)
+    stem := thumbnail.OutputStem(name)
+    rest := name[len(stem):]
+    unique := stem
+    for n := 2; orphanStems[unique]; n++ {
+        unique = fmt.Sprintf("%s-%d", stem, n)
+    }
+    orphanStems[unique] = true
+
+    path := thumbnail.JoinPath(*orphanDir, unique + rest)
+    orphanPaths[inputPath] = path
+    return path
+}
+
+// isOrphan reports whether inputPath was put in -orphan-dir.
+func isOrphan(inputPath string) bool {
+    orphanMutex.Lock()
+    defer orphanMutex.Unlock()
+    _, found := orphanPaths[inputPath]
+    return found
+}
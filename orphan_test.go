@@ -0,0 +1,75 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+// Inputs outside -i go in -orphan-dir under their own names, a clash
+// suffixed, and each keeps its place when asked again.
+func TestOrphanPath(t *testing.T) {
+    dir := t.TempDir()
+    setFlag(t, inputDir, filepath.Join(dir, "in"))
+    setFlag(t, outputDir, filepath.Join(dir, "out"))
+    setFlag(t, &orphanPaths, make(map[string]string))
+    setFlag(t, &orphanStems, make(map[string]bool))
+    outside := []string{filepath.Join(dir, "x/a.jpg"), filepath.Join(dir, "y/a.png"), filepath.Join(dir, "z/a.jpg")}
+
+    if _, err := outputPath(outside[0]); !errors.Is(err, errOutside) {
+        t.Fatalf("Without -orphan-dir: got %v, want errOutside", err)
+    }
+
+    orphans := filepath.Join(dir, "orphans")
+    setFlag(t, orphanDir, orphans)
+    want := []string{"a.jpg", "a-2.png", "a-3.jpg"}
+    for round := 0; round < 2; round++ {
+        for i, input := range outside {
+            got, err := outputPath(input)
+            if err != nil || got != filepath.Join(orphans, want[i]) {
+                t.Errorf("%s: got %q, %v, want %s", input, got, err, want[i])
+            }
+            if !isOrphan(input) {
+                t.Errorf("%s isn't an orphan", input)
+            }
+        }
+    }
+    if isOrphan(filepath.Join(dir, "in/a.jpg")) {
+        t.Error("An input under -i is an orphan")
+    }
+}
+
+// Unmappable inputs are thumbnailed into -orphan-dir rather than skipped.
+func TestOrphanThumbnailed(t *testing.T) {
+    dir := t.TempDir()
+    writeFile(t, dir, "in/a.png", pngImage(t))
+    outside := []string{writeFile(t, dir, "x/a.png", pngImage(t)), writeFile(t, dir, "y/a.png", pngImage(t))}
+    orphans := filepath.Join(dir, "orphans")
+    setFlag(t, inputDir, filepath.Join(dir, "in"))
+    setFlag(t, outputDir, filepath.Join(dir, "out"))
+    setFlag(t, orphanDir, orphans)
+    setFlag(t, &orphanPaths, make(map[string]string))
+    setFlag(t, &orphanStems, make(map[string]bool))
+    setFlag(t, deduplicate, false)
+    setFlag(t, single, true)
+
+    if got := run(t); !reflect.DeepEqual(got, []string{"a.png"}) {
+        t.Errorf("Under -o: got %v", got)
+    }
+    ctx := context.Background()
+    for _, input := range outside {
+        if finish := processPath(ctx, input); finish != nil {
+            finish()
+        }
+    }
+    for _, name := range []string{"a.png", "a-2.png"} {
+        if _, err := os.Stat(filepath.Join(orphans, name)); err != nil {
+            t.Errorf("Not in -orphan-dir: %v", err)
+        }
+    }
+}
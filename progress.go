@@ -0,0 +1,151 @@
+package main
+
+import (
+    "fmt"
+    "gopkg.in/cheggaaa/pb.v1"
+    "log/slog"
+    "math"
+    "sync"
+    "time"
+)
+
+//=============================================================================
+
+// Quiet runs get a pb bar, or just a running count when the total isn't
+// known up front (streaming with -stdin or -count=false). Verbose runs
+// already log a line per file, which would shred a bar, so they get a
+// periodic progress record instead. Either way there's a rate, and an ETA
+// if the total is known.
+//
+// Inputs take wildly different times (a 50MP TIFF against a 200px GIF), so
+// the rate is over the last minute rather than the whole run, which on a
+// long one would answer mostly for how it started. pb's own speed and time
+// left are lifetime averages, so the bar shows these in its postfix.
+
+const progressInterval = 5 * time.Second
+
+const rateWindow = time.Minute
+
+type progressReporter struct {
+    mutex      sync.Mutex
+    bar        *pb.ProgressBar // Nil in verbose mode.
+    total      int             // Zero if unknown.
+    processed  int
+    lastReport time.Time
+    rate       rateMeter
+}
+
+// rateMeter counts inputs done per second, for the seconds in rateWindow.
+type rateMeter struct {
+    start   time.Time
+    counts  [int(rateWindow / time.Second)]int
+    seconds [int(rateWindow / time.Second)]int64 // The Unix second each count is for.
+}
+
+func (m *rateMeter) add(now time.Time) {
+    second := now.Unix()
+    i := second % int64(len(m.counts))
+    if m.seconds[i] != second {
+        m.seconds[i], m.counts[i] = second, 0
+    }
+    m.counts[i] += 1
+}
+
+// perSecond is the rate over the window, or the run so far while it's
+// shorter.
+func (m *rateMeter) perSecond(now time.Time) float64 {
+    span := min(now.Sub(m.start), rateWindow)
+    if span < time.Second {
+        return 0
+    }
+
+    done := 0
+    for i, second := range m.seconds {
+        if now.Unix() - second < int64(len(m.counts)) {
+            done += m.counts[i]
+        }
+    }
+    return float64(done) / span.Seconds()
+}
+
+// Started in produceInputs, once the total is known (or known not to be).
+var progress *progressReporter
+
+func startProgress(total int) *progressReporter {
+    now := time.Now()
+    p := &progressReporter{total: total, lastReport: now, rate: rateMeter{start: now}}
+    if !*verbose {
+        p.bar = pb.New(total)
+        p.bar.ShowSpeed = false
+        p.bar.ShowTimeLeft = false
+        p.bar.SetUnits(pb.U_NO).Start()
+    }
+    return p
+}
+
+func (p *progressReporter) increment() {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    now := time.Now()
+    p.processed += 1
+    p.rate.add(now)
+
+    if p.bar != nil {
+        rate, eta := p.estimate(now)
+        if eta >= 0 {
+            p.bar.Postfix(fmt.Sprintf(" %.1f/s ETA %s", rate, eta))
+        } else {
+            p.bar.Postfix(fmt.Sprintf(" %.1f/s", rate))
+        }
+        p.bar.Increment()
+        return
+    }
+
+    if now.Sub(p.lastReport) >= progressInterval {
+        p.report()
+        p.lastReport = time.Now()
+    }
+}
+
+// estimate is the current rate per second and the time left at it, which
+// is negative if there's no telling. It expects p.mutex to be held.
+func (p *progressReporter) estimate(now time.Time) (float64, time.Duration) {
+    rate := p.rate.perSecond(now)
+    if p.total <= 0 || rate == 0 {
+        return rate, -1
+    }
+    left := float64(max(p.total - p.processed, 0)) / rate
+    return rate, time.Duration(left * float64(time.Second)).Round(time.Second)
+}
+
+// report expects p.mutex to be held.
+func (p *progressReporter) report() {
+    stats.mutex.Lock()
+    failed := stats.failed
+    stats.mutex.Unlock()
+
+    rate, eta := p.estimate(time.Now())
+    rate = math.Round(rate * 10) / 10
+    if p.total > 0 && eta >= 0 {
+        slog.Info("Progress", "processed", p.processed, "total", p.total, "failed", failed, "rate", rate, "eta", eta)
+    } else if p.total > 0 {
+        slog.Info("Progress", "processed", p.processed, "total", p.total, "failed", failed, "rate", rate)
+    } else {
+        slog.Info("Progress", "processed", p.processed, "failed", failed, "rate", rate)
+    }
+}
+
+func (p *progressReporter) finish() {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    if p.bar != nil {
+        // No time left to speak of.
+        rate, _ := p.estimate(time.Now())
+        p.bar.Postfix(fmt.Sprintf(" %.1f/s", rate))
+        p.bar.Finish()
+        return
+    }
+    p.report()
+}
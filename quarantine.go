@@ -0,0 +1,70 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "log/slog"
+    "os"
+    "path/filepath"
+)
+
+//=============================================================================
+
+// Corrupt inputs (mostly partial downloads) are counted on their own, and
+// with -quarantine moved out of the dataset, so the same pass that
+// thumbnails it also cleans it. They keep their place under -i, which is
+// where they go back once they're downloaded again.
+
+var quarantineDir = flag.String("quarantine", "", "move corrupt inputs into this directory (or s3://bucket/prefix), mirroring -i")
+
+// quarantinePath is where inputPath is moved to. Inputs from outside -i
+// (only -stdin has those) go in at the top.
+func quarantinePath(inputPath string) string {
+    rel, err := relativePath(*inputDir, inputPath)
+    if err != nil {
+        rel = filepath.Base(inputPath)
+    }
+    return thumbnail.JoinPath(*quarantineDir, rel)
+}
+
+func quarantine(inputPath string) {
+    dest := quarantinePath(inputPath)
+    if *dryRun {
+        slog.Info("Would quarantine", "path", inputPath, "to", dest)
+        return
+    }
+
+    if err := moveFile(inputPath, dest); err != nil {
+        slog.Error("Failed quarantine", "path", inputPath, "err", err)
+        return
+    }
+    slog.Info("Quarantined", "path", inputPath, "to", dest)
+}
+
+// moveFile renames src to dst when both are local, or else copies it
+// through the thumbnailer's storage and removes the original.
+func moveFile(src, dst string) error {
+    storage := thumbnailer.Storage
+    if err := storage.MkdirAll(thumbnail.DirPath(dst)); err != nil {
+        return err
+    }
+
+    if !isS3URI(src) && !isS3URI(dst) {
+        err := os.Rename(src, dst)
+        var linkErr *os.LinkError
+        if !errors.As(err, &linkErr) {
+            return err // Including nil
+        }
+        // Most likely across devices; copy instead.
+    }
+
+    data, err := storage.ReadFile(src)
+    if err != nil {
+        return err
+    }
+    if err := storage.WriteFile(dst, data); err != nil {
+        return err
+    }
+    return storage.Remove(src)
+}
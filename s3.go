@@ -0,0 +1,421 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "io"
+    "io/fs"
+    "mime"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+//=============================================================================
+
+// -i and -o also take s3://bucket/prefix URIs. Rather than pull in the AWS
+// SDK for four calls, this is a small SigV4 client over net/http.
+//
+// Credentials and region come from the usual AWS_* environment variables,
+// falling back to the shared credentials and config files (honoring
+// AWS_PROFILE). Instance roles aren't supported. AWS_ENDPOINT_URL points
+// it at an S3-compatible service such as MinIO, addressed path-style.
+
+func isS3URI(p string) bool {
+    return strings.HasPrefix(p, "s3://")
+}
+
+func parseS3URI(uri string) (bucket, key string) {
+    bucket, key, _ = strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+    return bucket, key
+}
+
+type s3Client struct {
+    region       string
+    accessKey    string
+    secretKey    string
+    sessionToken string
+    endpoint     *url.URL // Nil for AWS proper.
+}
+
+var s3Once sync.Once
+var s3Shared *s3Client
+var s3Err error
+
+// getS3Client loads credentials on first use, so local-only runs never
+// look for them.
+func getS3Client() (*s3Client, error) {
+    s3Once.Do(func() {
+        s3Shared, s3Err = newS3Client()
+    })
+    return s3Shared, s3Err
+}
+
+func envOr(name, fallback string) string {
+    if v := os.Getenv(name); v != "" {
+        return v
+    }
+    return fallback
+}
+
+// readINISection returns the key = value pairs under [section] in path, or
+// nothing if either is missing.
+func readINISection(path, section string) map[string]string {
+    values := make(map[string]string)
+
+    fp, err := os.Open(path)
+    if err != nil {
+        return values
+    }
+    defer fp.Close()
+
+    inSection := false
+    scanner := bufio.NewScanner(fp)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' || line[0] == ';' {
+            continue
+        }
+        if line[0] == '[' {
+            inSection = strings.TrimSpace(strings.Trim(line, "[]")) == section
+            continue
+        }
+        if k, v, found := strings.Cut(line, "="); inSection && found {
+            values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+        }
+    }
+
+    return values
+}
+
+func newS3Client() (*s3Client, error) {
+    c := &s3Client{
+        region: envOr("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION")),
+        accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+        secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+        sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+    }
+
+    home, _ := os.UserHomeDir()
+    profile := envOr("AWS_PROFILE", "default")
+
+    if c.accessKey == "" {
+        path := envOr("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"))
+        creds := readINISection(path, profile)
+        c.accessKey = creds["aws_access_key_id"]
+        c.secretKey = creds["aws_secret_access_key"]
+        c.sessionToken = creds["aws_session_token"]
+    }
+    if c.accessKey == "" || c.secretKey == "" {
+        return nil, errors.New("No AWS credentials in the environment or shared credentials file")
+    }
+
+    if c.region == "" {
+        section := profile
+        if profile != "default" {
+            section = "profile " + profile
+        }
+        path := envOr("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"))
+        c.region = readINISection(path, section)["region"]
+    }
+    if c.region == "" {
+        c.region = "us-east-1"
+    }
+
+    if raw := envOr("AWS_ENDPOINT_URL_S3", os.Getenv("AWS_ENDPOINT_URL")); raw != "" {
+        endpoint, err := url.Parse(strings.TrimSuffix(raw, "/"))
+        if err != nil {
+            return nil, fmt.Errorf("Bad AWS endpoint %q: %v", raw, err)
+        }
+        c.endpoint = endpoint
+    }
+
+    return c, nil
+}
+
+//=============================================================================
+
+// awsEscape is SigV4's URI encoding: everything but unreserved characters
+// (and, in paths, slashes) is percent-encoded.
+func awsEscape(s string, isPath bool) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        unreserved := 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+            c == '-' || c == '_' || c == '.' || c == '~'
+        if unreserved || isPath && c == '/' {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "%%%02X", c)
+        }
+    }
+    return b.String()
+}
+
+func sha256Hex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+// do sends a signed request and returns the response, body read, or an
+// error for any non-2xx status. A 404 wraps fs.ErrNotExist.
+func (c *s3Client) do(method, bucket, key string, query map[string]string, body []byte) (*http.Response, []byte, error) {
+    return c.send(method, bucket, key, query, nil, body)
+}
+
+// send is do with extra headers, which go unsigned, as SigV4 allows for
+// anything but Host and x-amz-*.
+func (c *s3Client) send(method, bucket, key string, query, header map[string]string, body []byte) (*http.Response, []byte, error) {
+    host := "s3." + c.region + ".amazonaws.com"
+    scheme := "https"
+    uriPath := "/" + bucket + "/" + key
+    if c.endpoint != nil {
+        host, scheme = c.endpoint.Host, c.endpoint.Scheme
+        uriPath = strings.TrimSuffix(c.endpoint.Path, "/") + uriPath
+    } else {
+        host = bucket + "." + host
+        uriPath = "/" + key
+    }
+    canonicalURI := awsEscape(uriPath, true)
+
+    var params []string
+    for k, v := range query {
+        params = append(params, awsEscape(k, false) + "=" + awsEscape(v, false))
+    }
+    sort.Strings(params)
+    canonicalQuery := strings.Join(params, "&")
+
+    rawURL := scheme + "://" + host + canonicalURI
+    if canonicalQuery != "" {
+        rawURL += "?" + canonicalQuery
+    }
+    req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+    if err != nil {
+        return nil, nil, err
+    }
+
+    now := time.Now().UTC()
+    amzDate := now.Format("20060102T150405Z")
+    date := amzDate[:8]
+    payloadHash := sha256Hex(body)
+
+    req.Header.Set("x-amz-date", amzDate)
+    req.Header.Set("x-amz-content-sha256", payloadHash)
+    headers := []string{"host:" + host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + amzDate}
+    signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+    if c.sessionToken != "" {
+        req.Header.Set("x-amz-security-token", c.sessionToken)
+        headers = append(headers, "x-amz-security-token:" + c.sessionToken)
+        signedHeaders += ";x-amz-security-token"
+    }
+    if method == http.MethodPut {
+        req.Header.Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+    }
+    for k, v := range header {
+        req.Header.Set(k, v)
+    }
+
+    canonical := strings.Join([]string{
+        method, canonicalURI, canonicalQuery, strings.Join(headers, "\n") + "\n", signedHeaders, payloadHash,
+    }, "\n")
+    scope := date + "/" + c.region + "/s3/aws4_request"
+    toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+    signingKey := hmacSHA256([]byte("AWS4" + c.secretKey), date)
+    for _, part := range []string{c.region, "s3", "aws4_request"} {
+        signingKey = hmacSHA256(signingKey, part)
+    }
+    signature := hex.EncodeToString(hmacSHA256(signingKey, toSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        c.accessKey, scope, signedHeaders, signature))
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    uri := "s3://" + bucket + "/" + key
+    switch {
+    case resp.StatusCode == http.StatusNotFound:
+        return nil, nil, fmt.Errorf("%s %s: %w", method, uri, fs.ErrNotExist)
+    case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+        return nil, nil, fmt.Errorf("%s %s: %s (%w)", method, uri, resp.Status, thumbnail.ErrTransient)
+    case resp.StatusCode >= 300:
+        return nil, nil, fmt.Errorf("%s %s: %s", method, uri, resp.Status)
+    }
+    return resp, data, nil
+}
+
+type s3ListResult struct {
+    Contents []struct {
+        Key          string
+        Size         int64
+        LastModified time.Time
+    }
+    IsTruncated           bool
+    NextContinuationToken string
+}
+
+// list calls visit for every object under prefix until visit returns
+// false.
+func (c *s3Client) list(bucket, prefix string, visit func (key string, size int64, modified time.Time) bool) error {
+    query := map[string]string{"list-type": "2", "prefix": prefix}
+
+    for {
+        _, data, err := c.do(http.MethodGet, bucket, "", query, nil)
+        if err != nil {
+            return err
+        }
+
+        var page s3ListResult
+        if err := xml.Unmarshal(data, &page); err != nil {
+            return err
+        }
+        for _, object := range page.Contents {
+            if !visit(object.Key, object.Size, object.LastModified) {
+                return nil
+            }
+        }
+
+        if !page.IsTruncated {
+            return nil
+        }
+        query["continuation-token"] = page.NextContinuationToken
+    }
+}
+
+// walkS3 is walkInputs' S3 flavor.
+func walkS3(uri string, visit func (path string) bool) error {
+    c, err := getS3Client()
+    if err != nil {
+        return err
+    }
+
+    bucket, prefix := parseS3URI(uri)
+    if prefix != "" && !strings.HasSuffix(prefix, "/") {
+        prefix += "/" // s3://b/pack shouldn't match s3://b/pack2.
+    }
+
+    return c.list(bucket, prefix, func (key string, size int64, modified time.Time) bool {
+        // Keys ending in / are the console's folder markers.
+        if strings.HasSuffix(key, "/") || !isImagePath(key, size) || !modifiedSince(modified) {
+            return true
+        }
+        rel := strings.TrimPrefix(key, prefix)
+        if beyondMaxDepth(path.Dir(rel)) || !globSelected(rel) {
+            return true
+        }
+        return visit("s3://" + bucket + "/" + key)
+    })
+}
+
+//=============================================================================
+
+// uriStorage sends s3:// paths to S3 and everything else to disk.
+type uriStorage struct {
+    thumbnail.LocalStorage
+}
+
+func (s uriStorage) ReadFile(path string) ([]byte, error) {
+    if !isS3URI(path) {
+        return s.LocalStorage.ReadFile(path)
+    }
+    c, err := getS3Client()
+    if err != nil {
+        return nil, err
+    }
+    bucket, key := parseS3URI(path)
+    _, data, err := c.do(http.MethodGet, bucket, key, nil, nil)
+    return data, err
+}
+
+func (s uriStorage) ReadRange(path string, offset int64, n int) ([]byte, error) {
+    if !isS3URI(path) {
+        return s.LocalStorage.ReadRange(path, offset, n)
+    }
+    c, err := getS3Client()
+    if err != nil {
+        return nil, err
+    }
+    bucket, key := parseS3URI(path)
+    header := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", offset, offset + int64(n) - 1)}
+    _, data, err := c.send(http.MethodGet, bucket, key, nil, header, nil)
+    return data, err
+}
+
+// An S3 PUT is atomic, so there's never a partial object to clean up.
+func (s uriStorage) WriteFile(path string, data []byte) error {
+    if !isS3URI(path) {
+        return s.LocalStorage.WriteFile(path, data)
+    }
+    c, err := getS3Client()
+    if err != nil {
+        return err
+    }
+    bucket, key := parseS3URI(path)
+    _, _, err = c.do(http.MethodPut, bucket, key, nil, data)
+    return err
+}
+
+func (s uriStorage) Size(path string) (int64, error) {
+    if !isS3URI(path) {
+        return s.LocalStorage.Size(path)
+    }
+    c, err := getS3Client()
+    if err != nil {
+        return 0, err
+    }
+    bucket, key := parseS3URI(path)
+    resp, _, err := c.do(http.MethodHead, bucket, key, nil, nil)
+    if err != nil {
+        return 0, err
+    }
+    return resp.ContentLength, nil
+}
+
+func (s uriStorage) Remove(path string) error {
+    if !isS3URI(path) {
+        return s.LocalStorage.Remove(path)
+    }
+    c, err := getS3Client()
+    if err != nil {
+        return err
+    }
+    bucket, key := parseS3URI(path)
+    _, _, err = c.do(http.MethodDelete, bucket, key, nil, nil)
+    return err
+}
+
+// S3 has no directories to make.
+func (s uriStorage) MkdirAll(dir string) error {
+    if !isS3URI(dir) {
+        return s.LocalStorage.MkdirAll(dir)
+    }
+    return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "image"
+    "io"
+    "log/slog"
+    "mime"
+    "net/http"
+    "strconv"
+)
+
+//=============================================================================
+
+// With -serve, the tool thumbnails uploads instead of walking -i:
+//
+//     curl --data-binary @photo.jpg 'localhost:8080/thumbnail?w=128&h=128&anchor=top'
+//
+// Every other flag still applies, except that each request yields exactly
+// one thumbnail: the requested anchor, unflipped. Nothing touches disk and
+// nothing is deduplicated.
+
+var serveAddr = flag.String("serve", "", "serve POST /thumbnail on this address (e.g. :8080) instead of running a batch")
+
+const maxUploadBytes = 64 << 20
+
+// Bigger than anyone needs a thumbnail, small enough not to be a DoS.
+const maxServeDim = 4096
+
+// Holds a token per request being thumbnailed; sized to -workers.
+var serveSlots chan struct{}
+
+func serve(addr string) error {
+    serveSlots = make(chan struct{}, *nProcessors)
+
+    http.HandleFunc("/thumbnail", handleThumbnail)
+    slog.Info("Serving", "addr", addr)
+    return http.ListenAndServe(addr, nil)
+}
+
+func queryDim(r *http.Request, name string, fallback int) (int, error) {
+    raw := r.URL.Query().Get(name)
+    if raw == "" {
+        return fallback, nil
+    }
+
+    v, err := strconv.Atoi(raw)
+    if err != nil || v < 1 || v > maxServeDim {
+        return 0, fmt.Errorf("%s %q out of range, expected 1-%d", name, raw, maxServeDim)
+    }
+    return v, nil
+}
+
+// requestThumbnailer is the flag-built Thumbnailer narrowed to what r asks
+// for. A fresh one per request keeps requests from seeing each other.
+func requestThumbnailer(r *http.Request) (*thumbnail.Thumbnailer, error) {
+    t, err := newThumbnailer()
+    if err != nil {
+        return nil, err
+    }
+
+    if t.Dim[0], err = queryDim(r, "w", thumbDim[0]); err != nil {
+        return nil, err
+    }
+    if t.Dim[1], err = queryDim(r, "h", thumbDim[1]); err != nil {
+        return nil, err
+    }
+
+    name := r.URL.Query().Get("anchor")
+    if name == "" {
+        name = "center"
+    }
+    if t.Anchors, err = thumbnail.ParseAnchors(name); err != nil {
+        return nil, err
+    }
+    if len(t.Anchors) != 1 {
+        return nil, fmt.Errorf("Expected a single anchor, got %q", name)
+    }
+
+    t.Flip = false
+    t.FlipVertical = false
+    t.Deduplicate = false
+    t.Log = nil
+    return t, nil
+}
+
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+        return
+    }
+
+    t, err := requestThumbnailer(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadBytes))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    select {
+    case serveSlots <- struct{}{}:
+        defer func() { <-serveSlots }()
+    case <-r.Context().Done():
+        return
+    }
+
+    img, err := t.Decode(data)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+        return
+    }
+
+    size := img.Bounds().Size()
+    if !t.AllowUpscale && t.Undersized(size) {
+        msg := fmt.Sprintf("Image is %dx%d, %v", size.X, size.Y, thumbnail.ErrUndersized)
+        http.Error(w, msg, http.StatusUnprocessableEntity)
+        return
+    }
+
+    // Unflipped and single-anchored, so there's exactly one.
+    thumbs := t.Thumbnail(img)
+    defer t.Release(thumbs)
+    var thumb image.Image
+    for _, thumb = range thumbs {
+        break
+    }
+
+    var buf bytes.Buffer
+    if err := t.Encode(&buf, thumb); err != nil {
+        slog.Error("Encoding thumbnail", "err", err)
+        http.Error(w, "Encoding failed", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", mime.TypeByExtension(thumbnail.FORMAT_EXTENSIONS[t.Format]))
+    w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+    w.Write(buf.Bytes())
+
+    slog.Debug("Served",
+        "thumbnail", fmt.Sprintf("%dx%d", t.Dim[0], t.Dim[1]),
+        "upload", fmt.Sprintf("%dx%d", size.X, size.Y))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "path/filepath"
+    "strconv"
+)
+
+//=============================================================================
+
+// With -shard, outputs are spread over N subdirectories of -o, named in
+// hex (00-ff for 256), picked by the source's checksum. That's a function
+// of the bytes alone, so a rerun lands every input in the same shard and
+// -skip-existing still finds it, however -i was walked. The shard goes in
+// front of the mirrored path, so each shard holds its own copy of -i's
+// tree, with only its files in it; with -flat, the shards are the only
+// directories. Inputs that aren't mirrored (-map, -inplace, -orphan-dir)
+// aren't sharded. The shard is picked inside ProcessAs, from the read it
+// decodes, so nothing is read twice; but it takes the whole checksum, so
+// -skip-existing reads skipped inputs whole, whatever -quick-hash says.
+
+const maxShards = 65536
+
+var shards = flag.Int("shard", 0, "spread outputs over this many subdirectories of -o by source checksum, e.g. 256 for 00-ff, each holding its part of the mirrored tree: -o/3f/sub/photo_center.png (0 means off)")
+
+func validShards(n int) error {
+    if n < 0 || n > maxShards {
+        return fmt.Errorf("Shard %d out of range, expected 0-%d", n, maxShards)
+    }
+    return nil
+}
+
+// shardName is the shard directory for a checksum, zero-padded to the
+// width of the last shard's name so they sort. Checksums are hex, as
+// every HASHES digest is.
+func shardName(checksum string, n int) string {
+    if len(checksum) > 8 {
+        checksum = checksum[:8]
+    }
+    v, _ := strconv.ParseUint(checksum, 16, 32)
+
+    width := len(strconv.FormatInt(int64(n - 1), 16))
+    return fmt.Sprintf("%0*x", width, v % uint64(n))
+}
+
+// shardOutput is the Thumbnailer's OutputDir under -shard: dir, a mirrored
+// directory under -o, moved into checksum's shard.
+func shardOutput(inputPath, dir, checksum string) string {
+    if _, mapped := mappedOutputs[inputPath]; mapped || *inPlace || isOrphan(inputPath) {
+        return dir
+    }
+    // relativePath is for files; -o itself would come back as its name.
+    root := thumbnail.JoinPath(*outputDir)
+    shard := thumbnail.JoinPath(root, shardName(checksum, *shards))
+    if dir == root {
+        return shard
+    }
+    rel, err := relativePath(root, dir)
+    if err != nil {
+        return dir
+    }
+    return thumbnail.JoinPath(shard, rel)
+}
+
+// shardFile is shardOutput for an output file.
+func shardFile(inputPath, outputFile, checksum string) string {
+    return thumbnail.JoinPath(shardOutput(inputPath, thumbnail.DirPath(outputFile), checksum), filepath.Base(outputFile))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+func TestShardName(t *testing.T) {
+    tests := []struct {
+        checksum string
+        n        int
+        want     string
+    }{
+        {"0000002a", 256, "2a"},
+        {"0000012a", 256, "2a"},
+        {"deadbeefcafe", 16, "f"},
+        {"0000002a", 4096, "02a"},
+    }
+    for _, test := range tests {
+        if got := shardName(test.checksum, test.n); got != test.want {
+            t.Errorf("%s in %d: got %s, want %s", test.checksum, test.n, got, test.want)
+        }
+    }
+}
+
+// Each input's outputs go in its checksum's shard, the mirrored tree under
+// that; ones mapped elsewhere stay where they're put.
+func TestShardOutput(t *testing.T) {
+    dir := t.TempDir()
+    out := filepath.Join(dir, "out")
+    setFlag(t, outputDir, out + "/")
+    setFlag(t, shards, 256)
+    setFlag(t, &mappedOutputs, map[string]string{"mapped.png": filepath.Join(out, "m.png")})
+
+    tests := []struct {
+        input, dir, want string
+    }{
+        {"a.png", out, "out/2a"},
+        {"x/b.png", filepath.Join(out, "x", "y"), "out/2a/x/y"},
+        {"mapped.png", out, "out"},
+        {"s3.png", "s3://bucket/elsewhere", "s3://bucket/elsewhere"},
+    }
+    for _, test := range tests {
+        want := test.want
+        if want[:2] != "s3" {
+            want = filepath.Join(dir, filepath.FromSlash(want))
+        }
+        if got := shardOutput(test.input, test.dir, "0000002a"); got != want {
+            t.Errorf("%s in %s: got %s, want %s", test.input, test.dir, got, want)
+        }
+    }
+}
+
+// A run puts every output in its source's shard, and a rerun with
+// -skip-existing finds them all there.
+func TestShardRun(t *testing.T) {
+    dir := t.TempDir()
+    in := filepath.Join(dir, "in")
+    writeFile(t, in, "a.png", pngImage(t))
+    writeFile(t, in, "x/b.png", pngImage(t))
+    setFlag(t, inputDir, in)
+    setFlag(t, outputDir, filepath.Join(dir, "out"))
+    setFlag(t, shards, 16)
+    setFlag(t, single, true)
+    setFlag(t, deduplicate, false)
+
+    got := run(t)
+    checksum, err := thumbnailer.Checksum(filepath.Join(in, "a.png"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    shard := shardName(checksum, 16)
+    want := []string{shard + "/a.png", shard + "/x/b.png"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("Got %v, want %v", got, want)
+    }
+
+    setFlag(t, skipExisting, true)
+    existing := stats.existing
+    if got := run(t); !reflect.DeepEqual(got, want) {
+        t.Errorf("Rerun: got %v, want %v", got, want)
+    }
+    if stats.existing - existing != 2 {
+        t.Errorf("Rerun skipped %d as existing, want 2", stats.existing - existing)
+    }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "log/slog"
+)
+
+//=============================================================================
+
+// With -sidecar, every written thumbnail gets a <thumb>.json next to it
+// recording where it came from. Unlike the manifest, it travels with the
+// thumbnail when that's copied around on its own. A -sprite gets just the
+// one, listing its tiles.
+
+var writeSidecars = flag.Bool("sidecar", false, "write a JSON provenance file next to each thumbnail")
+
+type sidecar struct {
+    Source   string       `json:"source"`
+    Format   string       `json:"format"`
+    Width    int          `json:"width"`
+    Height   int          `json:"height"`
+    BlurHash string       `json:"blurhash,omitempty"`
+    Checksum string       `json:"checksum"`
+    Anchor   string       `json:"anchor"`
+    Flipped  bool         `json:"flipped"`
+    Vertical bool         `json:"vertical,omitempty"` // Flipped top to bottom.
+    Resample string       `json:"resample"`
+    Color    string       `json:"dominant_color,omitempty"`
+    Tiles    []spriteTile `json:"tiles,omitempty"`
+}
+
+type spriteTile struct {
+    Anchor   string `json:"anchor"`
+    Flipped  bool   `json:"flipped"`
+    Vertical bool   `json:"vertical,omitempty"`
+    Tile     string `json:"tile"` // WxH+X+Y.
+    Color    string `json:"dominant_color,omitempty"`
+}
+
+func writeSidecarFiles(result *thumbnail.Result) {
+    for i, o := range result.Outputs {
+        // A sprite's other tiles, already listed.
+        if i > 0 && o.Path == result.Outputs[i - 1].Path {
+            continue
+        }
+
+        var tiles []spriteTile
+        for _, tile := range result.Outputs[i:] {
+            if tile.Tile.Empty() || tile.Path != o.Path {
+                break
+            }
+            tiles = append(tiles, spriteTile{tile.Name, tile.Flipped, tile.Vertical, tileGeometry(tile.Tile), tile.Color})
+        }
+        if tiles != nil {
+            o.Name, o.Flipped, o.Vertical, o.Color = "sprite", false, false, ""
+        }
+
+        data, err := json.MarshalIndent(sidecar{
+            Source: result.Input,
+            Format: result.Format,
+            Width: result.Size.X,
+            Height: result.Size.Y,
+            BlurHash: result.BlurHash,
+            Checksum: result.Checksum,
+            Anchor: o.Name,
+            Flipped: o.Flipped,
+            Vertical: o.Vertical,
+            Resample: thumbnailer.Resample,
+            Color: o.Color,
+            Tiles: tiles,
+        }, "", "  ")
+
+        path := o.Path + ".json"
+        if err == nil {
+            // Atomic like the thumbnails, locally; S3 PUTs are anyway.
+            err = thumbnailer.Storage.WriteFile(path, append(data, '\n'))
+        }
+        if err != nil {
+            slog.Error("Failed sidecar", "path", path, "err", err)
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "time"
+)
+
+//=============================================================================
+
+// -since limits the walk to files modified at or after a point in time,
+// given outright or as a duration back from the start of the run. Paths
+// from -stdin or -map are taken as given like always. With -skip-existing
+// it makes reruns over a growing dataset cheap: old files aren't even
+// queued, and ones changed since are redone, if their thumbnails are gone.
+// It goes by the files' own mtimes (S3's LastModified), so a copy that
+// didn't preserve them looks brand new.
+
+var sinceSpec = flag.String("since", "", "only walk inputs modified since this: an RFC 3339 time, a date like 2024-05-01, or a duration back from now like 24h")
+
+// Parsed in main; zero means everything.
+var sinceTime time.Time
+
+// Layouts -since takes besides durations, tried in order. The ones without
+// a zone are local time.
+var SINCE_LAYOUTS = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseSince(spec string, now time.Time) (time.Time, error) {
+    if d, err := time.ParseDuration(spec); err == nil {
+        if d < 0 {
+            return time.Time{}, fmt.Errorf("Since %s out of range, expected a duration of 0 or more", spec)
+        }
+        return now.Add(-d), nil
+    }
+    for _, layout := range SINCE_LAYOUTS {
+        if t, err := time.ParseInLocation(layout, spec, time.Local); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("Bad -since %q, expected a time like 2024-05-01T12:00:00Z or 2024-05-01, or a duration like 24h", spec)
+}
+
+// modifiedSince reports whether a file modified at mod passes -since.
+func modifiedSince(mod time.Time) bool {
+    return sinceTime.IsZero() || !mod.Before(sinceTime)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+//=============================================================================
+
+func TestParseSince(t *testing.T) {
+    now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    local := func(s string) time.Time {
+        parsed, _ := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+        return parsed
+    }
+    tests := []struct {
+        spec string
+        want time.Time
+    }{
+        {"24h", now.Add(-24 * time.Hour)},
+        {"90m", now.Add(-90 * time.Minute)},
+        {"0s", now},
+        {"2024-05-01T08:30:00Z", time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)},
+        {"2024-05-01T08:30:00+02:00", time.Date(2024, 5, 1, 6, 30, 0, 0, time.UTC)},
+        {"2024-05-01T08:30:00", local("2024-05-01 08:30:00")},
+        {"2024-05-01 08:30", local("2024-05-01 08:30:00")},
+        {"2024-05-01", local("2024-05-01 00:00:00")},
+    }
+    for _, test := range tests {
+        if got, err := parseSince(test.spec, now); err != nil || !got.Equal(test.want) {
+            t.Errorf("%s: got %v, %v, want %v", test.spec, got, err, test.want)
+        }
+    }
+
+    for _, spec := range []string{"-1h", "yesterday", "2024-13-01", "2024-05-01T08:30", ""} {
+        if got, err := parseSince(spec, now); err == nil {
+            t.Errorf("%q: got %v, want an error", spec, got)
+        }
+    }
+}
+
+func TestModifiedSince(t *testing.T) {
+    at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+    if !modifiedSince(at) {
+        t.Error("Filtered without -since")
+    }
+    setFlag(t, &sinceTime, at)
+    tests := map[time.Duration]bool{-time.Second: false, 0: true, time.Second: true}
+    for offset, want := range tests {
+        if got := modifiedSince(at.Add(offset)); got != want {
+            t.Errorf("%v from -since: got %v, want %v", offset, got, want)
+        }
+    }
+}
+
+// The walk skips what's older, going by mtime.
+func TestWalkSince(t *testing.T) {
+    root := imageTree(t, "old.jpg", "x/old.jpg", "new.jpg", "x/new.jpg")
+    old := time.Now().Add(-48 * time.Hour)
+    for _, name := range []string{"old.jpg", "x/old.jpg"} {
+        if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    setFlag(t, &sinceTime, time.Now().Add(-24 * time.Hour))
+    if got := walked(t, root); !reflect.DeepEqual(got, []string{"new.jpg", "x/new.jpg"}) {
+        t.Errorf("Got %v", got)
+    }
+}
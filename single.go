@@ -0,0 +1,31 @@
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+//=============================================================================
+
+// -i can also name a single image (local or S3), for trying settings out
+// on one file. It's queued as is, like a path from -stdin, without walking
+// or filtering, and its thumbnails go right in -o.
+
+// Set in main.
+var inputIsFile bool
+
+// isSingleFile reports whether inputPath is a file rather than a directory
+// (or S3 prefix). For S3 that's a key with an object of its own.
+func isSingleFile(inputPath string) bool {
+    if !isS3URI(inputPath) {
+        info, err := os.Stat(inputPath)
+        return err == nil && info.Mode().IsRegular()
+    }
+
+    _, key := parseS3URI(inputPath)
+    if key == "" || strings.HasSuffix(key, "/") {
+        return false
+    }
+    _, err := thumbnailer.Storage.Size(inputPath)
+    return err == nil
+}
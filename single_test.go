@@ -0,0 +1,32 @@
+package main
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+//=============================================================================
+
+// A file as -i is thumbnailed alone, into -o, whatever else its directory
+// holds and whatever -ext says.
+func TestSingleFileInput(t *testing.T) {
+    dir := t.TempDir()
+    input := writeFile(t, dir, "in/x/b.img", pngImage(t))
+    writeFile(t, dir, "in/x/c.png", pngImage(t))
+    setFlag(t, inputDir, input)
+    setFlag(t, outputDir, filepath.Join(dir, "out"))
+
+    if !isSingleFile(input) || isSingleFile(filepath.Dir(input)) || isSingleFile(filepath.Join(dir, "missing.png")) {
+        t.Error("Files and directories mixed up")
+    }
+
+    want := []string{
+        "b_center.png", "b_center_flipped.png",
+        "b_left.png", "b_left_flipped.png",
+        "b_right.png", "b_right_flipped.png",
+    }
+    if got := run(t); !reflect.DeepEqual(got, want) {
+        t.Errorf("Got %v, want %v", got, want)
+    }
+}
@@ -0,0 +1,133 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+)
+
+//=============================================================================
+
+// Sources with more than one frame come out as just one of them: a GIF's
+// by GifFrame, an APNG's default image, a multi-page TIFF's first page.
+// Animated WebPs don't decode at all. For datasets where any of that would
+// be a surprise, RejectAnimated skips them all, with an error wrapping
+// ErrAnimated, before they're decoded. Only the container is looked at,
+// which is cheap: GIF blocks are skipped by their lengths, APNGs have an
+// acTL chunk, WebPs set the VP8X animation flag, and TIFFs link a second
+// IFD.
+
+// frameCount says how many frames data holds, as far as its headers tell:
+// 1 for anything it can't see more in.
+func frameCount(data []byte) int {
+    switch {
+    case isGIF(data):
+        return gifFrames(data)
+    case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+        return apngFrames(data)
+    case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+        return webpFrames(data)
+    case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+        return tiffPages(data)
+    }
+    return 1
+}
+
+// gifFrames counts a GIF's image descriptors, stopping at a second.
+func gifFrames(data []byte) int {
+    if len(data) < 13 {
+        return 1
+    }
+    i := 13
+    if flags := data[10]; flags & 0x80 != 0 {
+        i += 3 << (flags & 7 + 1)
+    }
+
+    // Skips sub-blocks from i, each prefixed with its length, through the
+    // empty one that ends them.
+    skipBlocks := func(i int) int {
+        for i < len(data) && data[i] != 0 {
+            i += int(data[i]) + 1
+        }
+        return i + 1
+    }
+
+    frames := 0
+    for i < len(data) {
+        switch data[i] {
+        case 0x21: // Extension: label, then sub-blocks.
+            i = skipBlocks(i + 2)
+        case 0x2C: // Image: descriptor, local color table, LZW code size.
+            frames++
+            if frames > 1 || i + 10 > len(data) {
+                return max(frames, 1)
+            }
+            flags := data[i + 9]
+            i += 10
+            if flags & 0x80 != 0 {
+                i += 3 << (flags & 7 + 1)
+            }
+            i = skipBlocks(i + 1)
+        default: // Trailer, or something that isn't GIF.
+            return max(frames, 1)
+        }
+    }
+    return max(frames, 1)
+}
+
+// apngFrames reads an APNG's acTL, which has to come before the image
+// data.
+func apngFrames(data []byte) int {
+    for i := 8; i + 12 <= len(data); {
+        n := int(binary.BigEndian.Uint32(data[i:]))
+        if n < 0 || i + 12 + n > len(data) {
+            break
+        }
+        kind := string(data[i + 4:i + 8])
+        if kind == "IDAT" {
+            break
+        }
+        if kind == "acTL" && n >= 8 {
+            return max(int(binary.BigEndian.Uint32(data[i + 8:])), 1)
+        }
+        i += 12 + n
+    }
+    return 1
+}
+
+// webpFrames is 2 for an animated WebP, which doesn't say how many frames
+// it has up front.
+func webpFrames(data []byte) int {
+    if len(data) >= 21 && string(data[12:16]) == "VP8X" && data[20] & 0x02 != 0 {
+        return 2
+    }
+    return 1
+}
+
+// tiffPages is 2 for a TIFF whose first IFD links another.
+func tiffPages(data []byte) int {
+    var order binary.ByteOrder = binary.LittleEndian
+    if data[0] == 'M' {
+        order = binary.BigEndian
+    }
+    if len(data) < 8 {
+        return 1
+    }
+    ifd := int64(order.Uint32(data[4:]))
+    if ifd + 2 > int64(len(data)) {
+        return 1
+    }
+    next := ifd + 2 + 12 * int64(order.Uint16(data[ifd:]))
+    if next + 4 > int64(len(data)) || order.Uint32(data[next:]) == 0 {
+        return 1
+    }
+    return 2
+}
+
+// checkAnimated fails data with more than one frame, under RejectAnimated.
+func (t *Thumbnailer) checkAnimated(data []byte) error {
+    if t.RejectAnimated && frameCount(data) > 1 {
+        return fmt.Errorf("%w: more than one frame", ErrAnimated)
+    }
+    return nil
+}
@@ -0,0 +1,86 @@
+package thumbnail
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/color"
+    "image/gif"
+    "testing"
+)
+
+//=============================================================================
+
+// gifData encodes a GIF of frames solid 8x8 frames.
+func gifData(t *testing.T, frames int) []byte {
+    t.Helper()
+    palette := color.Palette{color.Black, color.White}
+    anim := &gif.GIF{}
+    for i := 0; i < frames; i++ {
+        frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+        for j := range frame.Pix {
+            frame.Pix[j] = uint8(i % 2)
+        }
+        anim.Image = append(anim.Image, frame)
+        anim.Delay = append(anim.Delay, 10)
+    }
+
+    var b bytes.Buffer
+    if err := gif.EncodeAll(&b, anim); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+// testdata/animated.webp is 16x16, VP8X, ANIM and two ANMF frames, solid
+// red then blue, each a VP8L from encodeWebP.
+
+// stillWebP is a plain lossless WebP, as encodeWebP writes them.
+func stillWebP(t *testing.T) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    if err := encodeWebP(&b, solidImage(16, 16, color.White), 100); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+func TestFrameCount(t *testing.T) {
+    tests := []struct {
+        name     string
+        data     []byte
+        animated bool
+    }{
+        {"still gif", gifData(t, 1), false},
+        {"animated gif", gifData(t, 3), true},
+        {"still webp", stillWebP(t), false},
+        {"animated webp", readTestdata(t, "animated.webp"), true},
+        {"png", pngData(t, solidImage(8, 8, color.White)), false},
+        {"garbage", []byte("not an image"), false},
+    }
+
+    for _, test := range tests {
+        if got := frameCount(test.data) > 1; got != test.animated {
+            t.Errorf("%s: animated %v, want %v", test.name, got, test.animated)
+        }
+    }
+}
+
+func TestRejectAnimated(t *testing.T) {
+    th := New()
+    th.RejectAnimated = true
+
+    for name, data := range map[string][]byte{"gif": gifData(t, 2), "webp": readTestdata(t, "animated.webp")} {
+        if _, err := th.Decode(data); !errors.Is(err, ErrAnimated) {
+            t.Errorf("%s: got %v, want ErrAnimated", name, err)
+        }
+    }
+    if _, err := th.Decode(gifData(t, 1)); err != nil {
+        t.Errorf("Still gif rejected: %v", err)
+    }
+
+    th.RejectAnimated = false
+    if _, err := th.Decode(gifData(t, 2)); err != nil {
+        t.Errorf("Animated gif rejected without RejectAnimated: %v", err)
+    }
+}
@@ -0,0 +1,113 @@
+package thumbnail
+
+import (
+    "fmt"
+    "github.com/disintegration/gift"
+    "hash/fnv"
+    "image"
+    "image/color"
+    "math"
+    "math/rand"
+)
+
+//=============================================================================
+
+// With Augment, each input makes that many thumbnails instead of one per
+// anchor, each a little different, for training sets: turned by a random
+// angle of up to AugmentRotate degrees either way, cropped at a random
+// spot, and (with Flip, and FlipVertical) mirrored at even odds. They're
+// named aug0, aug1 and so on, so a run writes Augment times as many files
+// per input; Mode and Anchors don't apply. The source is scaled once to
+// cover Dim turned by the widest angle, with 10% to spare, and every crop
+// is a window of that which the turn leaves no corners of. AugmentSeed
+// and the input's checksum decide everything, so a rerun with the same
+// seed makes the same thumbnails, however its workers are scheduled.
+
+// Headroom past the rotated Dim, which is what's left for random offsets
+// when the source has Dim's aspect ratio.
+const augmentMargin = 1.1
+
+// augmentVariants are variants's names under Augment.
+func (t *Thumbnailer) augmentVariants() []Variant {
+    variants := make([]Variant, t.Augment)
+    for i := range variants {
+        variants[i] = Variant{Name: fmt.Sprintf("aug%d", i)}
+    }
+    return variants
+}
+
+// rotatedBox is the size of the axis-aligned box around Dim turned by
+// degrees.
+func (t *Thumbnailer) rotatedBox(degrees float64) (float64, float64) {
+    sin, cos := math.Sincos(degrees * math.Pi / 180)
+    sin, cos = math.Abs(sin), math.Abs(cos)
+    w, h := float64(t.Dim[0]), float64(t.Dim[1])
+    return w * cos + h * sin, w * sin + h * cos
+}
+
+// augmentRand is the random source for the input with the given key
+// (its checksum, or empty).
+func (t *Thumbnailer) augmentRand(key string) *rand.Rand {
+    h := fnv.New64a()
+    h.Write([]byte(key))
+    return rand.New(rand.NewSource(t.AugmentSeed ^ int64(h.Sum64())))
+}
+
+// addAugmented stores Augment thumbnails of src, drawing on key's random
+// source.
+func (t *Thumbnailer) addAugmented(thumbs map[string]image.Image, src image.Image, key string) {
+    // A couple of pixels' more room, for the rounding of the crop and turn.
+    bx, by := t.rotatedBox(t.AugmentRotate)
+    cover := image.Pt(int(math.Ceil(bx * augmentMargin)) + 4, int(math.Ceil(by * augmentMargin)) + 4)
+
+    size := src.Bounds().Size()
+    s := math.Max(float64(cover.X) / float64(size.X), float64(cover.Y) / float64(size.Y))
+    w := max(int(math.Round(float64(size.X) * s)), cover.X)
+    h := max(int(math.Round(float64(size.Y) * s)), cover.Y)
+
+    g := gift.New(t.resampling(src, gift.Resize(w, h, RESAMPLINGS[t.Resample])))
+    scaled := t.canvas(src, g.Bounds(src.Bounds()))
+    g.Draw(scaled, src)
+    defer release(scaled)
+
+    adjustments := t.adjustments()
+    decoration := t.decoration()
+    if decoration != nil {
+        decoration.deep = t.deep(src)
+    }
+
+    r := t.augmentRand(key)
+    for _, v := range t.augmentVariants() {
+        // Drawn whether or not they're used, so changing the flips doesn't
+        // change the angles and offsets.
+        degrees := (r.Float64() * 2 - 1) * t.AugmentRotate
+        bx, by := t.rotatedBox(degrees)
+        window := image.Pt(int(math.Ceil(bx)) + 4, int(math.Ceil(by)) + 4)
+        offset := image.Pt(r.Intn(w - window.X + 1), r.Intn(h - window.Y + 1))
+        flip, vflip := r.Intn(2) == 0, r.Intn(2) == 0
+
+        filters := []gift.Filter{
+            gift.Crop(image.Rectangle{offset, offset.Add(window)}),
+            gift.Rotate(float32(degrees), color.Transparent, gift.CubicInterpolation),
+            gift.CropToSize(t.Dim[0], t.Dim[1], gift.CenterAnchor),
+        }
+        filters = append(filters, adjustments...)
+        if decoration != nil {
+            filters = append(filters, decoration)
+        }
+        if t.Flip && flip {
+            filters = append(filters, gift.FlipHorizontal())
+        }
+        if t.FlipVertical && vflip {
+            filters = append(filters, gift.FlipVertical())
+        }
+        if w := t.watermark(); w != nil {
+            filters = append(filters, w)
+        }
+
+        g := gift.New(filters...)
+        dst := t.canvas(src, g.Bounds(scaled.Bounds()))
+        g.Draw(dst, scaled)
+        thumbs[v.Key()] = dst
+    }
+}
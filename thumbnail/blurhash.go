@@ -0,0 +1,119 @@
+package thumbnail
+
+import (
+    "github.com/disintegration/gift"
+    "image"
+    "math"
+    "strings"
+)
+
+//=============================================================================
+
+// BlurHash (https://blurha.sh) packs a few DCT components of an image into
+// a short string that web clients decode into a blurry placeholder. Only
+// the lowest frequencies survive, so it's computed from a small copy of
+// the source; the full size would cost a lot and change nothing.
+
+const blurHashSample = 32
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(b *strings.Builder, value, length int) {
+    for i := length - 1; i >= 0; i-- {
+        divisor := int(math.Pow(83, float64(i)))
+        b.WriteByte(base83Chars[value / divisor % 83])
+    }
+}
+
+func srgbToLinear(v uint8) float64 {
+    f := float64(v) / 255
+    if f <= 0.04045 {
+        return f / 12.92
+    }
+    return math.Pow((f + 0.055) / 1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+    v = clamp01(v)
+    if v <= 0.0031308 {
+        return int(v * 12.92 * 255 + 0.5)
+    }
+    return int((1.055 * math.Pow(v, 1 / 2.4) - 0.055) * 255 + 0.5)
+}
+
+// signPow is math.Pow that keeps v's sign.
+func signPow(v, exp float64) float64 {
+    return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// BlurHash encodes img with xComponents by yComponents components, 1-9
+// each. Alpha is ignored.
+func BlurHash(img image.Image, xComponents, yComponents int) string {
+    g := gift.New(gift.Resize(blurHashSample, 0, gift.BoxResampling))
+    if size := img.Bounds().Size(); size.Y > size.X {
+        g = gift.New(gift.Resize(0, blurHashSample, gift.BoxResampling))
+    }
+    small := image.NewNRGBA(g.Bounds(img.Bounds()))
+    g.Draw(small, img)
+
+    w, h := small.Bounds().Dx(), small.Bounds().Dy()
+    linear := make([][3]float64, w * h)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            c := small.NRGBAAt(x, y)
+            linear[y * w + x] = [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)}
+        }
+    }
+
+    factors := make([][3]float64, 0, xComponents * yComponents)
+    for j := 0; j < yComponents; j++ {
+        for i := 0; i < xComponents; i++ {
+            var f [3]float64
+            for y := 0; y < h; y++ {
+                for x := 0; x < w; x++ {
+                    basis := math.Cos(math.Pi * float64(i * x) / float64(w)) *
+                             math.Cos(math.Pi * float64(j * y) / float64(h))
+                    p := linear[y * w + x]
+                    f[0] += basis * p[0]
+                    f[1] += basis * p[1]
+                    f[2] += basis * p[2]
+                }
+            }
+
+            scale := 2 / float64(w * h)
+            if i == 0 && j == 0 {
+                scale = 1 / float64(w * h)
+            }
+            factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+        }
+    }
+
+    var b strings.Builder
+    encode83(&b, (xComponents - 1) + (yComponents - 1) * 9, 1)
+
+    dc, ac := factors[0], factors[1:]
+    maximum := 1.0
+    if len(ac) > 0 {
+        actual := 0.0
+        for _, f := range ac {
+            actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+        }
+        quantised := int(math.Max(0, math.Min(82, math.Floor(actual * 166 - 0.5))))
+        maximum = float64(quantised + 1) / 166
+        encode83(&b, quantised, 1)
+    } else {
+        encode83(&b, 0, 1)
+    }
+
+    encode83(&b, linearToSRGB(dc[0]) << 16 | linearToSRGB(dc[1]) << 8 | linearToSRGB(dc[2]), 4)
+
+    for _, f := range ac {
+        var q [3]int
+        for k := range f {
+            q[k] = int(math.Max(0, math.Min(18, math.Floor(signPow(f[k] / maximum, 0.5) * 9 + 9.5))))
+        }
+        encode83(&b, q[0] * 19 * 19 + q[1] * 19 + q[2], 2)
+    }
+
+    return b.String()
+}
@@ -0,0 +1,47 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+// Reference hashes from the woltapp/blurhash encoder's algorithm, run on
+// the same pixels. At 32 pixels across, the sources aren't resampled
+// first.
+func TestBlurHash(t *testing.T) {
+    halves := gradientImage(32, 32)
+    for y := 0; y < 32; y++ {
+        for x := 0; x < 32; x++ {
+            c := color.NRGBA{255, 0, 0, 255}
+            if x >= 16 {
+                c = color.NRGBA{0, 0, 255, 255}
+            }
+            halves.SetNRGBA(x, y, c)
+        }
+    }
+
+    tests := []struct {
+        name   string
+        img    *image.NRGBA
+        x, y   int
+        want   string
+    }{
+        {"gradient", gradientImage(32, 32), 4, 3, "L$Het82swxX8l}WDjte;gJfjfQfj"},
+        {"gradient, mean only", gradientImage(32, 32), 1, 1, "00Het8"},
+        {"halves", halves, 4, 3, "L~LjfL|TsRJro3n~jsa}fQfQfQfQ"},
+    }
+    for _, test := range tests {
+        if got := BlurHash(test.img, test.x, test.y); got != test.want {
+            t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+        }
+    }
+
+    // A bigger copy is sampled down to about the same thing: the mean color
+    // (characters 2-5) is the same.
+    if got := BlurHash(gradientImage(256, 256), 4, 3); len(got) != 28 || got[2:6] != "Het8" {
+        t.Errorf("256x256 gradient: got %q", got)
+    }
+}
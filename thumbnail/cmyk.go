@@ -0,0 +1,77 @@
+package thumbnail
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "strings"
+)
+
+//=============================================================================
+
+// CMYK JPEGs come out of print workflows. image/jpeg decodes the ones with
+// an Adobe APP14 marker, undoing Adobe's inverted storage (255 is no ink),
+// but refuses those without one, which store their ink the right way up.
+// Those get a marker spliced in and their channels flipped back after.
+// Either way, CMYK becomes NRGBA before any filter sees it, since gift's
+// fast paths don't know CMYK. The conversion is the naive one; without an
+// ICC profile there's nothing better to go on.
+
+// adobeCMYK is an APP14 segment declaring plain CMYK (transform 0).
+var adobeCMYK = []byte{
+    0xFF, 0xEE, 0x00, 0x0E,
+    'A', 'd', 'o', 'b', 'e',
+    0x00, 0x64, // Version
+    0x00, 0x00, 0x00, 0x00, // Flags
+    0x00, // Transform
+}
+
+// isBareCMYK reports whether err is image/jpeg refusing a CMYK JPEG for
+// lack of an APP14 marker.
+func isBareCMYK(err error) bool {
+    var unsupported jpeg.UnsupportedError
+    return errors.As(err, &unsupported) && strings.Contains(string(unsupported), "APP14")
+}
+
+// decodeBareCMYK decodes a CMYK JPEG without an APP14 marker by giving it
+// one.
+func decodeBareCMYK(data []byte) (image.Image, error) {
+    patched := make([]byte, 0, len(data) + len(adobeCMYK))
+    patched = append(patched, data[:2]...) // SOI
+    patched = append(patched, adobeCMYK...)
+    patched = append(patched, data[2:]...)
+
+    img, err := jpeg.Decode(bytes.NewReader(patched))
+    if err != nil {
+        return nil, err
+    }
+
+    // The decoder undid an inversion that was never there; redo it.
+    if cmyk, ok := img.(*image.CMYK); ok {
+        for i := range cmyk.Pix {
+            cmyk.Pix[i] = 255 - cmyk.Pix[i]
+        }
+    }
+    return img, nil
+}
+
+// cmykToNRGBA converts img if it's CMYK, and returns it as is otherwise.
+func cmykToNRGBA(img image.Image) image.Image {
+    cmyk, ok := img.(*image.CMYK)
+    if !ok {
+        return img
+    }
+
+    b := cmyk.Bounds()
+    dst := image.NewNRGBA(b)
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            c := cmyk.CMYKAt(x, y)
+            r, g, bl := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+            dst.SetNRGBA(x, y, color.NRGBA{r, g, bl, 0xFF})
+        }
+    }
+    return dst
+}
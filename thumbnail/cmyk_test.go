@@ -0,0 +1,107 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "os"
+    "testing"
+)
+
+//=============================================================================
+
+// testdata/cmyk.jpg is image/jpeg's video-001.cmyk.jpeg: a warm, reddish
+// photo, CMYK with an Adobe APP14 marker.
+
+// withoutSegment drops a JPEG's marker segments of the given kind.
+func withoutSegment(data []byte, kind byte) []byte {
+    out := append([]byte{}, data[:2]...)
+    pos := 2
+    for pos + 4 <= len(data) && data[pos] == 0xFF && data[pos + 1] != 0xDA {
+        size := int(binary.BigEndian.Uint16(data[pos + 2:]))
+        if data[pos + 1] != kind {
+            out = append(out, data[pos:pos + 2 + size]...)
+        }
+        pos += 2 + size
+    }
+    return append(out, data[pos:]...)
+}
+
+// naiveRGB converts ink to RGB the way cmykToNRGBA means to, inverting it
+// first if invert is set.
+func naiveRGB(ink *image.CMYK, invert bool) *image.NRGBA {
+    b := ink.Bounds()
+    dst := image.NewNRGBA(b)
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            c := ink.CMYKAt(x, y)
+            if invert {
+                c = color.CMYK{255 - c.C, 255 - c.M, 255 - c.Y, 255 - c.K}
+            }
+            r, g, bl := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+            dst.SetNRGBA(x, y, color.NRGBA{r, g, bl, 0xFF})
+        }
+    }
+    return dst
+}
+
+// meanColor is img's average red, green and blue.
+func meanColor(img *image.NRGBA) [3]int {
+    var sum [3]int
+    for i := 0; i < len(img.Pix); i += 4 {
+        sum[0] += int(img.Pix[i])
+        sum[1] += int(img.Pix[i + 1])
+        sum[2] += int(img.Pix[i + 2])
+    }
+    n := len(img.Pix) / 4
+    return [3]int{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+func TestCMYK(t *testing.T) {
+    adobe, err := os.ReadFile("testdata/cmyk.jpg")
+    if err != nil {
+        t.Fatal(err)
+    }
+    decoded, err := jpeg.Decode(bytes.NewReader(adobe))
+    if err != nil {
+        t.Fatal(err)
+    }
+    ink := decoded.(*image.CMYK)
+
+    // Without the marker the same bytes mean the opposite ink, since Adobe
+    // stores it inverted.
+    bare := withoutSegment(adobe, 0xEE)
+    if _, err := jpeg.Decode(bytes.NewReader(bare)); !isBareCMYK(err) {
+        t.Fatalf("Bare fixture decoded with %v", err)
+    }
+
+    tests := []struct {
+        name   string
+        data   []byte
+        invert bool
+        warm   bool
+    }{
+        {"adobe", adobe, false, true},
+        {"bare", bare, true, false},
+    }
+    for _, test := range tests {
+        img, err := New().Decode(test.data)
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        got, ok := img.(*image.NRGBA)
+        if !ok {
+            t.Fatalf("%s: decoded as %T, want NRGBA", test.name, img)
+        }
+        if d := maxDiff(t, naiveRGB(ink, test.invert), got); d != 0 {
+            t.Errorf("%s: off by up to %d", test.name, d)
+        }
+        // Inverted, the photo would come out bluish.
+        m := meanColor(got)
+        if warm := m[0] > m[1] && m[1] > m[2]; warm != test.warm {
+            t.Errorf("%s: mean color %v, warm %v, want %v", test.name, m, warm, test.warm)
+        }
+    }
+}
@@ -0,0 +1,74 @@
+package thumbnail
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "golang.org/x/image/tiff"
+    "image"
+    "image/jpeg"
+    "image/png"
+)
+
+//=============================================================================
+
+// Partial downloads are the usual culprit. The decoders catch most of
+// them; the EOI check is for header-only reads (dry runs), which never
+// get as far as the missing bytes. Files a decoder merely doesn't support
+// aren't corrupt, so their errors are left as they were.
+
+// Wrapped by decodeImage's panics, which say more about the decoder than
+// the file.
+var errUnsupported = errors.New("Unsupported image")
+
+// corruptError wraps a decode error in ErrCorrupt, unless the decoder was
+// declining a feature rather than the data.
+func corruptError(err error) error {
+    var jpegUnsupported jpeg.UnsupportedError
+    var pngUnsupported png.UnsupportedError
+    var tiffUnsupported tiff.UnsupportedError
+    if errors.As(err, &jpegUnsupported) || errors.As(err, &pngUnsupported) || errors.As(err, &tiffUnsupported) || errors.Is(err, errUnsupported) {
+        return err
+    }
+    return fmt.Errorf("%w: %v", ErrCorrupt, err)
+}
+
+// checkComplete returns an ErrCorrupt error if data is obviously cut short.
+func checkComplete(data []byte) error {
+    if bytes.HasPrefix(data, []byte{0xFF, 0xD8}) && jpegTruncated(data) {
+        return fmt.Errorf("%w: JPEG has no end-of-image marker", ErrCorrupt)
+    }
+    return nil
+}
+
+// checkBounds returns an ErrCorrupt error for an image with no pixels,
+// which some decoders hand back for a header and nothing else.
+func checkBounds(size image.Point) error {
+    if size.X <= 0 || size.Y <= 0 {
+        return fmt.Errorf("%w: image is %dx%d", ErrCorrupt, size.X, size.Y)
+    }
+    return nil
+}
+
+// jpegTruncated reports whether a JPEG stops before its end-of-image
+// marker. Segments up to the first scan are skipped by their lengths,
+// since an EXIF thumbnail brings markers of its own. Past there, scan data
+// escapes its 0xFF bytes, so the first EOI is the real one and anything
+// after it (motion photos append a whole video) doesn't matter.
+func jpegTruncated(data []byte) bool {
+    i := 2
+    for i + 4 <= len(data) {
+        if data[i] != 0xFF {
+            return false // Out of step; the decoder can judge.
+        }
+        switch marker := data[i + 1]; marker {
+        case 0xFF:
+            i += 1 // Fill byte
+        case 0xDA:
+            return !bytes.Contains(data[i:], []byte{0xFF, 0xD9})
+        default:
+            i += 2 + (int(data[i + 2]) << 8 | int(data[i + 3]))
+        }
+    }
+    return true
+}
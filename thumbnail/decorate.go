@@ -0,0 +1,114 @@
+package thumbnail
+
+import (
+    "github.com/disintegration/gift"
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+)
+
+//=============================================================================
+
+// Borders and rounded corners for gallery-ready thumbnails. Both come from
+// the pixel's distance to the edge of a rounded rectangle, which is what
+// keeps the curves antialiased: a pixel the edge passes through gets
+// partial alpha (or partial border) instead of all or nothing.
+
+type decorateFilter struct {
+    width  int
+    color  color.NRGBA
+    radius int
+    deep   bool // Work at 16 bits (see depth.go).
+}
+
+// decoration is the filter for the Border* and Radius options, or nil if
+// they're all off.
+func (t *Thumbnailer) decoration() *decorateFilter {
+    if t.BorderWidth == 0 && t.Radius == 0 {
+        return nil
+    }
+
+    var c color.NRGBA
+    if t.BorderColor != nil {
+        c = color.NRGBAModel.Convert(t.BorderColor).(color.NRGBA)
+    }
+    return &decorateFilter{width: t.BorderWidth, color: c, radius: t.Radius}
+}
+
+func (f *decorateFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+    return image.Rect(0, 0, srcBounds.Dx(), srcBounds.Dy())
+}
+
+// edgeDistance is the signed distance from (x, y) to the edge of a w x h
+// rounded rectangle; negative inside.
+func edgeDistance(x, y, w, h, r float64) float64 {
+    qx := math.Abs(x - w / 2) - (w / 2 - r)
+    qy := math.Abs(y - h / 2) - (h / 2 - r)
+    outside := math.Hypot(math.Max(qx, 0), math.Max(qy, 0))
+    return outside + math.Min(math.Max(qx, qy), 0) - r
+}
+
+func clamp01(v float64) float64 {
+    return math.Max(0, math.Min(1, v))
+}
+
+func (f *decorateFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+    b := src.Bounds()
+    w, h := float64(b.Dx()), float64(b.Dy())
+
+    // A radius past half the short side would turn the corners inside out.
+    r := math.Min(float64(f.radius), math.Min(w, h) / 2)
+
+    if f.deep {
+        f.drawDeep(dst, src, r)
+        return
+    }
+
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            c := color.NRGBAModel.Convert(src.At(b.Min.X + x, b.Min.Y + y)).(color.NRGBA)
+            d := edgeDistance(float64(x) + 0.5, float64(y) + 0.5, w, h, r)
+
+            if f.width > 0 {
+                mix := clamp01(float64(f.width) + d + 0.5)
+                c.R = uint8(float64(c.R) * (1 - mix) + float64(f.color.R) * mix + 0.5)
+                c.G = uint8(float64(c.G) * (1 - mix) + float64(f.color.G) * mix + 0.5)
+                c.B = uint8(float64(c.B) * (1 - mix) + float64(f.color.B) * mix + 0.5)
+                c.A = uint8(float64(c.A) * (1 - mix) + float64(f.color.A) * mix + 0.5)
+            }
+            if r > 0 {
+                c.A = uint8(float64(c.A) * clamp01(0.5 - d) + 0.5)
+            }
+
+            dst.Set(dst.Bounds().Min.X + x, dst.Bounds().Min.Y + y, c)
+        }
+    }
+}
+
+// drawDeep is Draw at 16 bits.
+func (f *decorateFilter) drawDeep(dst draw.Image, src image.Image, r float64) {
+    b := src.Bounds()
+    w, h := float64(b.Dx()), float64(b.Dy())
+    border := color.NRGBA64Model.Convert(f.color).(color.NRGBA64)
+    mixed := func (v, border uint16, mix float64) uint16 {
+        return uint16(float64(v) * (1 - mix) + float64(border) * mix + 0.5)
+    }
+
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            c := color.NRGBA64Model.Convert(src.At(b.Min.X + x, b.Min.Y + y)).(color.NRGBA64)
+            d := edgeDistance(float64(x) + 0.5, float64(y) + 0.5, w, h, r)
+
+            if f.width > 0 {
+                mix := clamp01(float64(f.width) + d + 0.5)
+                c = color.NRGBA64{mixed(c.R, border.R, mix), mixed(c.G, border.G, mix), mixed(c.B, border.B, mix), mixed(c.A, border.A, mix)}
+            }
+            if r > 0 {
+                c.A = uint16(float64(c.A) * clamp01(0.5 - d) + 0.5)
+            }
+
+            dst.Set(dst.Bounds().Min.X + x, dst.Bounds().Min.Y + y, c)
+        }
+    }
+}
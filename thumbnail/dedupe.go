@@ -0,0 +1,116 @@
+package thumbnail
+
+import (
+    "image"
+    "sync"
+)
+
+//=============================================================================
+
+type dedupeState struct {
+    mutex sync.Mutex
+
+    // Maps checksums to the first path that produced them.
+    checksums map[string]string
+
+    // Only used in phash mode, where near misses count too.
+    phashes bkTree
+
+    // Only used with QuickHash: quick keys to the paths that produced
+    // them, and those paths' checksums, once they're known.
+    quick map[string][]string
+    sums  map[string]string
+}
+
+// isDupe records path, or returns the path it duplicates. Exact matching
+// only needs the keys (see quickhash.go); phash mode hashes img instead.
+func (t *Thumbnailer) isDupe(keys fileKeys, img image.Image, path string) (string, bool) {
+    var hash uint64
+    if t.DedupeMode == "phash" {
+        hash = perceptualHash(img) // Outside the lock; it's not cheap.
+    }
+
+    // This should be better than a RWLock for most cases.
+    // Usually, you have only a few dupes.
+    d := &t.dedupe
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+
+    if t.DedupeMode == "phash" {
+        if original, found := d.phashes.find(hash, t.DedupeDistance); found {
+            return original, true
+        }
+        d.phashes.add(hash, path)
+        return "", false
+    }
+
+    if t.QuickHash {
+        return t.isQuickDupe(keys, path)
+    }
+
+    checksum := keys.checksum
+    if original, found := d.checksums[checksum]; found {
+        return original, true
+    }
+    if d.checksums == nil {
+        d.checksums = make(map[string]string)
+    }
+    d.checksums[checksum] = path
+    return "", false
+}
+
+// forget undoes isDupe's recording of path, for an input that failed
+// after all, so inputs duplicating it aren't skipped for it later. Only
+// failures get here, so it just looks through everything.
+func (t *Thumbnailer) forget(path string) {
+    d := &t.dedupe
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+
+    for checksum, p := range d.checksums {
+        if p == path {
+            delete(d.checksums, checksum)
+        }
+    }
+    delete(d.sums, path)
+    for key, paths := range d.quick {
+        for i, p := range paths {
+            if p == path {
+                d.quick[key] = append(paths[:i:i], paths[i + 1:]...)
+                break
+            }
+        }
+    }
+    d.phashes.remove(path)
+}
+
+// Remember counts path as already seen, so inputs duplicating it come back
+// as its duplicates; e.g. to dedupe a new batch against an old one. In
+// phash mode it has to be decoded; with QuickHash, it's mostly just the
+// ends that are read.
+func (t *Thumbnailer) Remember(path string) error {
+    if t.DedupeMode != "phash" {
+        keys, err := t.dedupeKeys(path)
+        if err == nil {
+            t.isDupe(keys, nil, path)
+        }
+        return err
+    }
+
+    img, _, keys, err := t.readImage(path)
+    if err != nil {
+        return err
+    }
+    t.isDupe(keys, img, path)
+    return nil
+}
+
+// RememberChecksum is Remember for a file whose checksum (under Hash) is
+// known already, as from an earlier run's manifest. Only exact matching
+// can use it; phash mode ignores it. With QuickHash, only inputs read
+// whole (the ones being thumbnailed) are matched against it.
+func (t *Thumbnailer) RememberChecksum(checksum, path string) {
+    if t.DedupeMode != "phash" {
+        t.isDupe(fileKeys{checksum: checksum}, nil, path)
+    }
+}
@@ -0,0 +1,41 @@
+package thumbnail
+
+import (
+    "encoding/binary"
+    "math"
+)
+
+//=============================================================================
+
+// With DPI, thumbnails say how big they'd print: a pHYs chunk in PNGs, in
+// pixels per meter (the only unit it has), and a JFIF APP0 segment in
+// JPEGs, in dots per inch. Neither encoder writes them, so they're spliced
+// into its output. Without DPI there's neither, and readers assume their
+// own default, usually 72 or 96. WebP has nowhere to put it.
+
+// The JFIF density fields are 16 bits.
+const maxDPI = 65535
+
+// physChunk is a PNG pHYs chunk for dpi, or nil for 0.
+func physChunk(dpi int) []byte {
+    if dpi <= 0 {
+        return nil
+    }
+    perMeter := uint32(math.Round(float64(dpi) / 0.0254))
+
+    data := binary.BigEndian.AppendUint32(nil, perMeter)
+    data = binary.BigEndian.AppendUint32(data, perMeter)
+    return pngChunk("pHYs", append(data, 1)) // Unit 1 is the meter.
+}
+
+// jfifSegment is a JFIF 1.02 APP0 segment for dpi with no embedded
+// thumbnail, or nil for 0.
+func jfifSegment(dpi int) []byte {
+    if dpi <= 0 {
+        return nil
+    }
+    segment := []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 2, 1} // Unit 1 is the inch.
+    segment = binary.BigEndian.AppendUint16(segment, uint16(dpi))
+    segment = binary.BigEndian.AppendUint16(segment, uint16(dpi))
+    return append(segment, 0, 0)
+}
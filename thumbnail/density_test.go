@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "math"
+    "testing"
+)
+
+//=============================================================================
+
+// pngChunkData returns the data of every chunk of kind in an encoded PNG.
+func pngChunkData(data []byte, kind string) [][]byte {
+    var found [][]byte
+    for i := 8; i + 12 <= len(data); {
+        n := int(binary.BigEndian.Uint32(data[i:]))
+        if i + 12 + n > len(data) {
+            break
+        }
+        if string(data[i + 4:i + 8]) == kind {
+            found = append(found, data[i + 8:i + 8 + n])
+        }
+        i += 12 + n
+    }
+    return found
+}
+
+// jfifDensity reads the units and densities of a JPEG's first segment,
+// which has to be JFIF's APP0 if there is one.
+func jfifDensity(data []byte) (units byte, x, y int, ok bool) {
+    if len(data) < 20 || !bytes.Equal(data[2:4], []byte{0xFF, 0xE0}) || string(data[6:11]) != "JFIF\x00" {
+        return 0, 0, 0, false
+    }
+    return data[13], int(binary.BigEndian.Uint16(data[14:])), int(binary.BigEndian.Uint16(data[16:])), true
+}
+
+func TestDensity(t *testing.T) {
+    src := gradientImage(32, 32)
+    for _, dpi := range []int{72, 300, 1200} {
+        th := New()
+        th.DPI = dpi
+
+        data, err := th.encodeThumb(src, "png", nil, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        phys := pngChunkData(data, "pHYs")
+        if len(phys) != 1 || len(phys[0]) != 9 {
+            t.Fatalf("%d dpi: got pHYs %v", dpi, phys)
+        }
+        x, y := binary.BigEndian.Uint32(phys[0]), binary.BigEndian.Uint32(phys[0][4:])
+        if x != y || phys[0][8] != 1 || int(math.Round(float64(x) * 0.0254)) != dpi {
+            t.Errorf("%d dpi: pHYs %d x %d per unit %d", dpi, x, y, phys[0][8])
+        }
+        decodeData(t, data)
+
+        // EXIF too, to check JFIF still comes first.
+        for _, progressive := range []bool{false, true} {
+            th.Progressive = progressive
+            data, err = th.encodeThumb(src, "jpeg", exifSegmentFor(1), "")
+            if err != nil {
+                t.Fatal(err)
+            }
+            units, x, y, ok := jfifDensity(data)
+            if !ok || units != 1 || x != dpi || y != dpi {
+                t.Errorf("%d dpi, progressive %v: JFIF %v, units %d, %d x %d", dpi, progressive, ok, units, x, y)
+            }
+            if bytes.Count(data, []byte("JFIF\x00")) != 1 {
+                t.Errorf("%d dpi, progressive %v: more than one JFIF segment", dpi, progressive)
+            }
+            decodeData(t, data)
+        }
+    }
+
+    // None without DPI.
+    th := New()
+    plainPNG, _ := th.encodeThumb(src, "png", nil, "")
+    plainJPEG, _ := th.encodeThumb(src, "jpeg", nil, "")
+    if len(pngChunkData(plainPNG, "pHYs")) != 0 || bytes.Contains(plainJPEG, []byte("JFIF\x00")) {
+        t.Error("Density written without DPI")
+    }
+}
@@ -0,0 +1,75 @@
+package thumbnail
+
+import (
+    "image"
+    "image/draw"
+    "math"
+    "sync"
+)
+
+//=============================================================================
+
+// Everything is 8 bits per channel by default, whatever the source had.
+// Scientific and medical imagery is often 16-bit, and there the low bits
+// are data. With BitDepth 16, sources that have them (16-bit PNGs and
+// TIFFs) keep them: every intermediate is an NRGBA64 instead of an NRGBA,
+// gift resizes and adjusts at that precision, and the PNG written is
+// 16-bit. 8-bit sources are processed the way they always were. JPEG and
+// WebP can't hold 16 bits, so it takes png output. What's still 8-bit:
+// Palette (by definition), ICCConvert, and watermarks, which are blended
+// at 16 bits but are 8-bit images themselves.
+
+// isDeep reports whether img has more than 8 bits per channel.
+func isDeep(img image.Image) bool {
+    switch img.(type) {
+    case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+        return true
+    }
+    return false
+}
+
+// deep reports whether what's made from img should stay deep. gift's own
+// intermediates are always NRGBA64, so filters can't tell from the images
+// they're given, and are told this instead.
+func (t *Thumbnailer) deep(img image.Image) bool {
+    return t.BitDepth == 16 && isDeep(img)
+}
+
+// canvas returns a new image with bounds r, for something made from src:
+// an NRGBA64 if it should stay deep, or else a pooled NRGBA.
+func (t *Thumbnailer) canvas(src image.Image, r image.Rectangle) draw.Image {
+    if t.deep(src) {
+        return image.NewNRGBA64(r)
+    }
+    return newNRGBA(r)
+}
+
+// LinearResize's tables for 16-bit values, which are only worth their
+// 256KB when something deep is resized.
+var srgb16ToLinear16 = sync.OnceValue(func() *[65536]uint16 {
+    var lut [65536]uint16
+    for i := range lut {
+        v := float64(i) / 0xFFFF
+        if v <= 0.04045 {
+            v /= 12.92
+        } else {
+            v = math.Pow((v + 0.055) / 1.055, 2.4)
+        }
+        lut[i] = uint16(math.Round(v * 0xFFFF))
+    }
+    return &lut
+})
+
+var linear16ToSRGB16 = sync.OnceValue(func() *[65536]uint16 {
+    var lut [65536]uint16
+    for i := range lut {
+        v := float64(i) / 0xFFFF
+        if v <= 0.0031308 {
+            v *= 12.92
+        } else {
+            v = 1.055 * math.Pow(v, 1 / 2.4) - 0.055
+        }
+        lut[i] = uint16(math.Round(clamp01(v) * 0xFFFF))
+    }
+    return &lut
+})
@@ -0,0 +1,69 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+// ramp16 is a 16-bit image whose low bytes matter: red climbs 37 a column,
+// and green and blue are constants an 8-bit pipeline would round away.
+func ramp16(w, h int) *image.NRGBA64 {
+    img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            img.SetNRGBA64(x, y, color.NRGBA64{uint16(0x4000 + x * 37), 0x1234, 0xABCD, 0xFFFF})
+        }
+    }
+    return img
+}
+
+// A 16-bit PNG comes out a 16-bit PNG with BitDepth 16, halved to within a
+// few of its own 16-bit values, and 8-bit otherwise.
+func TestBitDepth(t *testing.T) {
+    for _, depth := range []int{8, 16} {
+        storage := newMemStorage()
+        storage.WriteFile("in/a.png", pngData(t, ramp16(64, 48)))
+        th := testThumbnailer(32, 24)
+        th.Storage = storage
+        th.Single = true
+        th.Flip = false
+        th.BitDepth = depth
+        if err := th.ProcessFile("in/a.png", "out"); err != nil {
+            t.Fatal(err)
+        }
+        data, err := storage.ReadFile("out/a.png")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        img := decodeData(t, data)
+        if depth == 8 {
+            if isDeep(img) {
+                t.Error("Depth 8: wrote a 16-bit PNG")
+            }
+            continue
+        }
+        if !isDeep(img) {
+            t.Fatalf("Depth 16: decodes as %T", img)
+        }
+        worst := 0
+        // Clear of the edges, each column averages two of the source's.
+        for y := 2; y < 22; y++ {
+            for x := 2; x < 30; x++ {
+                got := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+                want := color.NRGBA64{uint16(0x4000 + 37 * (2 * x) + 18), 0x1234, 0xABCD, 0xFFFF}
+                worst = max(worst, diff16(got.R, want.R), diff16(got.G, want.G), diff16(got.B, want.B), diff16(got.A, want.A))
+            }
+        }
+        if worst > 4 {
+            t.Errorf("Depth 16: off by up to %d of 65535", worst)
+        }
+    }
+}
+
+func diff16(a, b uint16) int {
+    return max(int(a) - int(b), int(b) - int(a))
+}
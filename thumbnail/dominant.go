@@ -0,0 +1,76 @@
+package thumbnail
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+)
+
+//=============================================================================
+
+// The dominant color is the histogram's mode, binned at 4 bits a channel
+// so a gradient's near-identical shades count together, then averaged
+// within the winning bin so the answer doesn't snap to a bin's corner.
+// It's run on the thumbnails, which are small enough for it to be cheap.
+
+const dominantBits = 4
+
+// DominantColor is img's most common color. Mostly transparent pixels
+// don't count; an image of nothing else comes out transparent.
+func DominantColor(img image.Image) color.NRGBA {
+    type bin struct {
+        count   int
+        r, g, b int
+    }
+    bins := make(map[int]*bin)
+
+    var best *bin
+    b := img.Bounds()
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+            if c.A < 0x80 {
+                continue
+            }
+
+            shift := 8 - dominantBits
+            key := int(c.R >> shift) << (2 * dominantBits) | int(c.G >> shift) << dominantBits | int(c.B >> shift)
+            entry := bins[key]
+            if entry == nil {
+                entry = &bin{}
+                bins[key] = entry
+            }
+            entry.count += 1
+            entry.r += int(c.R)
+            entry.g += int(c.G)
+            entry.b += int(c.B)
+
+            if best == nil || entry.count > best.count {
+                best = entry
+            }
+        }
+    }
+
+    if best == nil {
+        return color.NRGBA{}
+    }
+    n := best.count
+    return color.NRGBA{uint8((best.r + n / 2) / n), uint8((best.g + n / 2) / n), uint8((best.b + n / 2) / n), 0xFF}
+}
+
+// dominantHex is Output.Color for thumb.
+func (t *Thumbnailer) dominantHex(thumb image.Image) string {
+    if !t.DominantColors {
+        return ""
+    }
+    return HexColor(DominantColor(thumb))
+}
+
+// HexColor is the #RRGGBB spelling of c that ParseHexColor reads, or
+// #RRGGBBAA if it isn't opaque.
+func HexColor(c color.NRGBA) string {
+    if c.A == 0xFF {
+        return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+    }
+    return fmt.Sprintf("#%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
@@ -0,0 +1,78 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "testing"
+)
+
+//=============================================================================
+
+// stripes is 10 pixels wide, painted from the left in the given widths
+// and colors.
+func stripes(widths []int, colors []color.NRGBA) *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, 10, 4))
+    x := 0
+    for i, w := range widths {
+        draw.Draw(img, image.Rect(x, 0, x + w, 4), image.NewUniform(colors[i]), image.Point{}, draw.Src)
+        x += w
+    }
+    return img
+}
+
+func TestDominantColor(t *testing.T) {
+    red, blue := color.NRGBA{200, 10, 10, 255}, color.NRGBA{0x33, 0x66, 0xCC, 255}
+    tests := []struct {
+        name   string
+        img    *image.NRGBA
+        want   color.NRGBA
+    }{
+        {"majority", stripes([]int{3, 3, 4}, []color.NRGBA{red, {0, 255, 0, 255}, blue}), blue},
+        // Neither shade wins alone, but they share a bin, which beats blue.
+        {"close shades", stripes([]int{3, 3, 4}, []color.NRGBA{red, {202, 12, 14, 255}, blue}), color.NRGBA{201, 11, 12, 255}},
+        {"transparent ignored", stripes([]int{7, 3}, []color.NRGBA{{255, 255, 255, 0}, red}), red},
+        {"all transparent", stripes([]int{10}, []color.NRGBA{{255, 255, 255, 0}}), color.NRGBA{}},
+    }
+    for _, test := range tests {
+        if got := DominantColor(test.img); got != test.want {
+            t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+        }
+    }
+}
+
+func TestHexColor(t *testing.T) {
+    tests := map[color.NRGBA]string{
+        {0x33, 0x66, 0xCC, 0xFF}: "#3366CC",
+        {0, 0, 0, 0}: "#00000000",
+        {1, 2, 3, 0x80}: "#01020380",
+    }
+    for c, want := range tests {
+        if got := HexColor(c); got != want {
+            t.Errorf("%v: got %s, want %s", c, got, want)
+        }
+        if back, err := ParseHexColor(want); err != nil || back != c {
+            t.Errorf("%s read back as %v, %v", want, back, err)
+        }
+    }
+}
+
+// Output.Color is the dominant color of each thumbnail, with
+// DominantColors.
+func TestOutputColor(t *testing.T) {
+    storage := newMemStorage()
+    storage.WriteFile("in/a.png", pngData(t, solidImage(32, 32, color.NRGBA{0x33, 0x66, 0xCC, 255})))
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    th.InMemory = true
+    th.DominantColors = true
+    result, err := th.Process("in/a.png", "out")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, o := range result.Outputs {
+        if o.Color != "#3366CC" {
+            t.Errorf("%s: color %q", o.Key(), o.Color)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package thumbnail
+
+import (
+    "errors"
+    "fmt"
+)
+
+//=============================================================================
+
+// ProcessFile reports inputs it deliberately skipped, or couldn't make
+// sense of at all, with these, so callers can tell them apart from real
+// failures.
+
+// ErrUndersized is wrapped by ProcessFile's error for inputs smaller than
+// Dim on either axis, unless AllowUpscale is set.
+var ErrUndersized = errors.New("smaller than the thumbnail")
+
+// ErrExists is wrapped by ProcessFile's error for inputs whose outputs are
+// all present, when SkipExisting is set.
+var ErrExists = errors.New("thumbnails already exist")
+
+// ErrCorrupt is wrapped by ProcessFile's error for inputs that fail to
+// decode or are evidently truncated.
+var ErrCorrupt = errors.New("corrupt or truncated")
+
+// ErrAnimated is wrapped by ProcessFile's error for inputs with more than
+// one frame, when RejectAnimated is set.
+var ErrAnimated = errors.New("animated or multi-frame")
+
+// ErrTooLarge is wrapped by ProcessFile's error for inputs whose header
+// declares more than MaxPixels, which are never decoded.
+var ErrTooLarge = errors.New("too large to decode")
+
+// DuplicateError is returned by ProcessFile for inputs it skipped because
+// an earlier input had the same checksum.
+type DuplicateError struct {
+    Path     string
+    Original string
+}
+
+func (e *DuplicateError) Error() string {
+    return fmt.Sprintf("%s is a duplicate of %s", e.Path, e.Original)
+}
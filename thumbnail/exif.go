@@ -0,0 +1,115 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "github.com/disintegration/gift"
+    "image"
+)
+
+//=============================================================================
+
+// Only the Orientation tag is needed, so this is just enough of an EXIF
+// reader to find it in a JPEG's APP1 segment. Anything malformed reads as
+// orientation 1 (as stored) rather than an error; a wrong rotation is
+// better than a dropped image.
+
+const exifOrientationTag = 0x0112
+
+func exifOrientation(data []byte) int {
+    if tiff := exifTIFF(data); tiff != nil {
+        return tiffOrientation(tiff)
+    }
+    return 1
+}
+
+// exifTIFF returns the TIFF structure in a JPEG's EXIF segment, or nil.
+func exifTIFF(data []byte) []byte {
+    if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+        return nil // Not a JPEG.
+    }
+
+    pos := 2
+    for pos + 4 <= len(data) {
+        if data[pos] != 0xFF {
+            return nil
+        }
+        marker := data[pos + 1]
+        size := int(binary.BigEndian.Uint16(data[pos + 2:]))
+
+        // Start of scan; the metadata segments are all behind us.
+        if marker == 0xDA || size < 2 || pos + 2 + size > len(data) {
+            return nil
+        }
+
+        segment := data[pos + 4:pos + 2 + size]
+        if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+            return segment[6:]
+        }
+
+        pos += 2 + size
+    }
+
+    return nil
+}
+
+func tiffOrientation(tiff []byte) int {
+    if len(tiff) < 8 {
+        return 1
+    }
+
+    var order binary.ByteOrder
+    switch string(tiff[:2]) {
+    case "II":
+        order = binary.LittleEndian
+    case "MM":
+        order = binary.BigEndian
+    default:
+        return 1
+    }
+
+    ifd := int(order.Uint32(tiff[4:]))
+    if ifd < 0 || ifd + 2 > len(tiff) {
+        return 1
+    }
+
+    entries := int(order.Uint16(tiff[ifd:]))
+    for i := 0; i < entries; i++ {
+        entry := ifd + 2 + i * 12
+        if entry + 12 > len(tiff) {
+            return 1
+        }
+        if order.Uint16(tiff[entry:]) == exifOrientationTag {
+            v := int(order.Uint16(tiff[entry + 8:]))
+            if v < 1 || v > 8 {
+                return 1
+            }
+            return v
+        }
+    }
+
+    return 1
+}
+
+// Indexed by EXIF orientation value; each undoes what the camera recorded.
+var ORIENTATION_FILTERS = [9]gift.Filter{
+    2: gift.FlipHorizontal(),
+    3: gift.Rotate180(),
+    4: gift.FlipVertical(),
+    5: gift.Transpose(),
+    6: gift.Rotate270(),
+    7: gift.Transverse(),
+    8: gift.Rotate90(),
+}
+
+func applyOrientation(src image.Image, orientation int) image.Image {
+    if orientation < 2 || orientation > 8 {
+        return src
+    }
+
+    g := gift.New(ORIENTATION_FILTERS[orientation])
+    dst := image.NewNRGBA(g.Bounds(src.Bounds()))
+    g.Draw(dst, src)
+
+    return dst
+}
@@ -0,0 +1,117 @@
+package thumbnail
+
+import (
+    "encoding/binary"
+    "github.com/disintegration/gift"
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+// exifSegmentFor is an APP1 segment with nothing in it but orientation,
+// little-endian, as phones write it.
+func exifSegmentFor(orientation int) []byte {
+    tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+    entry := make([]byte, 12)
+    binary.LittleEndian.PutUint16(entry, exifOrientationTag)
+    binary.LittleEndian.PutUint16(entry[2:], tiffShort)
+    binary.LittleEndian.PutUint32(entry[4:], 1)
+    binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+    tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+
+    payload := append([]byte("Exif\x00\x00"), tiff...)
+    segment := []byte{0xFF, 0xE1, 0, 0}
+    binary.BigEndian.PutUint16(segment[2:], uint16(len(payload) + 2))
+    return append(segment, payload...)
+}
+
+// quadrants is 32x16, red, green, blue and white clockwise from the top
+// left, so any turn or flip of it tells which.
+func quadrants() *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+    colors := [2][2]color.NRGBA{
+        {{255, 0, 0, 255}, {0, 255, 0, 255}},
+        {{255, 255, 255, 255}, {0, 0, 255, 255}},
+    }
+    for y := 0; y < 16; y++ {
+        for x := 0; x < 32; x++ {
+            img.SetNRGBA(x, y, colors[y / 8][x / 16])
+        }
+    }
+    return img
+}
+
+// Each orientation's stored pixels are the upright image turned the other
+// way; decoding them with auto-orientation gives the upright one back.
+func TestOrientations(t *testing.T) {
+    stored := [9]gift.Filter{
+        2: gift.FlipHorizontal(),
+        3: gift.Rotate180(),
+        4: gift.FlipVertical(),
+        5: gift.Transpose(),
+        6: gift.Rotate90(),
+        7: gift.Transverse(),
+        8: gift.Rotate270(),
+    }
+    upright := quadrants()
+
+    for orientation := 1; orientation <= 8; orientation++ {
+        var src image.Image = upright
+        if f := stored[orientation]; f != nil {
+            g := gift.New(f)
+            dst := image.NewNRGBA(g.Bounds(upright.Bounds()))
+            g.Draw(dst, upright)
+            src = dst
+        }
+        data := withSegment(jpegData(t, src, 95), exifSegmentFor(orientation))
+
+        if got := exifOrientation(data); got != orientation {
+            t.Errorf("Orientation %d read as %d", orientation, got)
+        }
+
+        th := New()
+        img, err := th.Decode(data)
+        if err != nil {
+            t.Fatalf("Orientation %d: %v", orientation, err)
+        }
+        if img.Bounds().Size() != upright.Bounds().Size() {
+            t.Errorf("Orientation %d: size %v, want %v", orientation, img.Bounds().Size(), upright.Bounds().Size())
+            continue
+        }
+        // Quadrant centers, clear of JPEG's bleeding at the edges.
+        for _, p := range []image.Point{{8, 4}, {24, 4}, {8, 12}, {24, 12}} {
+            want := upright.NRGBAAt(p.X, p.Y)
+            got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+            if diff(got.R, want.R) > 40 || diff(got.G, want.G) > 40 || diff(got.B, want.B) > 40 {
+                t.Errorf("Orientation %d: %v is %v, want %v", orientation, p, got, want)
+            }
+        }
+
+        // Left as stored without AutoOrient.
+        th.AutoOrient = false
+        if img, _ := th.Decode(data); img.Bounds().Size() != src.Bounds().Size() {
+            t.Errorf("Orientation %d turned without AutoOrient", orientation)
+        }
+    }
+}
+
+func diff(a, b uint8) int {
+    return max(int(a) - int(b), int(b) - int(a))
+}
+
+func TestMalformedOrientation(t *testing.T) {
+    plain := jpegData(t, quadrants(), 90)
+    tests := map[string][]byte{
+        "no exif": plain,
+        "out of range": withSegment(plain, exifSegmentFor(9)),
+        "truncated": withSegment(plain, exifSegmentFor(6)[:20]),
+        "not a jpeg": pngData(t, quadrants()),
+    }
+    for name, data := range tests {
+        if got := exifOrientation(data); got != 1 {
+            t.Errorf("%s: got %d, want 1", name, got)
+        }
+    }
+}
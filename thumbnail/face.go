@@ -0,0 +1,78 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "sort"
+)
+
+//=============================================================================
+
+// The smart-face anchor keeps whatever FaceDetector finds in frame. The
+// built-in one is a skin-tone heuristic: no model to ship, and good enough
+// to keep people from being cropped off the side of a portrait. Anything
+// better (a cascade, a CNN behind cgo) can be dropped in as a Detector.
+
+// A Detector finds the region of src a crop should keep, if any.
+type Detector interface {
+    Detect(src image.Image) (image.Rectangle, bool)
+}
+
+// FaceDetector is what smart-face crops with.
+var FaceDetector Detector = SkinDetector{}
+
+// SkinDetector finds the bulk of the skin-toned pixels in an image.
+type SkinDetector struct{}
+
+// Skin below this share of the image is noise: a wooden table, a hand.
+const minSkinShare = 0.005
+
+// isSkin is the usual RGB rule of thumb for skin under daylight.
+func isSkin(c color.NRGBA) bool {
+    r, g, b := int(c.R), int(c.G), int(c.B)
+    hi := max(r, max(g, b))
+    lo := min(r, min(g, b))
+    return c.A > 127 && r > 95 && g > 40 && b > 20 && hi - lo > 15 && r - g > 15 && r > b
+}
+
+func (SkinDetector) Detect(src image.Image) (image.Rectangle, bool) {
+    b := src.Bounds()
+    var xs, ys []int
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            if isSkin(color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)) {
+                xs = append(xs, x)
+                ys = append(ys, y)
+            }
+        }
+    }
+    if float64(len(xs)) < minSkinShare * float64(b.Dx() * b.Dy()) {
+        return image.Rectangle{}, false
+    }
+
+    // The middle 80% each way, so stray skin-colored specks don't stretch
+    // the box to the edges.
+    sort.Ints(xs)
+    sort.Ints(ys)
+    lo, hi := len(xs) / 10, len(xs) * 9 / 10
+    return image.Rect(xs[lo], ys[lo], xs[hi - 1] + 1, ys[hi - 1] + 1), true
+}
+
+// faceCrop returns the w x h window of src centered on what FaceDetector
+// finds, slid back inside src where it would overhang, or the centered
+// window when it finds nothing.
+func faceCrop(src image.Image, w, h int) image.Rectangle {
+    b := src.Bounds()
+    if w >= b.Dx() && h >= b.Dy() {
+        return b
+    }
+
+    center := image.Pt((b.Min.X + b.Max.X) / 2, (b.Min.Y + b.Max.Y) / 2)
+    if r, found := FaceDetector.Detect(src); found {
+        center = image.Pt((r.Min.X + r.Max.X) / 2, (r.Min.Y + r.Max.Y) / 2)
+    }
+
+    x := min(max(center.X - w / 2, b.Min.X), b.Max.X - w)
+    y := min(max(center.Y - h / 2, b.Min.Y), b.Max.Y - h)
+    return image.Rect(x, y, x + w, y + h)
+}
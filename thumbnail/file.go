@@ -0,0 +1,689 @@
+package thumbnail
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/jpeg"
+    "image/png"
+    "io"
+    "path/filepath"
+    "strings"
+    _ "golang.org/x/image/bmp"
+    _ "golang.org/x/image/tiff"
+    _ "image/gif"
+)
+
+//=============================================================================
+
+// readFile returns path's bytes and their keys: the hex digest under
+// t.Hash and, with QuickHash, the quick one.
+func (t *Thumbnailer) readFile(path string) (data []byte, keys fileKeys, err error) {
+    err = t.retry("read", path, func() (err error) {
+        data, err = t.storage().ReadFile(path)
+        return err
+    })
+    if err != nil {
+        return nil, keys, err
+    }
+
+    h := HASHES[t.Hash]()
+    h.Write(data)
+    keys.checksum = hex.EncodeToString(h.Sum(nil))
+    if t.QuickHash {
+        keys.quick = t.quickKey(int64(len(data)), data[:min(len(data), quickHashBytes)], data[max(0, len(data) - quickHashBytes):])
+    }
+
+    return data, keys, nil
+}
+
+// Checksum is the hex digest of path's bytes under t.Hash, as Results
+// carry it.
+func (t *Thumbnailer) Checksum(path string) (string, error) {
+    _, keys, err := t.readFile(path)
+    return keys.checksum, err
+}
+
+// decodeImage is image.Decode, except a decoder panic (the TIFF one has
+// been known to, on odd subtypes) comes back as an error.
+func decodeImage(data []byte) (img image.Image, format string, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            img, err = nil, fmt.Errorf("%w: decoder panicked: %v", errUnsupported, r)
+        }
+    }()
+
+    return image.Decode(bytes.NewReader(data))
+}
+
+// readImage also returns the format name image.Decode gave, e.g. "jpeg".
+func (t *Thumbnailer) readImage(path string) (img image.Image, format string, keys fileKeys, err error) {
+    data, keys, err := t.readFile(path)
+    if err != nil {
+        return nil, "", keys, err
+    }
+
+    img, format, err = t.decode(data)
+    if err != nil {
+        return nil, "", keys, err
+    }
+    return img, format, keys, nil
+}
+
+// Decode turns an encoded image into what Thumbnail expects, honoring
+// GifFrame and AutoOrient the way Process does. Data that won't decode, or
+// is cut short, comes back as an error wrapping ErrCorrupt.
+func (t *Thumbnailer) Decode(data []byte) (img image.Image, err error) {
+    img, _, err = t.decode(data)
+    return img, err
+}
+
+// decode is Decode, plus the format name.
+func (t *Thumbnailer) decode(data []byte) (img image.Image, format string, err error) {
+    if err := checkComplete(data); err != nil {
+        return nil, "", err
+    }
+    if err := t.checkAnimated(data); err != nil {
+        return nil, "", err
+    }
+    if err := t.checkHeaderPixels(data); err != nil {
+        return nil, "", err
+    }
+
+    // Multi-page TIFFs decode as their first page.
+    if t.GifFrame != "first" && isGIF(data) {
+        img, err = decodeGIFFrame(data, t.GifFrame)
+        format = "gif"
+    } else {
+        img, format, err = decodeImage(data)
+        if isBareCMYK(err) {
+            img, err = decodeBareCMYK(data)
+        }
+    }
+    if err != nil {
+        return nil, "", corruptError(err)
+    }
+    img = cmykToNRGBA(img)
+    if t.ICCConvert {
+        img = toSRGB(img, data)
+    }
+    if err := checkBounds(img.Bounds().Size()); err != nil {
+        return nil, "", err
+    }
+
+    if t.AutoOrient {
+        img = applyOrientation(img, exifOrientation(data))
+    }
+
+    return img, format, nil
+}
+
+// checkPixels fails a size over MaxPixels.
+func (t *Thumbnailer) checkPixels(size image.Point) error {
+    if t.MaxPixels > 0 && int64(size.X) * int64(size.Y) > t.MaxPixels {
+        return fmt.Errorf("%w: %dx%d is over %d pixels", ErrTooLarge, size.X, size.Y, t.MaxPixels)
+    }
+    return nil
+}
+
+// checkHeaderPixels is checkPixels on the size data's header declares,
+// which a decompression bomb can make large enough to run out of memory
+// on allocating the pixels alone. Reading the header allocates nothing
+// like that. Headers that won't parse are left for the decoder to fail
+// on properly.
+func (t *Thumbnailer) checkHeaderPixels(data []byte) (err error) {
+    if t.MaxPixels <= 0 {
+        return nil
+    }
+    defer func() {
+        if recover() != nil {
+            err = nil
+        }
+    }()
+
+    config, _, configErr := image.DecodeConfig(bytes.NewReader(data))
+    if configErr != nil {
+        return nil
+    }
+    return t.checkPixels(image.Pt(config.Width, config.Height))
+}
+
+// readConfig is readImage for callers that only need the size and format;
+// it skips decoding the pixels.
+func (t *Thumbnailer) readConfig(path string) (size image.Point, format string, keys fileKeys, err error) {
+    data, keys, err := t.readFile(path)
+    if err != nil {
+        return size, "", keys, err
+    }
+    size, format, err = t.decodeConfig(data)
+    return size, format, keys, err
+}
+
+// decodeConfig is decode for just the size and format.
+func (t *Thumbnailer) decodeConfig(data []byte) (size image.Point, format string, err error) {
+    if err := checkComplete(data); err != nil {
+        return size, "", err
+    }
+    if err := t.checkAnimated(data); err != nil {
+        return size, "", err
+    }
+    config, format, err := image.DecodeConfig(bytes.NewReader(data))
+    if err != nil {
+        return size, "", corruptError(err)
+    }
+    size = image.Pt(config.Width, config.Height)
+    if err := checkBounds(size); err != nil {
+        return size, "", err
+    }
+    if err := t.checkPixels(size); err != nil {
+        return size, "", err
+    }
+
+    // Orientations 5-8 are rotated a quarter turn.
+    if t.AutoOrient && exifOrientation(data) >= 5 {
+        size = image.Pt(size.Y, size.X)
+    }
+
+    return size, format, nil
+}
+
+// JPEG has no alpha channel. Without flattening, the encoder just drops
+// alpha and transparent regions (usually zeroed) come out black.
+func flattenAlpha(img image.Image, bg color.Color) image.Image {
+    var dst draw.Image = image.NewRGBA(img.Bounds())
+    if isDeep(img) {
+        dst = image.NewRGBA64(img.Bounds())
+    }
+    draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+    draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+    return dst
+}
+
+// toGray repacks an opaque, already-desaturated image as gray (16-bit for
+// deep ones), which PNG stores in a quarter of the space. Images with
+// alpha are left alone since Gray would drop it.
+func toGray(img image.Image) image.Image {
+    if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+        return img
+    }
+
+    var dst draw.Image = image.NewGray(img.Bounds())
+    if isDeep(img) {
+        dst = image.NewGray16(img.Bounds())
+    }
+    draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+    return dst
+}
+
+func (t *Thumbnailer) flattenBackground() color.Color {
+    if t.FlattenBackground == nil {
+        return color.White
+    }
+    return t.FlattenBackground
+}
+
+// outputFormat is what a source in the given format is thumbnailed as:
+// the same format with MatchFormat, if there's an encoder for it, or else
+// Format.
+func (t *Thumbnailer) outputFormat(source string) string {
+    if _, found := FORMAT_EXTENSIONS[source]; t.MatchFormat && found {
+        return source
+    }
+    return t.Format
+}
+
+// Encode writes img to w according to Format, Quality and PNGCompression.
+func (t *Thumbnailer) Encode(w io.Writer, img image.Image) error {
+    return t.encode(w, img, t.Format)
+}
+
+// encode is Encode in a format other than Format.
+func (t *Thumbnailer) encode(w io.Writer, img image.Image, format string) error {
+    switch format {
+    case "jpeg":
+        img = flattenAlpha(img, t.flattenBackground())
+        if t.Progressive {
+            return encodeProgressive(w, img, t.Quality)
+        }
+        opts := jpeg.Options{Quality: t.Quality}
+        return jpeg.Encode(w, img, &opts)
+    case "webp":
+        if t.Flatten {
+            img = flattenAlpha(img, t.flattenBackground())
+        }
+        return encodeWebP(w, img, t.Quality)
+    default:
+        if t.Flatten {
+            img = flattenAlpha(img, t.flattenBackground())
+        }
+        if t.Palette > 0 {
+            img = quantize(img, t.Palette)
+        } else if t.Grayscale {
+            img = toGray(img)
+        }
+        encoder := png.Encoder{CompressionLevel: PNG_COMPRESSIONS[t.PNGCompression], BufferPool: pngBuffers}
+        return encoder.Encode(w, img)
+    }
+}
+
+// encodeThumb encodes in memory first, so a failed encode never reaches
+// storage at all. A non-nil exif segment goes into JPEGs, and with
+// EmbedChecksum, a non-empty checksum into PNGs. Either gets DPI. PNGs are
+// optimized before any chunks go in.
+func (t *Thumbnailer) encodeThumb(img image.Image, format string, exif []byte, checksum string) ([]byte, error) {
+    var buf bytes.Buffer
+    if err := t.encode(&buf, img, format); err != nil {
+        return nil, err
+    }
+
+    data := buf.Bytes()
+    if format == "png" && t.Optimize {
+        var saved int
+        data, saved = optimizePNG(data)
+        t.optimized.Add(int64(saved))
+    }
+    if format == "jpeg" {
+        // JFIF's APP0 has to come first, so it goes in last.
+        data = withSegment(data, exif)
+        data = withSegment(data, jfifSegment(t.DPI))
+    }
+    if format == "png" && t.EmbedChecksum && checksum != "" {
+        data = withChunk(data, textChunk(ChecksumKey(t.Hash), checksum))
+    }
+    if format == "png" {
+        data = withChunk(data, physChunk(t.DPI))
+    }
+    return data, nil
+}
+
+func (t *Thumbnailer) writeThumb(path string, data []byte) error {
+    return t.retry("write", path, func() error {
+        return t.storage().WriteFile(path, data)
+    })
+}
+
+//=============================================================================
+
+// OutputStem is the part of every output name taken from the input: its
+// base name up to the first dot, past any it starts with, so a hidden
+// .photo.jpg is .photo.
+func OutputStem(inputPath string) string {
+    name := filepath.Base(inputPath)
+    lead := len(name) - len(strings.TrimLeft(name, "."))
+    if j := strings.Index(name[lead:], "."); j != -1 {
+        name = name[:lead + j]
+    }
+    return name
+}
+
+func (t *Thumbnailer) thumbPath(outputDir, stem, key, format string) string {
+    if t.Sprite != "" {
+        key = spriteKey
+    }
+    if t.Single {
+        return JoinPath(outputDir, stem + FORMAT_EXTENSIONS[format])
+    }
+    return JoinPath(outputDir, stem + "_" + key + FORMAT_EXTENSIONS[format])
+}
+
+// OutputPaths lists the outputs ProcessAs writes for stem in outputDir,
+// given the source's format, without writing them; e.g. to point a
+// duplicate's outputs at its original's.
+func (t *Thumbnailer) OutputPaths(outputDir, stem, source string) []Output {
+    var outputs []Output
+    for _, v := range t.variants() {
+        outputs = append(outputs, Output{Variant: v, Path: t.thumbPath(outputDir, stem, v.Key(), t.outputFormat(source))})
+    }
+    return outputs
+}
+
+// outputsExist reports whether every thumbnail named after stem is already
+// in outputDir, in the given format, and non-empty.
+func (t *Thumbnailer) outputsExist(outputDir, stem, format string) bool {
+    for _, v := range t.variants() {
+        size, err := t.storage().Size(t.thumbPath(outputDir, stem, v.Key(), format))
+        if err != nil || size == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Output is one thumbnail written by Process.
+type Output struct {
+    Variant
+    Path  string          // Empty with InMemory.
+    Image image.Image     // Only kept with InMemory.
+    Data  []byte          // The encoded output, only kept with Deferred until Commit.
+    Tile  image.Rectangle // With Sprite, where in Path this variant is.
+    Color string          // Dominant color as #RRGGBB, with DominantColors.
+
+    written bool // Path is this run's, for removeOutputs to take back.
+}
+
+// Result describes what Process did with one input. It's filled in as far
+// as processing got, so skipped and failed inputs report what was known.
+type Result struct {
+    Input    string
+    Checksum string      // Hex digest of the input; empty if never read whole.
+    Format   string      // Of the source as image.Decode names it, e.g. "jpeg"; empty if never read.
+    Original string      // For a skipped duplicate, the input it duplicates.
+    BlurHash string      // Of the source, with BlurHashX and BlurHashY; empty if the pixels weren't decoded.
+    Size     image.Point // Of the decoded source; zero if never decoded.
+    Outputs  []Output
+}
+
+// ProcessFile thumbnails inputPath into outputDir, creating it if needed.
+// Outputs are named after the input's base name plus the variant key,
+// e.g. photo.jpg becomes photo_center.png (or photo.png with Single). Inputs skipped as duplicates
+// return a *DuplicateError; undersized ones wrap ErrUndersized, corrupt
+// ones ErrCorrupt, and with SkipExisting, already thumbnailed ones wrap
+// ErrExists.
+func (t *Thumbnailer) ProcessFile(inputPath, outputDir string) error {
+    _, err := t.Process(inputPath, outputDir)
+    return err
+}
+
+// Process is ProcessFile, but also reports what was written.
+func (t *Thumbnailer) Process(inputPath, outputDir string) (*Result, error) {
+    return t.ProcessAs(context.Background(), inputPath, outputDir, OutputStem(inputPath))
+}
+
+// Inspect fully decodes inputPath and puts it through ProcessAs's checks
+// (corrupt, undersized, duplicate, with the same errors) without making
+// any thumbnails; e.g. to audit a dataset. Like ProcessAs, it registers
+// the input with deduplication.
+func (t *Thumbnailer) Inspect(inputPath string) (*Result, error) {
+    result := &Result{Input: inputPath}
+
+    img, source, keys, err := t.readImage(inputPath)
+    if err != nil {
+        return result, err
+    }
+    result.Size = img.Bounds().Size()
+    result.Checksum = keys.checksum
+    result.Format = source
+
+    if err := t.checkSize(inputPath, result.Size); err != nil {
+        return result, err
+    }
+
+    if t.Deduplicate {
+        if original, dupe := t.isDupe(keys, img, inputPath); dupe {
+            result.Original = original
+            return result, &DuplicateError{Path: inputPath, Original: original}
+        }
+    }
+    return result, nil
+}
+
+// checkSize fails a source of size that Crop misses, or that's undersized.
+// Upscaled thumbnails are mostly blur, and they pollute training sets.
+func (t *Thumbnailer) checkSize(inputPath string, size image.Point) error {
+    if !t.Crop.Empty() {
+        cropped := t.cropBounds(image.Rectangle{Max: size})
+        if cropped.Empty() {
+            return fmt.Errorf("%s: crop %v is outside its %dx%d", inputPath, t.Crop, size.X, size.Y)
+        }
+        if cropped.Size() != t.Crop.Size() {
+            t.warnf("Crop %v clamped to %v for %dx%d %s", t.Crop, cropped, size.X, size.Y, inputPath)
+        }
+        size = cropped.Size()
+    }
+    if !t.AllowUpscale && t.Undersized(size) {
+        return fmt.Errorf("%s is %dx%d, %w", inputPath, size.X, size.Y, ErrUndersized)
+    }
+    return nil
+}
+
+// ProcessAs is Process with the outputs named after stem rather than the
+// input, for callers with their own naming scheme. It gives up between
+// steps once ctx is done, returning an error wrapping ctx.Err(); nothing is
+// left half-written, and an input that fails isn't kept as the original
+// of its duplicates.
+func (t *Thumbnailer) ProcessAs(ctx context.Context, inputPath, outputDir, stem string) (result *Result, err error) {
+    result = &Result{Input: inputPath}
+
+    // Under OutputDir, which outputs to look for hangs on the checksum, so
+    // the input is read first, and everything below works from that.
+    var data []byte
+    var keys fileKeys
+    if t.OutputDir != nil {
+        if data, keys, err = t.readFile(inputPath); err != nil {
+            return result, err
+        }
+        result.Checksum = keys.checksum
+        outputDir = t.OutputDir(inputPath, outputDir, keys.checksum)
+    }
+
+    // Checked before decoding, which is the whole point. The checksum is
+    // still registered so a rerun doesn't resurrect this input's dupes;
+    // phash needs the pixels though, so it can't be. With QuickHash that
+    // only reads the ends, unless they match another input's (or OutputDir
+    // read it all already). With MatchFormat the
+    // header says which outputs to look for; a source that won't even give
+    // one is left to fail properly below.
+    existingFormat := t.Format
+    if t.SkipExisting && t.MatchFormat {
+        var source string
+        var configErr error
+        if data != nil {
+            _, source, configErr = t.decodeConfig(data)
+        } else {
+            _, source, _, configErr = t.readConfig(inputPath)
+        }
+        if configErr == nil {
+            result.Format = source
+            existingFormat = t.outputFormat(source)
+        }
+    }
+    if t.SkipExisting && t.outputsExist(outputDir, stem, existingFormat) {
+        if t.Deduplicate && t.DedupeMode == "crc32" {
+            var keysErr error
+            if data == nil {
+                keys, keysErr = t.dedupeKeys(inputPath)
+            }
+            if keysErr == nil {
+                result.Checksum = keys.checksum
+                t.isDupe(keys, nil, inputPath)
+            }
+        }
+        return result, fmt.Errorf("%s: %w", inputPath, ErrExists)
+    }
+
+    var img image.Image
+    var source string
+    dryRun := t.DryRun && t.DedupeMode != "phash"
+    switch {
+    case data != nil && dryRun:
+        // A dry run only reports sizes and checksums; skip the pixels.
+        result.Size, source, err = t.decodeConfig(data)
+    case data != nil:
+        img, source, err = t.decode(data)
+    case dryRun:
+        result.Size, source, keys, err = t.readConfig(inputPath)
+    default:
+        img, source, keys, err = t.readImage(inputPath)
+    }
+    if err == nil && img != nil {
+        result.Size = img.Bounds().Size()
+    }
+
+    // Check the error first; a failed read has no meaningful checksum.
+    if err != nil {
+        return result, err
+    }
+    checksum := keys.checksum
+    result.Checksum = checksum
+    result.Format = source
+    format := t.outputFormat(source)
+
+    if err := t.checkSize(inputPath, result.Size); err != nil {
+        return result, err
+    }
+
+    // Decoding is the slow part, and past here is shared dedup state.
+    if err := ctx.Err(); err != nil {
+        return result, fmt.Errorf("%s: %w", inputPath, err)
+    }
+
+    if t.Deduplicate {
+        if original, dupe := t.isDupe(keys, img, inputPath); dupe {
+            result.Original = original
+            return result, &DuplicateError{Path: inputPath, Original: original}
+        }
+        // Inputs duplicating it since are lost, but later ones needn't be.
+        defer func() {
+            if err != nil {
+                t.forget(inputPath)
+            }
+        }()
+    }
+
+    if t.BlurHashX > 0 && img != nil {
+        result.BlurHash = BlurHash(img, t.BlurHashX, t.BlurHashY)
+    }
+
+    if t.DryRun {
+        for _, v := range t.variants() {
+            f_p := t.thumbPath(outputDir, stem, v.Key(), format)
+            t.logf("Would save %s", f_p)
+            result.Outputs = append(result.Outputs, Output{Variant: v, Path: f_p})
+        }
+        return result, nil
+    }
+
+    var thumbs map[string]image.Image
+    var copied []byte
+    if t.isPassthrough(result.Size) {
+        thumbs = t.passthroughThumbs(img)
+        if !t.InMemory && t.Sprite == "" {
+            copied = t.passthroughBytes(inputPath, format)
+        }
+    } else {
+        thumbs = t.thumbnail(img, checksum)
+    }
+    if !t.InMemory {
+        defer t.Release(thumbs)
+    }
+
+    if t.InMemory {
+        for _, v := range t.variants() {
+            thumb := thumbs[v.Key()]
+            result.Outputs = append(result.Outputs, Output{Variant: v, Image: thumb, Color: t.dominantHex(thumb)})
+        }
+        return result, nil
+    }
+
+    if !t.Deferred {
+        if err := t.storage().MkdirAll(outputDir); err != nil {
+            return result, err
+        }
+    }
+
+    // Another read, but only when asked for; the decoders don't keep it.
+    var exif []byte
+    if t.PreserveMetadata && format == "jpeg" {
+        if data, err := t.storage().ReadFile(inputPath); err == nil {
+            exif = readMetadata(data).exifSegment(t.AutoOrient)
+        }
+    }
+
+    if t.Sprite != "" {
+        return t.writeSprite(ctx, result, thumbs, t.thumbPath(outputDir, stem, spriteKey, format), format, exif)
+    }
+
+    // All or nothing: a half-thumbnailed input would look done to a rerun.
+    for _, v := range t.variants() {
+        f_p := t.thumbPath(outputDir, stem, v.Key(), format)
+        output := Output{Variant: v, Path: f_p, Color: t.dominantHex(thumbs[v.Key()])}
+        var err error
+        if copied != nil && !v.Flipped {
+            output.Data = copied
+        } else {
+            output.Data, err = t.encodeThumb(thumbs[v.Key()], format, exif, checksum)
+        }
+        // Last thing before writing, so an abandoned input writes no more.
+        if err == nil {
+            err = abandoned(ctx, inputPath)
+        }
+        if err == nil && !t.Deferred {
+            if copied != nil && !v.Flipped {
+                t.logf("Copying %s", f_p)
+            } else {
+                t.logf("Saving %s", f_p)
+            }
+            err = t.writeThumb(f_p, output.Data)
+            output.Data = nil
+            output.written = err == nil
+        }
+        if err != nil {
+            t.removeOutputs(result)
+            return result, err
+        }
+        result.Outputs = append(result.Outputs, output)
+    }
+
+    // The last write may have outlasted ctx; if so, it's taken back.
+    if err := abandoned(ctx, inputPath); err != nil {
+        t.removeOutputs(result)
+        return result, err
+    }
+    return result, nil
+}
+
+// abandoned wraps ctx.Err() for inputPath, or is nil while ctx isn't done.
+func abandoned(ctx context.Context, inputPath string) error {
+    if err := ctx.Err(); err != nil {
+        return fmt.Errorf("%s: %w", inputPath, err)
+    }
+    return nil
+}
+
+// Discard undoes a ProcessAs that succeeded, for a caller that gave up on
+// it: it deletes what it wrote and no longer counts its input as the
+// original of its duplicates.
+func (t *Thumbnailer) Discard(result *Result) {
+    t.removeOutputs(result)
+    t.forget(result.Input)
+}
+
+// Commit writes out a result ProcessAs kept back under Deferred, all or
+// nothing like ProcessAs itself, and lets go of the encoded data. Results
+// with nothing kept back, like a dry run's, are left alone.
+func (t *Thumbnailer) Commit(result *Result) error {
+    for i, o := range result.Outputs {
+        if o.Data == nil {
+            continue
+        }
+        t.logf("Saving %s", o.Path)
+        err := t.storage().MkdirAll(DirPath(o.Path))
+        if err == nil {
+            err = t.writeThumb(o.Path, o.Data)
+        }
+        if err != nil {
+            result.Outputs = result.Outputs[:i]
+            t.removeOutputs(result)
+            return err
+        }
+        result.Outputs[i].Data = nil
+        result.Outputs[i].written = true
+    }
+    return nil
+}
+
+// removeOutputs deletes what result's outputs wrote so far, if anything,
+// and forgets them. Outputs it didn't write (kept back, a dry run's, ones
+// SkipExisting found, or a sprite's after the first) are left alone.
+func (t *Thumbnailer) removeOutputs(result *Result) {
+    for _, o := range result.Outputs {
+        if o.written {
+            t.storage().Remove(o.Path)
+        }
+    }
+    result.Outputs = nil
+}
@@ -0,0 +1,463 @@
+package thumbnail
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "image"
+    "image/gif"
+    "image/png"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+)
+
+//=============================================================================
+
+// outputsUnder lists what storage has under dir, sorted.
+func outputsUnder(storage *memStorage, dir string) []string {
+    var paths []string
+    for _, p := range storage.paths() {
+        if strings.HasPrefix(p, dir + "/") {
+            paths = append(paths, p)
+        }
+    }
+    return paths
+}
+
+func TestThumbnailModes(t *testing.T) {
+    tests := []struct {
+        mode string
+        want []string
+    }{
+        {"crop", []string{"center", "center_flipped", "left", "left_flipped", "right", "right_flipped"}},
+        {"fit", []string{"fit", "fit_flipped"}},
+        {"stretch", []string{"stretch", "stretch_flipped"}},
+        {"square", []string{"square", "square_flipped"}},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(16, 12)
+        th.Mode = test.mode
+        thumbs := th.Thumbnail(gradientImage(40, 30))
+
+        var got []string
+        for k, img := range thumbs {
+            got = append(got, k)
+            if size := img.Bounds().Size(); size != image.Pt(16, 12) {
+                t.Errorf("%s %s: size %v, want 16x12", test.mode, k, size)
+            }
+        }
+        sort.Strings(got)
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: got %v, want %v", test.mode, got, test.want)
+        }
+    }
+}
+
+// ProcessFile writes Thumbnail's images, as PNGs named after the input,
+// all through Storage.
+func TestProcessFile(t *testing.T) {
+    storage := newMemStorage()
+    source := gradientImage(64, 48)
+    storage.WriteFile("in/photo.png", pngData(t, source))
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    if err := th.ProcessFile("in/photo.png", "out"); err != nil {
+        t.Fatal(err)
+    }
+
+    thumbs := th.Thumbnail(source)
+    for key, want := range thumbs {
+        data, err := storage.ReadFile("out/photo_" + key + ".png")
+        if err != nil {
+            t.Errorf("%s: %v", key, err)
+            continue
+        }
+        got, err := png.Decode(bytes.NewReader(data))
+        if err != nil {
+            t.Fatalf("%s: %v", key, err)
+        }
+        if d := maxDiff(t, want, got); d != 0 {
+            t.Errorf("%s: off by up to %d from Thumbnail", key, d)
+        }
+    }
+    if got := len(outputsUnder(storage, "out")); got != len(thumbs) {
+        t.Errorf("Got %d outputs, want %d", got, len(thumbs))
+    }
+}
+
+func TestProcessFileErrors(t *testing.T) {
+    storage := newMemStorage()
+    storage.WriteFile("in/small.png", pngData(t, gradientImage(8, 8)))
+    storage.WriteFile("in/corrupt.jpg", jpegData(t, gradientImage(64, 48), 90)[:200])
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    tests := map[string]error{
+        "in/small.png": ErrUndersized,
+        "in/corrupt.jpg": ErrCorrupt,
+    }
+    for input, want := range tests {
+        if err := th.ProcessFile(input, "out"); !errors.Is(err, want) {
+            t.Errorf("%s: got %v, want %v", input, err, want)
+        }
+    }
+    if err := th.ProcessFile("in/missing.jpg", "out"); err == nil {
+        t.Error("Missing input succeeded")
+    }
+    if got := outputsUnder(storage, "out"); len(got) != 0 {
+        t.Errorf("Failed inputs wrote %v", got)
+    }
+
+    // Upscaling is allowed if asked for.
+    th.AllowUpscale = true
+    if err := th.ProcessFile("in/small.png", "out"); err != nil {
+        t.Errorf("Upscaled: %v", err)
+    }
+}
+
+func TestProcessInMemory(t *testing.T) {
+    storage := newMemStorage()
+    storage.WriteFile("in/a.png", pngData(t, gradientImage(64, 48)))
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    th.InMemory = true
+    result, err := th.Process("in/a.png", "out")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(result.Outputs) != 6 || result.Size != image.Pt(64, 48) {
+        t.Fatalf("Got %d outputs of a %v source, want 6 of 64x48", len(result.Outputs), result.Size)
+    }
+    for _, o := range result.Outputs {
+        if o.Image == nil || o.Path != "" {
+            t.Errorf("%s: image %v, path %q", o.Key(), o.Image != nil, o.Path)
+        }
+    }
+    if got := outputsUnder(storage, "out"); len(got) != 0 {
+        t.Errorf("InMemory wrote %v", got)
+    }
+}
+
+func TestDuplicatesThumbnailedOnce(t *testing.T) {
+    storage := newMemStorage()
+    data := jpegData(t, gradientImage(64, 48), 90)
+    storage.WriteFile("in/a.jpg", data)
+    storage.WriteFile("in/b.jpg", data)
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    if err := th.ProcessFile("in/a.jpg", "out"); err != nil {
+        t.Fatal(err)
+    }
+    var dupe *DuplicateError
+    if err := th.ProcessFile("in/b.jpg", "out"); !errors.As(err, &dupe) || dupe.Original != "in/a.jpg" {
+        t.Fatalf("Got %v, want a duplicate of in/a.jpg", err)
+    }
+
+    want := []string{
+        "out/a_center.png", "out/a_center_flipped.png",
+        "out/a_left.png", "out/a_left_flipped.png",
+        "out/a_right.png", "out/a_right_flipped.png",
+    }
+    if got := outputsUnder(storage, "out"); !reflect.DeepEqual(got, want) {
+        t.Errorf("Got %v, want %v", got, want)
+    }
+
+    // Without Deduplicate, both get their own.
+    th = testThumbnailer(16, 16)
+    th.Storage = storage
+    th.Deduplicate = false
+    th.ProcessFile("in/a.jpg", "out2")
+    if err := th.ProcessFile("in/b.jpg", "out2"); err != nil {
+        t.Fatal(err)
+    }
+    if got := len(outputsUnder(storage, "out2")); got != 12 {
+        t.Errorf("Got %d outputs without dedup, want 12", got)
+    }
+}
+
+// cancelingStorage is a memStorage that cancels a context during its
+// cancelAt'th write, as a timeout running out mid-write would.
+type cancelingStorage struct {
+    *memStorage
+    cancel   context.CancelFunc
+    cancelAt int
+    written  int
+}
+
+func (s *cancelingStorage) WriteFile(path string, data []byte) error {
+    err := s.memStorage.WriteFile(path, data)
+    if s.written++; s.written == s.cancelAt {
+        s.cancel()
+    }
+    return err
+}
+
+// An input given up on partway leaves no outputs, even from a write that
+// outlasted its context, and isn't the original of its duplicates; nor is
+// one Discarded after it succeeded.
+func TestAbandoned(t *testing.T) {
+    data := jpegData(t, gradientImage(64, 48), 90)
+    // Its first write, one between, and its last; or never, and Discard.
+    for _, cancelAt := range []int{1, 3, 6, 0} {
+        ctx, cancel := context.WithCancel(context.Background())
+        inputs := newMemStorage()
+        inputs.WriteFile("in/a.jpg", data)
+        inputs.WriteFile("in/b.jpg", data)
+        storage := &cancelingStorage{memStorage: inputs, cancel: cancel, cancelAt: cancelAt}
+
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        result, err := th.ProcessAs(ctx, "in/a.jpg", "out", "a")
+        if cancelAt == 0 {
+            if err != nil {
+                t.Fatal(err)
+            }
+            th.Discard(result)
+        } else if !errors.Is(err, context.Canceled) {
+            t.Errorf("Canceled at write %d: got %v", cancelAt, err)
+        }
+        cancel()
+
+        if got := outputsUnder(storage.memStorage, "out"); len(got) != 0 {
+            t.Errorf("Canceled at write %d: left %v", cancelAt, got)
+        }
+        if err := th.ProcessFile("in/b.jpg", "out"); err != nil {
+            t.Errorf("Canceled at write %d: its duplicate got %v", cancelAt, err)
+        }
+    }
+}
+
+// Outputs are written, and reported, in variants's order: anchors sorted,
+// each as is, then flipped, then flipped vertically.
+func TestWriteOrder(t *testing.T) {
+    storage := newMemStorage()
+    storage.WriteFile("in/a.png", pngData(t, gradientImage(64, 48)))
+    storage.writes = nil
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    th.Anchors, _ = ParseAnchors("right,top-left,center")
+    th.FlipVertical = true
+    want := []string{
+        "center", "center_flipped", "center_vflipped",
+        "right", "right_flipped", "right_vflipped",
+        "top-left", "top-left_flipped", "top-left_vflipped",
+    }
+
+    // Anchors is a map, so a few tries would catch its order leaking out.
+    for run := 0; run < 3; run++ {
+        var keys []string
+        for _, v := range th.variants() {
+            keys = append(keys, v.Key())
+        }
+        if !reflect.DeepEqual(keys, want) {
+            t.Fatalf("Variants %v, want %v", keys, want)
+        }
+    }
+
+    result, err := th.Process("in/a.png", "out")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(result.Outputs) != len(want) || len(storage.writes) != len(want) {
+        t.Fatalf("%d outputs, %d writes, want %d", len(result.Outputs), len(storage.writes), len(want))
+    }
+    for i, o := range result.Outputs {
+        path := "out/a_" + want[i] + ".png"
+        if o.Path != path || storage.writes[i] != path {
+            t.Errorf("Output %d: reported %s, written %s, want %s", i, o.Path, storage.writes[i], path)
+        }
+    }
+}
+
+// Result.Format is what the data decoded as, whatever the name says, and
+// with MatchFormat it picks the outputs' format where there's an encoder.
+func TestResultFormat(t *testing.T) {
+    src := gradientImage(32, 32)
+    var gifData bytes.Buffer
+    if err := gif.Encode(&gifData, src, nil); err != nil {
+        t.Fatal(err)
+    }
+
+    storage := newMemStorage()
+    inputs := []struct {
+        path, want, output string
+    }{
+        {"in/png.jpg", "png", "out/png_center.png"},
+        {"in/jpeg.png", "jpeg", "out/jpeg_center.jpg"},
+        {"in/gif.gif", "gif", "out/gif_center.png"},
+    }
+    storage.WriteFile("in/png.jpg", pngData(t, src))
+    storage.WriteFile("in/jpeg.png", jpegData(t, src, 90))
+    storage.WriteFile("in/gif.gif", gifData.Bytes())
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    th.Deduplicate = false
+    th.MatchFormat = true
+    for _, input := range inputs {
+        result, err := th.Process(input.path, "out")
+        if err != nil {
+            t.Fatalf("%s: %v", input.path, err)
+        }
+        if result.Format != input.want {
+            t.Errorf("%s: format %q, want %q", input.path, result.Format, input.want)
+        }
+        if _, err := storage.ReadFile(input.output); err != nil {
+            t.Errorf("%s: %v", input.path, err)
+        }
+    }
+
+    // Decoded but then rejected still says what it was; never read says
+    // nothing.
+    th.Dim = Dim{64, 64}
+    if result, _ := th.Process("in/jpeg.png", "out"); result.Format != "jpeg" {
+        t.Errorf("Undersized: format %q, want jpeg", result.Format)
+    }
+    if result, _ := th.Process("in/missing.png", "out"); result.Format != "" {
+        t.Errorf("Missing: format %q, want none", result.Format)
+    }
+}
+
+// Every PNGCompression level is lossless, and the sizes line up: none the
+// biggest, then speed, default and best.
+func TestPNGCompression(t *testing.T) {
+    src := jpegTexture(t)
+    sizes := make(map[string]int)
+    for level := range PNG_COMPRESSIONS {
+        th := New()
+        th.PNGCompression = level
+        var buf bytes.Buffer
+        if err := th.Encode(&buf, src); err != nil {
+            t.Fatal(err)
+        }
+        if d := maxDiff(t, src, decodeData(t, buf.Bytes())); d != 0 {
+            t.Errorf("%s: off by up to %d", level, d)
+        }
+        sizes[level] = buf.Len()
+    }
+
+    if !(sizes["none"] > sizes["speed"] && sizes["speed"] > sizes["default"] && sizes["default"] > sizes["best"]) {
+        t.Errorf("Sizes %v", sizes)
+    }
+}
+
+// The other side of the trade: ns/op at each level, with the bytes it
+// comes to.
+func BenchmarkPNGCompression(b *testing.B) {
+    src := jpegTexture(b)
+    for _, level := range []string{"none", "speed", "default", "best"} {
+        b.Run(level, func(b *testing.B) {
+            th := New()
+            th.PNGCompression = level
+            var buf bytes.Buffer
+            for i := 0; i < b.N; i++ {
+                buf.Reset()
+                if err := th.Encode(&buf, src); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(buf.Len()), "bytes")
+        })
+    }
+}
+
+// jpegTexture is a gradient through a lossy JPEG round trip, whose noise
+// gives zlib's levels something to differ on.
+func jpegTexture(t testing.TB) image.Image {
+    return decodeData(t, jpegData(t, gradientImage(128, 128), 50))
+}
+
+// bombPNG is a small PNG whose header claims w x h, which decoding would
+// have to allocate before finding the data short.
+func bombPNG(t *testing.T, w, h uint32) []byte {
+    t.Helper()
+    data := pngData(t, gradientImage(8, 8))
+    ihdr := append([]byte{}, data[12:29]...)
+    binary.BigEndian.PutUint32(ihdr[4:], w)
+    binary.BigEndian.PutUint32(ihdr[8:], h)
+    chunk := pngChunk("IHDR", ihdr[4:])
+    return append(append(append([]byte{}, data[:8]...), chunk...), data[33:]...)
+}
+
+// bombJPEG is bombPNG for a JPEG, its SOF0 patched.
+func bombJPEG(t *testing.T, w, h uint16) []byte {
+    t.Helper()
+    data := jpegData(t, gradientImage(8, 8), 90)
+    sof := bytes.Index(data, []byte{0xFF, 0xC0})
+    binary.BigEndian.PutUint16(data[sof + 5:], h)
+    binary.BigEndian.PutUint16(data[sof + 7:], w)
+    return data
+}
+
+// Sources whose headers declare more than MaxPixels fail with ErrTooLarge,
+// and aren't decoded: these would need gigabytes of pixels.
+func TestDecompressionBomb(t *testing.T) {
+    storage := newMemStorage()
+    storage.WriteFile("in/bomb.png", bombPNG(t, 60000, 60000))
+    storage.WriteFile("in/bomb.jpg", bombJPEG(t, 60000, 60000))
+    storage.WriteFile("in/fine.png", pngData(t, gradientImage(64, 64)))
+
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    for _, input := range []string{"in/bomb.png", "in/bomb.jpg"} {
+        if err := th.ProcessFile(input, "out"); !errors.Is(err, ErrTooLarge) {
+            t.Errorf("%s: got %v, want ErrTooLarge", input, err)
+        }
+    }
+
+    // The limit is MaxPixels, including a low one, and not the file size.
+    th.MaxPixels = 64 * 64
+    if err := th.ProcessFile("in/fine.png", "out"); err != nil {
+        t.Errorf("At the limit: %v", err)
+    }
+    th.MaxPixels = 64 * 64 - 1
+    th.Deduplicate = false
+    if err := th.ProcessFile("in/fine.png", "out"); !errors.Is(err, ErrTooLarge) {
+        t.Errorf("Over the limit: got %v, want ErrTooLarge", err)
+    }
+}
+
+// OutputDir gets the input's checksum off the read ProcessAs decodes, so
+// even with SkipExisting each input is read the once.
+func TestOutputDir(t *testing.T) {
+    storage := &countingStorage{memStorage: newMemStorage()}
+    storage.WriteFile("in/a.png", pngData(t, gradientImage(64, 48)))
+    th := testThumbnailer(16, 16)
+    th.Storage = storage.memStorage
+    want, err := th.Checksum("in/a.png")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    th.Storage = storage
+    th.Single = true
+    th.Deduplicate = false
+    th.OutputDir = func(inputPath, outputDir, checksum string) string {
+        if inputPath != "in/a.png" || outputDir != "out" || checksum != want {
+            t.Errorf("Got %s, %s, %s", inputPath, outputDir, checksum)
+        }
+        return JoinPath(outputDir, "shard")
+    }
+    for _, skip := range []bool{false, true} {
+        storage.full = 0
+        th.SkipExisting = skip
+        err := th.ProcessFile("in/a.png", "out")
+        if skip && !errors.Is(err, ErrExists) || !skip && err != nil {
+            t.Errorf("SkipExisting %v: got %v", skip, err)
+        }
+        if storage.full != 1 {
+            t.Errorf("SkipExisting %v: %d full reads", skip, storage.full)
+        }
+    }
+    if got := outputsUnder(storage.memStorage, "out"); len(got) != 1 || got[0] != "out/shard/a.png" {
+        t.Errorf("Got %v", got)
+    }
+}
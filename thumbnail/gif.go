@@ -0,0 +1,94 @@
+package thumbnail
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/draw"
+    "image/gif"
+    "strconv"
+)
+
+//=============================================================================
+
+// image.Decode only returns an animated GIF's first frame, which is often
+// a blank lead-in. GifFrame picks a more representative one.
+
+func isGIF(data []byte) bool {
+    return bytes.HasPrefix(data, []byte("GIF8"))
+}
+
+func validGifFrame(spec string) error {
+    switch spec {
+    case "first", "middle", "last":
+        return nil
+    }
+    if i, err := strconv.Atoi(spec); err != nil || i < 0 {
+        return fmt.Errorf("GIF frame %q expected first, middle, last, or an index", spec)
+    }
+    return nil
+}
+
+// gifFrameIndex resolves spec for a GIF of n frames. Indices past the end
+// clamp to the last frame.
+func gifFrameIndex(spec string, n int) int {
+    switch spec {
+    case "first":
+        return 0
+    case "middle":
+        return n / 2
+    case "last":
+        return n - 1
+    }
+
+    i, _ := strconv.Atoi(spec)
+    if i >= n {
+        return n - 1
+    }
+    return i
+}
+
+// decodeGIFFrame renders the selected frame the way a browser would show
+// it, i.e. drawn over whatever the earlier frames left behind.
+func decodeGIFFrame(data []byte, spec string) (image.Image, error) {
+    g, err := gif.DecodeAll(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    if len(g.Image) == 0 {
+        return nil, fmt.Errorf("GIF has no frames")
+    }
+
+    target := gifFrameIndex(spec, len(g.Image))
+    if target == 0 {
+        return g.Image[0], nil
+    }
+
+    canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+    for i, frame := range g.Image[:target + 1] {
+        disposal := byte(gif.DisposalNone)
+        if i < len(g.Disposal) {
+            disposal = g.Disposal[i]
+        }
+
+        var previous *image.NRGBA
+        if disposal == gif.DisposalPrevious {
+            previous = image.NewNRGBA(canvas.Bounds())
+            draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+        }
+
+        draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+        if i == target {
+            break
+        }
+
+        switch disposal {
+        case gif.DisposalBackground:
+            draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+        case gif.DisposalPrevious:
+            canvas = previous
+        }
+    }
+
+    return canvas, nil
+}
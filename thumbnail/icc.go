@@ -0,0 +1,301 @@
+package thumbnail
+
+import (
+    "bytes"
+    "compress/zlib"
+    "encoding/binary"
+    "image"
+    "image/draw"
+    "io"
+    "math"
+    "sort"
+)
+
+//=============================================================================
+
+// Decoders hand back a source's stored values, and everything after takes
+// them for sRGB. For sources tagged with a wider gamut (Adobe RGB, Display
+// P3, ProPhoto), that's wrong: greens go dull, skin goes gray. With
+// ICCConvert, the profile in a JPEG's APP2 segments or a PNG's iCCP chunk
+// is read, and unless it's sRGB already the pixels are converted to sRGB
+// as they're decoded. Untagged sources are sRGB, as browsers take them.
+//
+// There's no color management library to lean on, so this is just the
+// matrix/TRC profile model: three tone curves and the colorants' XYZ. It's
+// what RGB working spaces and camera profiles are. Ones built on lookup
+// tables, and CMYK or gray ones, are left alone. Colors outside sRGB are
+// clipped, the same as an ICC relative colorimetric intent would do.
+
+// sRGB's colorants, adapted to D50 the way ICC profiles carry them, as the
+// columns of its linear RGB to XYZ matrix.
+var srgbToXYZ = [3][3]float64{
+    {0.4360747, 0.3850649, 0.1430804},
+    {0.2225045, 0.7168786, 0.0606169},
+    {0.0139322, 0.0971045, 0.7141733},
+}
+
+var xyzToSRGB = invert3(srgbToXYZ)
+
+// Colorant differences from sRGB's still taken for sRGB, which covers
+// the rounding of s15Fixed16 and profiles adapted a bit differently.
+const iccTolerance = 0.002
+
+// iccTransform maps a profile's values to linear light, then to linear
+// sRGB.
+type iccTransform struct {
+    curves [3][256]float64
+    matrix [3][3]float64
+}
+
+// toSRGB converts img, decoded from data, if data has a profile other
+// than sRGB that it can follow; otherwise img comes back as is.
+func toSRGB(img image.Image, data []byte) image.Image {
+    transform := parseICC(iccProfile(data))
+    if transform == nil {
+        return img
+    }
+
+    b := img.Bounds()
+    dst := image.NewNRGBA(b)
+    draw.Draw(dst, b, img, b.Min, draw.Src)
+    for i := 0; i + 4 <= len(dst.Pix); i += 4 {
+        p := dst.Pix[i:i + 3:i + 3]
+        linear := [3]float64{transform.curves[0][p[0]], transform.curves[1][p[1]], transform.curves[2][p[2]]}
+        for c := range p {
+            m := transform.matrix[c]
+            v := clamp01(m[0] * linear[0] + m[1] * linear[1] + m[2] * linear[2])
+            p[c] = linearToSRGB8[int(v * 0xFFFF + 0.5)]
+        }
+    }
+    return dst
+}
+
+// iccProfile returns the ICC profile embedded in a JPEG or PNG, or nil.
+func iccProfile(data []byte) []byte {
+    if len(data) >= 8 && bytes.Equal(data[:8], []byte("\x89PNG\r\n\x1a\n")) {
+        return pngICC(data)
+    }
+    if len(data) >= 4 && data[0] == 0xFF && data[1] == 0xD8 {
+        return jpegICC(data)
+    }
+    return nil
+}
+
+// jpegICC joins the ICC_PROFILE APP2 segments of a JPEG, which a profile
+// over 64KB is split across, numbered from 1.
+func jpegICC(data []byte) []byte {
+    type chunk struct {
+        seq  int
+        data []byte
+    }
+    var chunks []chunk
+    marker := []byte("ICC_PROFILE\x00")
+
+    pos := 2
+    for pos + 4 <= len(data) && data[pos] == 0xFF {
+        kind := data[pos + 1]
+        size := int(binary.BigEndian.Uint16(data[pos + 2:]))
+        if kind == 0xDA || size < 2 || pos + 2 + size > len(data) {
+            break
+        }
+
+        segment := data[pos + 4:pos + 2 + size]
+        if kind == 0xE2 && bytes.HasPrefix(segment, marker) && len(segment) >= len(marker) + 2 {
+            chunks = append(chunks, chunk{int(segment[len(marker)]), segment[len(marker) + 2:]})
+        }
+        pos += 2 + size
+    }
+
+    sort.SliceStable(chunks, func (i, j int) bool { return chunks[i].seq < chunks[j].seq })
+    var profile []byte
+    for _, c := range chunks {
+        profile = append(profile, c.data...)
+    }
+    return profile
+}
+
+// pngICC inflates a PNG's iCCP chunk: a name, a NUL, a compression method
+// (always 0, zlib) and the compressed profile.
+func pngICC(data []byte) []byte {
+    for i := 8; i + 12 <= len(data); {
+        n := int(binary.BigEndian.Uint32(data[i:]))
+        if n < 0 || i + 12 + n > len(data) {
+            break
+        }
+        kind, chunk := string(data[i + 4:i + 8]), data[i + 8:i + 8 + n]
+        // It has to come before the image data.
+        if kind == "IDAT" || kind == "IEND" {
+            break
+        }
+        if kind == "iCCP" {
+            _, compressed, found := bytes.Cut(chunk, []byte{0})
+            if !found || len(compressed) < 1 {
+                return nil
+            }
+            r, err := zlib.NewReader(bytes.NewReader(compressed[1:]))
+            if err != nil {
+                return nil
+            }
+            profile, err := io.ReadAll(r)
+            if err != nil {
+                return nil
+            }
+            return profile
+        }
+        i += 12 + n
+    }
+    return nil
+}
+
+// parseICC reads a matrix/TRC RGB profile. Anything else, sRGB itself, or
+// a profile that doesn't parse comes back nil.
+func parseICC(profile []byte) *iccTransform {
+    if len(profile) < 132 || string(profile[36:40]) != "acsp" ||
+        string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+        return nil
+    }
+
+    tags := make(map[string][]byte)
+    count := int(binary.BigEndian.Uint32(profile[128:]))
+    for i := 0; i < count && 132 + 12 * (i + 1) <= len(profile); i++ {
+        entry := profile[132 + 12 * i:]
+        offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+        if uint64(offset) + uint64(size) <= uint64(len(profile)) {
+            tags[string(entry[:4])] = profile[offset:offset + size]
+        }
+    }
+
+    var transform iccTransform
+    var colorants [3][3]float64
+    srgb := true
+    for c, name := range []string{"r", "g", "b"} {
+        xyz, ok := iccXYZ(tags[name + "XYZ"])
+        if !ok {
+            return nil
+        }
+        for row := range xyz {
+            colorants[row][c] = xyz[row]
+            if math.Abs(xyz[row] - srgbToXYZ[row][c]) > iccTolerance {
+                srgb = false
+            }
+        }
+
+        curve, ok := iccCurve(tags[name + "TRC"])
+        if !ok {
+            return nil
+        }
+        for v := range transform.curves[c] {
+            transform.curves[c][v] = curve(float64(v) / 255)
+            if math.Abs(transform.curves[c][v] - srgbToLinear(uint8(v))) > iccTolerance {
+                srgb = false
+            }
+        }
+    }
+    if srgb {
+        return nil
+    }
+
+    transform.matrix = multiply3(xyzToSRGB, colorants)
+    return &transform
+}
+
+func s15Fixed16(b []byte) float64 {
+    return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccXYZ reads an XYZType tag.
+func iccXYZ(tag []byte) (xyz [3]float64, ok bool) {
+    if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+        return xyz, false
+    }
+    for i := range xyz {
+        xyz[i] = s15Fixed16(tag[8 + 4 * i:])
+    }
+    return xyz, true
+}
+
+// iccCurve reads a curveType or parametricCurveType tag as a function from
+// stored values to linear light, both 0-1.
+func iccCurve(tag []byte) (func (float64) float64, bool) {
+    if len(tag) < 12 {
+        return nil, false
+    }
+
+    switch string(tag[:4]) {
+    case "curv":
+        n := int(binary.BigEndian.Uint32(tag[8:]))
+        if len(tag) < 12 + 2 * n {
+            return nil, false
+        }
+        switch n {
+        case 0:
+            return func (x float64) float64 { return x }, true
+        case 1:
+            gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+            return func (x float64) float64 { return math.Pow(x, gamma) }, true
+        }
+        table := make([]float64, n)
+        for i := range table {
+            table[i] = float64(binary.BigEndian.Uint16(tag[12 + 2 * i:])) / 0xFFFF
+        }
+        return func (x float64) float64 {
+            pos := x * float64(n - 1)
+            i := min(int(pos), n - 2)
+            return table[i] + (table[i + 1] - table[i]) * (pos - float64(i))
+        }, true
+
+    case "para":
+        // g, a, b, c, d, e, f; how many of them depends on the function.
+        kind := binary.BigEndian.Uint16(tag[8:])
+        counts := []int{1, 3, 4, 5, 7}
+        if int(kind) >= len(counts) || len(tag) < 12 + 4 * counts[kind] {
+            return nil, false
+        }
+        p := [7]float64{1, 1}
+        for i := 0; i < counts[kind]; i++ {
+            p[i] = s15Fixed16(tag[12 + 4 * i:])
+        }
+        g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+        switch kind {
+        case 1, 2:
+            if a == 0 {
+                return nil, false
+            }
+            // Both are c (for 1, 0) on either side of the break.
+            d, e, f = -b / a, c, c
+            c = 0
+        }
+        return func (x float64) float64 {
+            if x < d {
+                return c * x + f
+            }
+            return math.Pow(max(a * x + b, 0), g) + e
+        }, true
+    }
+    return nil, false
+}
+
+func multiply3(m, n [3][3]float64) (product [3][3]float64) {
+    for i := range product {
+        for j := range product[i] {
+            for k := range m {
+                product[i][j] += m[i][k] * n[k][j]
+            }
+        }
+    }
+    return product
+}
+
+func invert3(m [3][3]float64) (inverse [3][3]float64) {
+    det := m[0][0] * (m[1][1] * m[2][2] - m[1][2] * m[2][1]) -
+        m[0][1] * (m[1][0] * m[2][2] - m[1][2] * m[2][0]) +
+        m[0][2] * (m[1][0] * m[2][1] - m[1][1] * m[2][0])
+    for i := range inverse {
+        for j := range inverse[i] {
+            // The cofactor of m[j][i], over the determinant.
+            a, b := m[(j + 1) % 3], m[(j + 2) % 3]
+            inverse[i][j] = (a[(i + 1) % 3] * b[(i + 2) % 3] - a[(i + 2) % 3] * b[(i + 1) % 3]) / det
+        }
+    }
+    return inverse
+}
@@ -0,0 +1,167 @@
+package thumbnail
+
+import (
+    "bytes"
+    "compress/zlib"
+    "encoding/binary"
+    "image"
+    "image/color"
+    "image/draw"
+    "testing"
+)
+
+//=============================================================================
+
+// iccTag is an ICC tag's signature and data.
+type iccTag struct {
+    sig  string
+    data []byte
+}
+
+func xyzTag(x, y, z float64) []byte {
+    tag := []byte("XYZ \x00\x00\x00\x00")
+    for _, v := range []float64{x, y, z} {
+        tag = binary.BigEndian.AppendUint32(tag, uint32(int32(v * 65536 + 0.5)))
+    }
+    return tag
+}
+
+// rgbProfile is a matrix/TRC display profile with the given colorants
+// (columns of its RGB to XYZ matrix) and one curve for all three channels.
+func rgbProfile(colorants [3][3]float64, curve []byte) []byte {
+    tags := []iccTag{{"rTRC", curve}, {"gTRC", curve}, {"bTRC", curve}}
+    for c, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+        tags = append(tags, iccTag{name, xyzTag(colorants[0][c], colorants[1][c], colorants[2][c])})
+    }
+
+    header := make([]byte, 128)
+    copy(header[12:], "mntr")
+    copy(header[16:], "RGB ")
+    copy(header[20:], "XYZ ")
+    copy(header[36:], "acsp")
+    table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+    offset := 128 + 4 + 12 * len(tags)
+    var data []byte
+    for _, tag := range tags {
+        table = append(table, tag.sig...)
+        table = binary.BigEndian.AppendUint32(table, uint32(offset + len(data)))
+        table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+        data = append(data, tag.data...)
+    }
+
+    profile := append(append(header, table...), data...)
+    binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+    return profile
+}
+
+// Adobe RGB (1998), D50-adapted as its profile carries it, gamma 563/256.
+var adobeRGB = rgbProfile([3][3]float64{
+    {0.6097559, 0.2052401, 0.1492240},
+    {0.3111145, 0.6256560, 0.0632294},
+    {0.0194702, 0.0608902, 0.7448387},
+}, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33\x00\x00"))
+
+// sRGB's own, with its piecewise curve as a parametric one.
+var srgbProfile = func() []byte {
+    curve := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+    for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+        curve = binary.BigEndian.AppendUint32(curve, uint32(int32(v * 65536 + 0.5)))
+    }
+    return rgbProfile(srgbToXYZ, curve)
+}()
+
+// jpegWithProfile embeds profile in a JPEG as a single APP2 segment.
+func jpegWithProfile(jpeg, profile []byte) []byte {
+    payload := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+    segment := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE2}, uint16(len(payload) + 2))
+    return withSegment(jpeg, append(segment, payload...))
+}
+
+// pngWithProfile embeds profile in a PNG as an iCCP chunk.
+func pngWithProfile(t *testing.T, png, profile []byte) []byte {
+    t.Helper()
+    var compressed bytes.Buffer
+    w := zlib.NewWriter(&compressed)
+    w.Write(profile)
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return withChunk(png, pngChunk("iCCP", append([]byte("Adobe RGB\x00\x00"), compressed.Bytes()...)))
+}
+
+// Colors as stored in an Adobe RGB file, and the sRGB ones they are.
+var adobeColors = []struct {
+    stored, srgb color.NRGBA
+}{
+    {color.NRGBA{100, 150, 50, 255}, color.NRGBA{66, 151, 34, 255}},
+    {color.NRGBA{200, 80, 60, 255}, color.NRGBA{230, 79, 56, 255}},
+    {color.NRGBA{128, 128, 128, 255}, color.NRGBA{129, 129, 129, 255}},
+}
+
+// swatches is a 16x16 square of each of adobeColors's stored colors, side
+// by side.
+func swatches() *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, 16 * len(adobeColors), 16))
+    for i, c := range adobeColors {
+        draw.Draw(img, image.Rect(16 * i, 0, 16 * (i + 1), 16), image.NewUniform(c.stored), image.Point{}, draw.Src)
+    }
+    return img
+}
+
+func TestICCConvert(t *testing.T) {
+    src := swatches()
+    sources := map[string][]byte{
+        "png": pngWithProfile(t, pngData(t, src), adobeRGB),
+        "jpeg": jpegWithProfile(jpegData(t, src, 100), adobeRGB),
+    }
+
+    for name, data := range sources {
+        if !bytes.Equal(iccProfile(data), adobeRGB) {
+            t.Fatalf("%s: profile didn't read back", name)
+        }
+
+        th := New()
+        th.ICCConvert = true
+        img, err := th.Decode(data)
+        if err != nil {
+            t.Fatal(err)
+        }
+        plain, _ := New().Decode(data)
+        for i, c := range adobeColors {
+            // Swatch middles, clear of JPEG's bleeding.
+            x := 16 * i + 8
+            got := color.NRGBAModel.Convert(img.At(x, 8)).(color.NRGBA)
+            if diff(got.R, c.srgb.R) > 3 || diff(got.G, c.srgb.G) > 3 || diff(got.B, c.srgb.B) > 3 {
+                t.Errorf("%s: %v converted to %v, want %v", name, c.stored, got, c.srgb)
+            }
+            // And as stored without ICCConvert.
+            got = color.NRGBAModel.Convert(plain.At(x, 8)).(color.NRGBA)
+            if diff(got.R, c.stored.R) > 3 || diff(got.G, c.stored.G) > 3 || diff(got.B, c.stored.B) > 3 {
+                t.Errorf("%s: %v unconverted is %v", name, c.stored, got)
+            }
+        }
+    }
+}
+
+// sRGB-tagged and untagged sources are left exactly as they are.
+func TestICCLeavesSRGB(t *testing.T) {
+    src := swatches()
+    th := New()
+    th.ICCConvert = true
+    for name, data := range map[string][]byte{
+        "srgb": pngWithProfile(t, pngData(t, src), srgbProfile),
+        "untagged": pngData(t, src),
+        "garbage": pngWithProfile(t, pngData(t, src), []byte("not a profile")),
+    } {
+        img, err := th.Decode(data)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if d := maxDiff(t, src, img); d != 0 {
+            t.Errorf("%s: off by up to %d", name, d)
+        }
+    }
+    if parseICC(srgbProfile) != nil {
+        t.Error("sRGB's profile taken for another")
+    }
+}
@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+    "github.com/disintegration/gift"
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+)
+
+//=============================================================================
+
+// Resampling averages pixel values, but sRGB values aren't light: the mean
+// of black and white in sRGB is a gray much darker than the two mixed.
+// Downscaled fine detail (hair, foliage, text) comes out darker than it
+// should. With LinearResize, the resize itself runs on linear light at 16
+// bits, and only it; the adjustments after it are defined in sRGB. Deep
+// sources (see depth.go) go through 16-bit tables instead, both ways.
+
+type linearFilter struct {
+    gift.Filter
+    deep bool
+}
+
+var srgbToLinear16 = func() (lut [256]uint16) {
+    for i := range lut {
+        lut[i] = uint16(math.Round(srgbToLinear(uint8(i)) * 0xFFFF))
+    }
+    return lut
+}()
+
+var linearToSRGB8 = func() (lut [65536]uint8) {
+    for i := range lut {
+        lut[i] = uint8(linearToSRGB(float64(i) / 0xFFFF))
+    }
+    return lut
+}()
+
+// resampling wraps a resizing filter of src for LinearResize.
+func (t *Thumbnailer) resampling(src image.Image, filter gift.Filter) gift.Filter {
+    if !t.LinearResize {
+        return filter
+    }
+    return &linearFilter{filter, t.deep(src)}
+}
+
+func (f *linearFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+    b := src.Bounds()
+    linear := image.NewNRGBA64(b)
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            if f.deep {
+                lut := srgb16ToLinear16()
+                c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+                linear.SetNRGBA64(x, y, color.NRGBA64{lut[c.R], lut[c.G], lut[c.B], c.A})
+                continue
+            }
+            c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+            linear.SetNRGBA64(x, y, color.NRGBA64{
+                srgbToLinear16[c.R], srgbToLinear16[c.G], srgbToLinear16[c.B], uint16(c.A) * 0x101,
+            })
+        }
+    }
+
+    resized := image.NewNRGBA64(f.Filter.Bounds(b))
+    f.Filter.Draw(resized, linear, options)
+
+    rb, db := resized.Bounds(), dst.Bounds()
+    for y := 0; y < rb.Dy(); y++ {
+        for x := 0; x < rb.Dx(); x++ {
+            c := resized.NRGBA64At(rb.Min.X + x, rb.Min.Y + y)
+            if f.deep {
+                lut := linear16ToSRGB16()
+                dst.Set(db.Min.X + x, db.Min.Y + y, color.NRGBA64{lut[c.R], lut[c.G], lut[c.B], c.A})
+                continue
+            }
+            dst.Set(db.Min.X + x, db.Min.Y + y, color.NRGBA{
+                linearToSRGB8[c.R], linearToSRGB8[c.G], linearToSRGB8[c.B], uint8(c.A >> 8),
+            })
+        }
+    }
+}
@@ -0,0 +1,50 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+// checkerboard is w x h of alternating black and white pixels.
+func checkerboard(w, h int) *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            if (x + y) % 2 == 0 {
+                img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+            } else {
+                img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+            }
+        }
+    }
+    return img
+}
+
+// Half black, half white is half the light: sRGB 188 when mixed in
+// linear light, but the much darker 128 when sRGB values are averaged.
+func TestLinearResize(t *testing.T) {
+    board := checkerboard(64, 64)
+    for _, linear := range []bool{false, true} {
+        th := New()
+        th.LinearResize = linear
+        lo, hi := rowRange(stretched(th, board, 8, 8))
+        want := 128
+        if linear {
+            want = 188
+        }
+        if diff(uint8(lo), uint8(want)) > 2 || diff(uint8(hi), uint8(want)) > 2 {
+            t.Errorf("Linear %v: grays %d-%d, want %d", linear, lo, hi, want)
+        }
+    }
+
+    // Anything flat comes back as it was.
+    flat := solidImage(64, 64, color.NRGBA{100, 150, 200, 255})
+    th := New()
+    th.LinearResize = true
+    if d := maxDiff(t, solidImage(8, 8, color.NRGBA{100, 150, 200, 255}), stretched(th, flat, 8, 8)); d > 1 {
+        t.Errorf("Flat color off by up to %d", d)
+    }
+}
@@ -0,0 +1,203 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+)
+
+//=============================================================================
+
+// Thumbnails are encoded from pixels, so by default they carry no
+// metadata at all. With PreserveMetadata, JPEG thumbnails get a minimal
+// EXIF block with just these source tags:
+//
+//   - Orientation: 1 if AutoOrient already turned the pixels upright,
+//     otherwise the source's, so viewers still turn it
+//   - DateTime and DateTimeOriginal
+//   - Copyright
+//
+// Everything else (GPS, camera serials, maker notes, the source's own
+// thumbnail) stays behind. PNG thumbnails get nothing.
+
+const (
+    exifDateTimeTag         = 0x0132
+    exifCopyrightTag        = 0x8298
+    exifIFDPointerTag       = 0x8769
+    exifDateTimeOriginalTag = 0x9003
+)
+
+// TIFF field types.
+const (
+    tiffASCII = 2
+    tiffShort = 3
+    tiffLong  = 4
+)
+
+type metadata struct {
+    orientation      int
+    dateTime         string
+    dateTimeOriginal string
+    copyright        string
+}
+
+// ifdStrings reads the ASCII tags wanted out of the IFD at offset, as
+// well as where it points to the EXIF IFD (zero if nowhere). Like
+// tiffOrientation, anything malformed just reads as missing.
+func ifdStrings(tiff []byte, order binary.ByteOrder, offset int, wanted map[uint16]*string) (exifIFD int) {
+    if offset < 8 || offset + 2 > len(tiff) {
+        return 0
+    }
+
+    entries := int(order.Uint16(tiff[offset:]))
+    for i := 0; i < entries; i++ {
+        entry := offset + 2 + i * 12
+        if entry + 12 > len(tiff) {
+            return exifIFD
+        }
+        tag, kind := order.Uint16(tiff[entry:]), order.Uint16(tiff[entry + 2:])
+        count := int(order.Uint32(tiff[entry + 4:]))
+
+        if tag == exifIFDPointerTag && kind == tiffLong {
+            exifIFD = int(order.Uint32(tiff[entry + 8:]))
+            continue
+        }
+
+        dst, found := wanted[tag]
+        if !found || kind != tiffASCII || count < 1 {
+            continue
+        }
+        start := entry + 8
+        if count > 4 {
+            start = int(order.Uint32(tiff[entry + 8:]))
+        }
+        if start < 0 || start + count > len(tiff) {
+            continue
+        }
+        *dst = string(bytes.TrimRight(tiff[start:start + count], "\x00"))
+    }
+    return exifIFD
+}
+
+// readMetadata pulls the preserved tags out of a source's bytes.
+func readMetadata(data []byte) metadata {
+    m := metadata{orientation: 1}
+    tiff := exifTIFF(data)
+    if len(tiff) < 8 {
+        return m
+    }
+
+    var order binary.ByteOrder = binary.BigEndian
+    if string(tiff[:2]) == "II" {
+        order = binary.LittleEndian
+    } else if string(tiff[:2]) != "MM" {
+        return m
+    }
+
+    m.orientation = tiffOrientation(tiff)
+    exifIFD := ifdStrings(tiff, order, int(order.Uint32(tiff[4:])), map[uint16]*string{
+        exifDateTimeTag: &m.dateTime,
+        exifCopyrightTag: &m.copyright,
+    })
+    ifdStrings(tiff, order, exifIFD, map[uint16]*string{
+        exifDateTimeOriginalTag: &m.dateTimeOriginal,
+    })
+    return m
+}
+
+type ifdEntry struct {
+    tag   uint16
+    kind  uint16
+    value []byte // ASCII, NUL-terminated; or a SHORT or LONG, big-endian.
+}
+
+// writeIFD appends an IFD of entries (sorted by tag) at the end of tiff,
+// with out-of-line values right after it, and returns the extended tiff.
+func writeIFD(tiff []byte, entries []ifdEntry) []byte {
+    order := binary.BigEndian
+    dataStart := len(tiff) + 2 + len(entries) * 12 + 4
+
+    var values []byte
+    tiff = order.AppendUint16(tiff, uint16(len(entries)))
+    for _, e := range entries {
+        count := len(e.value)
+        if e.kind != tiffASCII {
+            count = 1
+        }
+        tiff = order.AppendUint16(tiff, e.tag)
+        tiff = order.AppendUint16(tiff, e.kind)
+        tiff = order.AppendUint32(tiff, uint32(count))
+
+        switch {
+        case e.kind == tiffShort:
+            tiff = append(append(tiff, e.value...), 0, 0)
+        case len(e.value) <= 4:
+            tiff = append(tiff, e.value...)
+            tiff = append(tiff, make([]byte, 4 - len(e.value))...)
+        default:
+            tiff = order.AppendUint32(tiff, uint32(dataStart + len(values)))
+            values = append(values, e.value...)
+            if len(values) % 2 == 1 {
+                values = append(values, 0) // Word alignment
+            }
+        }
+    }
+    tiff = order.AppendUint32(tiff, 0) // No next IFD
+    return append(tiff, values...)
+}
+
+// exifSegment is m as a JPEG APP1 segment, or nil if there's nothing to
+// keep.
+func (m metadata) exifSegment(autoOriented bool) []byte {
+    orientation := m.orientation
+    if autoOriented {
+        orientation = 1
+    }
+
+    ascii := func (s string) []byte { return append([]byte(s), 0) }
+    var ifd0, exif []ifdEntry
+    ifd0 = append(ifd0, ifdEntry{exifOrientationTag, tiffShort, binary.BigEndian.AppendUint16(nil, uint16(orientation))})
+    if m.dateTime != "" {
+        ifd0 = append(ifd0, ifdEntry{exifDateTimeTag, tiffASCII, ascii(m.dateTime)})
+    }
+    if m.copyright != "" {
+        ifd0 = append(ifd0, ifdEntry{exifCopyrightTag, tiffASCII, ascii(m.copyright)})
+    }
+    if m.dateTimeOriginal != "" {
+        exif = append(exif, ifdEntry{exifDateTimeOriginalTag, tiffASCII, ascii(m.dateTimeOriginal)})
+    }
+
+    if orientation == 1 && len(ifd0) == 1 && len(exif) == 0 {
+        return nil
+    }
+
+    // The EXIF pointer's value comes after IFD0, so it's patched in once
+    // IFD0's size is known.
+    tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+    pointer := -1
+    if len(exif) > 0 {
+        pointer = len(tiff) + 2 + len(ifd0) * 12 + 8 // Value of the last entry
+        ifd0 = append(ifd0, ifdEntry{exifIFDPointerTag, tiffLong, make([]byte, 4)})
+    }
+    tiff = writeIFD(tiff, ifd0)
+    if pointer >= 0 {
+        binary.BigEndian.PutUint32(tiff[pointer:], uint32(len(tiff)))
+        tiff = writeIFD(tiff, exif)
+    }
+
+    segment := []byte{0xFF, 0xE1, 0, 0}
+    segment = append(segment, "Exif\x00\x00"...)
+    segment = append(segment, tiff...)
+    binary.BigEndian.PutUint16(segment[2:], uint16(len(segment) - 2))
+    return segment
+}
+
+// withSegment inserts segment into an encoded JPEG, right after its SOI.
+func withSegment(jpeg, segment []byte) []byte {
+    if len(segment) == 0 || len(jpeg) < 2 {
+        return jpeg
+    }
+    out := make([]byte, 0, len(jpeg) + len(segment))
+    out = append(out, jpeg[:2]...)
+    out = append(out, segment...)
+    return append(out, jpeg[2:]...)
+}
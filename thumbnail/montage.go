@@ -0,0 +1,70 @@
+package thumbnail
+
+import (
+    "image"
+    "image/draw"
+)
+
+//=============================================================================
+
+// Montage tiles images into a grid cols wide, left to right then top to
+// bottom. Every cell is the size of the largest image, and whatever the
+// images don't cover (including a partial final row) is Background.
+func (t *Thumbnailer) Montage(images []image.Image, cols int) image.Image {
+    dst, _ := t.montage(images, cols)
+    return dst
+}
+
+// montage is Montage, plus where each image went.
+func (t *Thumbnailer) montage(images []image.Image, cols int) (image.Image, []image.Rectangle) {
+    if cols < 1 {
+        cols = 1
+    }
+    if cols > len(images) && len(images) > 0 {
+        cols = len(images)
+    }
+
+    var cell image.Point
+    for _, img := range images {
+        size := img.Bounds().Size()
+        if size.X > cell.X {
+            cell.X = size.X
+        }
+        if size.Y > cell.Y {
+            cell.Y = size.Y
+        }
+    }
+
+    rows := (len(images) + cols - 1) / cols
+    bounds := image.Rect(0, 0, cols * cell.X, rows * cell.Y)
+    var dst draw.Image
+    if len(images) > 0 {
+        dst = t.canvas(images[0], bounds)
+    } else {
+        dst = image.NewNRGBA(bounds) // Empty.
+    }
+    draw.Draw(dst, dst.Bounds(), image.NewUniform(t.Background), image.Point{}, draw.Src)
+
+    tiles := make([]image.Rectangle, len(images))
+    for i, img := range images {
+        origin := image.Pt(i % cols * cell.X, i / cols * cell.Y)
+        tiles[i] = image.Rectangle{origin, origin.Add(img.Bounds().Size())}
+        draw.Draw(dst, tiles[i], img, img.Bounds().Min, draw.Over)
+    }
+
+    return dst, tiles
+}
+
+// Save encodes img to path according to Format and Quality, creating its
+// directory if needed, for callers that build their own images (e.g.
+// montages) from Thumbnail's output.
+func (t *Thumbnailer) Save(path string, img image.Image) error {
+    if err := t.storage().MkdirAll(DirPath(path)); err != nil {
+        return err
+    }
+    data, err := t.encodeThumb(img, t.Format, nil, "")
+    if err != nil {
+        return err
+    }
+    return t.writeThumb(path, data)
+}
@@ -0,0 +1,78 @@
+package thumbnail
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "testing"
+)
+
+//=============================================================================
+
+// Optimized PNGs decode to exactly the pixels of the plain encoding, as
+// gray or indexed where those fit, and never come out bigger.
+func TestOptimizePNG(t *testing.T) {
+    gray := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+    for y := 0; y < 64; y++ {
+        for x := 0; x < 64; x++ {
+            v := uint8(x * 4)
+            gray.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+        }
+    }
+    graphic := solidImage(64, 64, color.White)
+    draw.Draw(graphic, image.Rect(8, 8, 40, 40), image.NewUniform(color.NRGBA{200, 30, 30, 255}), image.Point{}, draw.Src)
+    draw.Draw(graphic, image.Rect(24, 24, 56, 56), image.NewUniform(color.NRGBA{30, 30, 200, 128}), image.Point{}, draw.Src)
+    translucent := gradientImage(64, 64)
+    for i := 3; i < len(translucent.Pix); i += 4 {
+        translucent.Pix[i] = uint8(i / 64)
+    }
+
+    photo := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+    draw.Draw(photo, photo.Bounds(), jpegTexture(t), image.Point{}, draw.Src)
+
+    tests := []struct {
+        name string
+        img  image.Image
+        want string
+    }{
+        {"photo", photo, "*image.RGBA"},
+        {"gray", gray, "*image.Gray"},
+        {"graphic", graphic, "*image.Paletted"},
+        {"translucent", translucent, "*image.NRGBA"},
+    }
+    for _, test := range tests {
+        plain := pngData(t, test.img)
+        optimized, saved := optimizePNG(plain)
+        if saved < 0 || saved != len(plain) - len(optimized) {
+            t.Errorf("%s: saved %d of %d, but it's %d", test.name, saved, len(plain), len(optimized))
+        }
+
+        img := decodeData(t, optimized)
+        if d := maxDiff(t, decodeData(t, plain), img); d != 0 {
+            t.Errorf("%s: off by up to %d", test.name, d)
+        }
+        if got := fmt.Sprintf("%T", img); got != test.want {
+            t.Errorf("%s: decodes as %s, want %s", test.name, got, test.want)
+        }
+    }
+
+    // What Optimize saves is tallied across thumbnails.
+    th := New()
+    th.Optimize = true
+    for i := 0; i < 2; i++ {
+        if _, err := th.encodeThumb(graphic, "png", nil, ""); err != nil {
+            t.Fatal(err)
+        }
+    }
+    _, saved := optimizePNG(pngData(t, graphic))
+    if got := th.OptimizeSaved(); got != int64(2 * saved) {
+        t.Errorf("Saved %d, want %d", got, 2 * saved)
+    }
+
+    data := []byte("not a png")
+    if got, saved := optimizePNG(data); !bytes.Equal(got, data) || saved != 0 {
+        t.Error("Changed something that isn't a PNG")
+    }
+}
@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "sort"
+)
+
+//=============================================================================
+
+// With Palette set, PNGs are written indexed, at a byte per pixel instead
+// of four. Median cut keeps photos looking right at 256 colors; dithering
+// hides most of the banding below that.
+
+// medianCut picks up to n colors for img by repeatedly splitting whichever
+// box of pixels spans the widest channel range, at its median.
+func medianCut(img image.Image, n int) color.Palette {
+    b := img.Bounds()
+    pixels := make([][4]uint8, 0, b.Dx() * b.Dy())
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+            pixels = append(pixels, [4]uint8{c.R, c.G, c.B, c.A})
+        }
+    }
+    if len(pixels) == 0 {
+        return color.Palette{color.Transparent}
+    }
+
+    boxes := [][][4]uint8{pixels}
+    for len(boxes) < n {
+        split, channel, widest := -1, 0, 0
+        for i, box := range boxes {
+            if len(box) < 2 {
+                continue
+            }
+            if ch, spread := widestChannel(box); spread > widest {
+                split, channel, widest = i, ch, spread
+            }
+        }
+        if split < 0 {
+            break // Every box is down to one color.
+        }
+
+        box := boxes[split]
+        sort.Slice(box, func (i, j int) bool { return box[i][channel] < box[j][channel] })
+        boxes[split] = box[:len(box) / 2]
+        boxes = append(boxes, box[len(box) / 2:])
+    }
+
+    palette := make(color.Palette, len(boxes))
+    for i, box := range boxes {
+        var sum [4]int
+        for _, p := range box {
+            for ch := range sum {
+                sum[ch] += int(p[ch])
+            }
+        }
+        count := len(box)
+        palette[i] = color.NRGBA{
+            uint8(sum[0] / count), uint8(sum[1] / count), uint8(sum[2] / count), uint8(sum[3] / count),
+        }
+    }
+    return palette
+}
+
+func widestChannel(box [][4]uint8) (channel, spread int) {
+    for ch := 0; ch < 4; ch++ {
+        lo, hi := box[0][ch], box[0][ch]
+        for _, p := range box {
+            if p[ch] < lo {
+                lo = p[ch]
+            }
+            if p[ch] > hi {
+                hi = p[ch]
+            }
+        }
+        if int(hi - lo) > spread {
+            channel, spread = ch, int(hi - lo)
+        }
+    }
+    return channel, spread
+}
+
+// quantize dithers img onto an n color median cut palette.
+func quantize(img image.Image, n int) *image.Paletted {
+    dst := image.NewPaletted(img.Bounds(), medianCut(img, n))
+    draw.FloydSteinberg.Draw(dst, dst.Bounds(), img, img.Bounds().Min)
+    return dst
+}
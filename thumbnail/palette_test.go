@@ -0,0 +1,141 @@
+package thumbnail
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "testing"
+)
+
+//=============================================================================
+
+func TestPalettePNG(t *testing.T) {
+    src := gradientImage(64, 64)
+    for _, n := range []int{2, 16, 256} {
+        th := New()
+        th.Palette = n
+        data, err := th.encodeThumb(src, "png", nil, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        img, err := png.Decode(bytes.NewReader(data))
+        if err != nil {
+            t.Fatal(err)
+        }
+        paletted, ok := img.(*image.Paletted)
+        if !ok {
+            t.Fatalf("Palette %d: decoded as %T, want indexed", n, img)
+        }
+        if len(paletted.Palette) > n {
+            t.Errorf("Palette %d: %d colors", n, len(paletted.Palette))
+        }
+        used := make(map[uint8]bool)
+        for _, i := range paletted.Pix {
+            used[i] = true
+        }
+        if len(used) > n {
+            t.Errorf("Palette %d: %d colors used", n, len(used))
+        }
+    }
+
+    for _, n := range []int{1, 257, -1} {
+        th := New()
+        th.Palette = n
+        if th.Validate() == nil {
+            t.Errorf("Palette %d validated", n)
+        }
+    }
+}
+
+// meanDiff is maxDiff's mean over every channel of every pixel instead.
+func meanDiff(t testing.TB, a, b image.Image) float64 {
+    t.Helper()
+    if a.Bounds().Size() != b.Bounds().Size() {
+        t.Fatalf("Sizes differ: %v and %v", a.Bounds().Size(), b.Bounds().Size())
+    }
+    total := 0
+    da := a.Bounds().Min.Sub(b.Bounds().Min)
+    for y := b.Bounds().Min.Y; y < b.Bounds().Max.Y; y++ {
+        for x := b.Bounds().Min.X; x < b.Bounds().Max.X; x++ {
+            ca := color.NRGBAModel.Convert(a.At(x + da.X, y + da.Y)).(color.NRGBA)
+            cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+            total += diff(ca.R, cb.R) + diff(ca.G, cb.G) + diff(ca.B, cb.B) + diff(ca.A, cb.A)
+        }
+    }
+    return float64(total) / float64(4 * b.Bounds().Dx() * b.Bounds().Dy())
+}
+
+// photoThumb is the center thumbnail of testdata/cmyk.jpg, a photo with
+// plenty of colors to quantize.
+func photoThumb(t testing.TB) image.Image {
+    t.Helper()
+    data, err := os.ReadFile("testdata/cmyk.jpg")
+    if err != nil {
+        t.Fatal(err)
+    }
+    th := testThumbnailer(96, 96)
+    src, err := th.Decode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return th.Thumbnail(src)["center"]
+}
+
+// On a photo, an indexed PNG is smaller than the full-color one, and still
+// close to it.
+func TestPalettePhoto(t *testing.T) {
+    thumb := photoThumb(t)
+    full, err := New().encodeThumb(thumb, "png", nil, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        n     int
+        worst int
+        mean  float64
+    }{
+        {256, 40, 2.5},
+        {64, 64, 4.5},
+    }
+    for _, test := range tests {
+        th := New()
+        th.Palette = test.n
+        data, err := th.encodeThumb(thumb, "png", nil, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(data) >= len(full) {
+            t.Errorf("Palette %d: %d bytes, full color %d", test.n, len(data), len(full))
+        }
+        img := decodeData(t, data)
+        worst, mean := maxDiff(t, thumb, img), meanDiff(t, thumb, img)
+        if worst > test.worst || mean > test.mean {
+            t.Errorf("Palette %d: off by up to %d, %.2f on average", test.n, worst, mean)
+        }
+    }
+}
+
+// Reports each palette's PNG size in bytes, 0 being full color.
+func BenchmarkPalettePNG(b *testing.B) {
+    thumb := photoThumb(b)
+    for _, n := range []int{0, 256, 64, 16} {
+        b.Run(fmt.Sprintf("colors-%d", n), func(b *testing.B) {
+            th := New()
+            th.Palette = n
+            var size int
+            for i := 0; i < b.N; i++ {
+                data, err := th.encodeThumb(thumb, "png", nil, "")
+                if err != nil {
+                    b.Fatal(err)
+                }
+                size = len(data)
+            }
+            b.ReportMetric(float64(size), "bytes")
+        })
+    }
+}
@@ -0,0 +1,106 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "image"
+    "image/color"
+)
+
+//=============================================================================
+
+// With Passthrough, a source that's already its thumbnail's size (exactly
+// Dim, MaxSide on its longer side, or anything at a Scale of 1) skips
+// resampling, which would only soften it: every crop, fit or stretch of it
+// is itself. Adjustments, decorations and flips still apply. When none of
+// them change the pixels and the formats match, the unflipped outputs are
+// the source bytes copied as is, which also spares a lossy JPEG re-encode.
+// A copy would keep whatever else the source carries, though, so one with
+// metadata, a profile for ICCConvert to apply, or CMYK pixels is encoded
+// like any other.
+
+func (t *Thumbnailer) isPassthrough(size image.Point) bool {
+    if !t.Passthrough || !t.Crop.Empty() || t.AutoTrim || t.Augment > 0 {
+        return false
+    }
+    if t.MaxSide > 0 {
+        return longSide(size) == t.MaxSide
+    }
+    if t.Scale > 0 {
+        return t.Scale == 1
+    }
+    // Square mode on a non-square Dim squashes even a source of that size.
+    if t.Mode == "square" && t.Dim[0] != t.Dim[1] {
+        return false
+    }
+    return size.X == t.Dim[0] && size.Y == t.Dim[1]
+}
+
+// passthroughThumbs is Thumbnail for a source isPassthrough accepts.
+func (t *Thumbnailer) passthroughThumbs(src image.Image) map[string]image.Image {
+    thumbs := make(map[string]image.Image)
+    for _, v := range t.variants() {
+        if !v.Flipped {
+            t.addVariants(thumbs, v.Name, src)
+        }
+    }
+    return thumbs
+}
+
+// passthroughBytes returns inputPath's bytes if they can stand in for an
+// unflipped thumbnail in format as is: same format, no orientation to
+// undo, no option that would change a pixel, no checksum or DPI to
+// record, and nothing in it a thumbnail wouldn't have. (PreserveMetadata
+// keeps only a few tags, so it's no reason to copy the rest.) Otherwise it
+// returns nil.
+func (t *Thumbnailer) passthroughBytes(inputPath, format string) []byte {
+    if len(t.adjustments()) > 0 || t.decoration() != nil || t.watermark() != nil || t.Flatten || t.Palette > 0 || t.EmbedChecksum && format == "png" || t.DPI > 0 && format != "webp" {
+        return nil
+    }
+
+    data, err := t.storage().ReadFile(inputPath)
+    if err != nil {
+        return nil
+    }
+
+    config, source, err := image.DecodeConfig(bytes.NewReader(data))
+    if err != nil || source != format || t.AutoOrient && exifOrientation(data) > 1 {
+        return nil
+    }
+    if hasMetadata(data) || t.ICCConvert && iccProfile(data) != nil || config.ColorModel == color.CMYKModel {
+        return nil
+    }
+    return data
+}
+
+// hasMetadata reports whether a JPEG has EXIF, XMP or IPTC segments (APP1
+// or APP13), or a PNG eXIf or text chunks.
+func hasMetadata(data []byte) bool {
+    if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+        for i := 8; i + 12 <= len(data); {
+            n := int(binary.BigEndian.Uint32(data[i:]))
+            if n < 0 || i + 12 + n > len(data) {
+                break
+            }
+            switch string(data[i + 4:i + 8]) {
+            case "eXIf", "tEXt", "zTXt", "iTXt":
+                return true
+            }
+            i += 12 + n
+        }
+        return false
+    }
+
+    for pos := 2; pos + 4 <= len(data) && data[pos] == 0xFF; {
+        kind := data[pos + 1]
+        size := int(binary.BigEndian.Uint16(data[pos + 2:]))
+        if kind == 0xDA || size < 2 {
+            break
+        }
+        if kind == 0xE1 || kind == 0xED {
+            return true
+        }
+        pos += 2 + size
+    }
+    return false
+}
@@ -0,0 +1,149 @@
+package thumbnail
+
+import (
+    "bytes"
+    "image/png"
+    "os"
+    "testing"
+)
+
+//=============================================================================
+
+// A source already at Dim isn't resampled: its unflipped outputs are the
+// source file as is when the formats match, and its flips exact mirrors.
+func TestPassthroughCopies(t *testing.T) {
+    storage := newMemStorage()
+    src := gradientImage(224, 224)
+    // Not how the thumbnailer would compress it, so a re-encode shows.
+    var buf bytes.Buffer
+    encoder := png.Encoder{CompressionLevel: png.NoCompression}
+    if err := encoder.Encode(&buf, src); err != nil {
+        t.Fatal(err)
+    }
+    data := buf.Bytes()
+    storage.WriteFile("in/a.png", data)
+
+    th := New()
+    th.Storage = storage
+    th.Passthrough = true
+    if err := th.ProcessFile("in/a.png", "out"); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, anchor := range []string{"left", "center", "right"} {
+        if got, _ := storage.ReadFile("out/a_" + anchor + ".png"); !bytes.Equal(got, data) {
+            t.Errorf("%s isn't a copy of the source", anchor)
+        }
+        flipped, err := storage.ReadFile("out/a_" + anchor + "_flipped.png")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if d := maxDiff(t, mirror(src), decodeData(t, flipped)); d != 0 {
+            t.Errorf("%s_flipped is off by up to %d from the mirrored source", anchor, d)
+        }
+    }
+}
+
+// A JPEG source can't be copied to a PNG, but its pixels still come
+// through, give or take YCbCr's rounding.
+func TestPassthroughReencodes(t *testing.T) {
+    storage := newMemStorage()
+    data := jpegData(t, gradientImage(224, 224), 90)
+    storage.WriteFile("in/a.jpg", data)
+
+    th := New()
+    th.Storage = storage
+    th.Passthrough = true
+    src, err := th.Decode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := th.ProcessFile("in/a.jpg", "out"); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, key := range []string{"left", "center", "right"} {
+        got, err := storage.ReadFile("out/a_" + key + ".png")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if d := maxDiff(t, src, decodeData(t, got)); d > 1 {
+            t.Errorf("%s is off by up to %d from the source", key, d)
+        }
+    }
+}
+
+// Only an exact fit passes through; a pixel off, or an adjustment to
+// make, and the source is thumbnailed and encoded as usual.
+func TestPassthroughOnlyExact(t *testing.T) {
+    th := New()
+    th.Passthrough = true
+    for _, size := range [][2]int{{225, 224}, {224, 225}, {448, 448}} {
+        storage := newMemStorage()
+        data := pngData(t, gradientImage(size[0], size[1]))
+        storage.WriteFile("in/a.png", data)
+        th.Storage = storage
+        if err := th.ProcessFile("in/a.png", "out"); err != nil {
+            t.Fatal(err)
+        }
+        if got, _ := storage.ReadFile("out/a_center.png"); bytes.Equal(got, data) {
+            t.Errorf("%dx%d copied", size[0], size[1])
+        }
+    }
+
+    storage := newMemStorage()
+    storage.WriteFile("in/a.png", pngData(t, gradientImage(224, 224)))
+    th.Storage = storage
+    if th.passthroughBytes("in/a.png", "jpeg") != nil {
+        t.Error("Copied a PNG as a JPEG")
+    }
+    th.Sharpen = 1
+    if th.passthroughBytes("in/a.png", "png") != nil {
+        t.Error("Copied with sharpening to do")
+    }
+}
+
+// A copy would carry everything in the source along, so metadata, a
+// profile ICCConvert would apply, or CMYK pixels mean encoding afresh.
+func TestPassthroughWithoutExtras(t *testing.T) {
+    plainJPEG := jpegData(t, gradientImage(224, 224), 90)
+    plainPNG := pngData(t, gradientImage(224, 224))
+    cmyk, err := os.ReadFile("testdata/cmyk.jpg")
+    if err != nil {
+        t.Fatal(err)
+    }
+    profile := append([]byte("ICC_PROFILE\x00\x01\x01"), "Stand-in profile"...)
+    app2 := append([]byte{0xFF, 0xE2, 0, byte(len(profile) + 2)}, profile...)
+
+    tests := []struct {
+        name   string
+        data   []byte
+        format string
+        set    func(*Thumbnailer)
+        copied bool
+    }{
+        {"plain jpeg", plainJPEG, "jpeg", nil, true},
+        {"plain png", plainPNG, "png", nil, true},
+        {"exif", withSegment(plainJPEG, exifSegmentFor(1)), "jpeg", nil, false},
+        {"exif, preserved", withSegment(plainJPEG, exifSegmentFor(1)), "jpeg", func(th *Thumbnailer) { th.PreserveMetadata = true }, false},
+        {"png text", withChunk(plainPNG, textChunk("Comment", "hi")), "png", nil, false},
+        {"profile", withSegment(plainJPEG, app2), "jpeg", nil, true},
+        {"profile to convert", withSegment(plainJPEG, app2), "jpeg", func(th *Thumbnailer) { th.ICCConvert = true }, false},
+        {"cmyk", cmyk, "jpeg", func(th *Thumbnailer) { th.Dim = [2]int{150, 103} }, false},
+    }
+
+    for _, test := range tests {
+        storage := newMemStorage()
+        storage.WriteFile("in/a", test.data)
+        th := New()
+        th.Storage = storage
+        th.Passthrough = true
+        th.Format = test.format
+        if test.set != nil {
+            test.set(th)
+        }
+        if got := th.passthroughBytes("in/a", test.format) != nil; got != test.copied {
+            t.Errorf("%s: copied %v, want %v", test.name, got, test.copied)
+        }
+    }
+}
@@ -0,0 +1,182 @@
+package thumbnail
+
+import (
+    "github.com/disintegration/gift"
+    "image"
+    "math"
+    "math/bits"
+    "sort"
+)
+
+//=============================================================================
+
+// The hash is the classic DCT pHash: shrink to 32x32 luminance, take the
+// DCT, and keep the sign (relative to the median) of the 8x8 lowest
+// frequencies. Re-encodes and small resizes barely move those.
+
+const phashSize = 32
+const phashBits = 8
+
+func perceptualHash(src image.Image) uint64 {
+    g := gift.New(
+        gift.Resize(phashSize, phashSize, gift.LinearResampling),
+        gift.Grayscale(),
+    )
+    small := image.NewGray(g.Bounds(src.Bounds()))
+    g.Draw(small, src)
+
+    var pixels [phashSize][phashSize]float64
+    for y := 0; y < phashSize; y++ {
+        for x := 0; x < phashSize; x++ {
+            pixels[y][x] = float64(small.GrayAt(x, y).Y)
+        }
+    }
+
+    coeffs := dct2d(&pixels)
+
+    // Skip the DC term; it only encodes overall brightness.
+    var values []float64
+    for y := 0; y < phashBits; y++ {
+        for x := 0; x < phashBits; x++ {
+            if x == 0 && y == 0 {
+                continue
+            }
+            values = append(values, coeffs[y][x])
+        }
+    }
+
+    sorted := append([]float64(nil), values...)
+    sort.Float64s(sorted)
+    median := sorted[len(sorted) / 2]
+
+    var hash uint64
+    for i, v := range values {
+        if v > median {
+            hash |= 1 << uint(i)
+        }
+    }
+
+    return hash
+}
+
+// Only the low frequencies are used, so just those rows and columns are
+// computed.
+func dct2d(pixels *[phashSize][phashSize]float64) [phashBits][phashBits]float64 {
+    var cosines [phashBits][phashSize]float64
+    for u := 0; u < phashBits; u++ {
+        for x := 0; x < phashSize; x++ {
+            cosines[u][x] = math.Cos(float64(2*x + 1) * float64(u) * math.Pi / (2 * phashSize))
+        }
+    }
+
+    var rows [phashSize][phashBits]float64
+    for y := 0; y < phashSize; y++ {
+        for u := 0; u < phashBits; u++ {
+            var sum float64
+            for x := 0; x < phashSize; x++ {
+                sum += pixels[y][x] * cosines[u][x]
+            }
+            rows[y][u] = sum
+        }
+    }
+
+    var out [phashBits][phashBits]float64
+    for v := 0; v < phashBits; v++ {
+        for u := 0; u < phashBits; u++ {
+            var sum float64
+            for y := 0; y < phashSize; y++ {
+                sum += rows[y][u] * cosines[v][y]
+            }
+            out[v][u] = sum
+        }
+    }
+
+    return out
+}
+
+func hammingDistance(a, b uint64) int {
+    return bits.OnesCount64(a ^ b)
+}
+
+//=============================================================================
+
+// A BK-tree makes "anything within distance d" lookups sublinear. Children
+// are keyed by their distance to the parent, so the triangle inequality
+// lets a search ignore every branch outside [dist - d, dist + d].
+
+type bkNode struct {
+    hash     uint64
+    path     string
+    removed  bool
+    children map[int]*bkNode
+}
+
+type bkTree struct {
+    root *bkNode
+}
+
+// find returns the path of some previously added hash within maxDist.
+func (t *bkTree) find(hash uint64, maxDist int) (string, bool) {
+    if t.root == nil {
+        return "", false
+    }
+
+    pending := []*bkNode{t.root}
+    for len(pending) > 0 {
+        node := pending[len(pending) - 1]
+        pending = pending[:len(pending) - 1]
+
+        d := hammingDistance(hash, node.hash)
+        if d <= maxDist && !node.removed {
+            return node.path, true
+        }
+
+        for k, child := range node.children {
+            if k >= d - maxDist && k <= d + maxDist {
+                pending = append(pending, child)
+            }
+        }
+    }
+
+    return "", false
+}
+
+// remove takes path out of the tree. Its node stays, since the nodes under
+// it are placed by their distance to it, but find passes over it.
+func (t *bkTree) remove(path string) {
+    if t.root == nil {
+        return
+    }
+    pending := []*bkNode{t.root}
+    for len(pending) > 0 {
+        node := pending[len(pending) - 1]
+        pending = pending[:len(pending) - 1]
+        if node.path == path {
+            node.removed = true
+        }
+        for _, child := range node.children {
+            pending = append(pending, child)
+        }
+    }
+}
+
+func (t *bkTree) add(hash uint64, path string) {
+    if t.root == nil {
+        t.root = &bkNode{hash: hash, path: path}
+        return
+    }
+
+    node := t.root
+    for {
+        d := hammingDistance(hash, node.hash)
+        child, found := node.children[d]
+        if !found {
+            if node.children == nil {
+                node.children = make(map[int]*bkNode)
+            }
+            node.children[d] = &bkNode{hash: hash, path: path}
+            return
+        }
+        node = child
+    }
+}
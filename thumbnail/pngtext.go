@@ -0,0 +1,70 @@
+package thumbnail
+
+import (
+    "encoding/binary"
+    "hash/crc32"
+    "strings"
+)
+
+//=============================================================================
+
+// With EmbedChecksum, PNG thumbnails carry their source's checksum in a
+// tEXt chunk keyed Source-CRC32 or Source-SHA256 (after Hash), so a
+// thumbnail found on its own still says which file it came from. Any PNG
+// tool shows it, e.g. exiftool or pngcheck -t. image/png doesn't write
+// text chunks, so it's spliced into the encoded bytes after the IHDR.
+
+// Signature plus the IHDR chunk, which always holds 13 bytes.
+const pngHeaderLen = 8 + 4 + 4 + 13 + 4
+
+// ChecksumKey is the tEXt keyword a checksum under hash is stored as.
+func ChecksumKey(hash string) string {
+    return "Source-" + strings.ToUpper(hash)
+}
+
+// pngChunk encodes a PNG chunk of the given kind, e.g. "tEXt".
+func pngChunk(kind string, data []byte) []byte {
+    chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+    chunk = append(chunk, kind...)
+    chunk = append(chunk, data...)
+    return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// textChunk encodes a PNG tEXt chunk. Keywords are Latin-1, 1-79 bytes.
+func textChunk(keyword, text string) []byte {
+    data := append([]byte(keyword), 0)
+    return pngChunk("tEXt", append(data, text...))
+}
+
+// withChunk inserts chunk into an encoded PNG, right after its IHDR.
+func withChunk(png, chunk []byte) []byte {
+    if len(chunk) == 0 || len(png) < pngHeaderLen {
+        return png
+    }
+    out := make([]byte, 0, len(png) + len(chunk))
+    out = append(out, png[:pngHeaderLen]...)
+    out = append(out, chunk...)
+    return append(out, png[pngHeaderLen:]...)
+}
+
+// PNGText returns the text of the first tEXt chunk under keyword in an
+// encoded PNG, e.g. to read back ChecksumKey.
+func PNGText(png []byte, keyword string) (string, bool) {
+    for i := 8; i + 12 <= len(png); {
+        n := int(binary.BigEndian.Uint32(png[i:]))
+        if n < 0 || i + 12 + n > len(png) {
+            break
+        }
+        kind, data := string(png[i + 4:i + 8]), png[i + 8:i + 8 + n]
+        if kind == "tEXt" {
+            if key, text, found := strings.Cut(string(data), "\x00"); found && key == keyword {
+                return text, true
+            }
+        }
+        if kind == "IEND" {
+            break
+        }
+        i += 12 + n
+    }
+    return "", false
+}
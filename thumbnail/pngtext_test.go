@@ -0,0 +1,65 @@
+package thumbnail
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "hash/crc32"
+    "testing"
+)
+
+//=============================================================================
+
+// With EmbedChecksum, every PNG thumbnail's tEXt chunk reads back as the
+// source's checksum, under the hash's own keyword, and the pixels are as
+// they'd be without it.
+func TestEmbedChecksum(t *testing.T) {
+    data := pngData(t, gradientImage(32, 32))
+    sums := map[string]string{
+        "crc32": fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)),
+        "sha256": fmt.Sprintf("%x", sha256.Sum256(data)),
+    }
+
+    for hash, want := range sums {
+        storage := newMemStorage()
+        storage.WriteFile("in/a.png", data)
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        th.Hash = hash
+        th.EmbedChecksum = true
+        result, err := th.Process("in/a.png", "out")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if result.Checksum != want {
+            t.Errorf("%s: result checksum %s, want %s", hash, result.Checksum, want)
+        }
+
+        plain := th.Thumbnail(decodeData(t, data))
+        for _, o := range result.Outputs {
+            out, _ := storage.ReadFile(o.Path)
+            if got, found := PNGText(out, ChecksumKey(hash)); !found || got != want {
+                t.Errorf("%s %s: read back %q, %v, want %s", hash, o.Key(), got, found, want)
+            }
+            if d := maxDiff(t, plain[o.Key()], decodeData(t, out)); d != 0 {
+                t.Errorf("%s %s: off by up to %d from the thumbnail", hash, o.Key(), d)
+            }
+        }
+    }
+
+    if ChecksumKey("crc32") != "Source-CRC32" || ChecksumKey("sha256") != "Source-SHA256" {
+        t.Errorf("Keys %s, %s", ChecksumKey("crc32"), ChecksumKey("sha256"))
+    }
+}
+
+func TestPNGText(t *testing.T) {
+    plain := pngData(t, gradientImage(4, 4))
+    tagged := withChunk(withChunk(plain, textChunk("Other", "x")), textChunk("Key", "value"))
+    if got, found := PNGText(tagged, "Key"); !found || got != "value" {
+        t.Errorf("Got %q, %v", got, found)
+    }
+    for name, data := range map[string][]byte{"untagged": plain, "not a png": jpegData(t, gradientImage(4, 4), 90), "truncated": tagged[:40]} {
+        if got, found := PNGText(data, "Key"); found {
+            t.Errorf("%s: found %q", name, got)
+        }
+    }
+}
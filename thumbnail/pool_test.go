@@ -0,0 +1,83 @@
+package thumbnail
+
+import (
+    "context"
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+func TestPooledBufferCleared(t *testing.T) {
+    used := newNRGBA(image.Rect(0, 0, 32, 32))
+    for i := range used.Pix {
+        used.Pix[i] = 0xFF
+    }
+    release(used)
+
+    // Smaller ones come off the same buffer.
+    for _, size := range []int{32, 16} {
+        img := newNRGBA(image.Rect(0, 0, size, size))
+        for i, v := range img.Pix {
+            if v != 0 {
+                t.Fatalf("%dx%d: byte %d is %d from the last image", size, size, i, v)
+            }
+        }
+        release(img)
+    }
+}
+
+// Thumbnails of an image come out the same whatever the pool held before.
+func TestPoolDoesntLeak(t *testing.T) {
+    th := testThumbnailer(16, 16)
+    th.Mode = "fit"
+    th.PadColor = color.Transparent
+    src := gradientImage(48, 32)
+
+    fresh := th.Thumbnail(src)
+    want := image.NewNRGBA(fresh["fit"].Bounds())
+    copy(want.Pix, fresh["fit"].(*image.NRGBA).Pix)
+
+    th.Release(th.Thumbnail(solidImage(48, 32, color.White)))
+    th.Release(fresh)
+
+    if d := maxDiff(t, want, th.Thumbnail(src)["fit"]); d != 0 {
+        t.Errorf("Off by up to %d after the pool was used", d)
+    }
+}
+
+// A 640x480 JPEG, thumbnailed at the defaults: 224x224 PNGs, three
+// anchors, flipped. pool.go's figures are B/op from these, with and
+// without newNRGBA taking from pixPool.
+func benchmarkSource(b *testing.B) []byte {
+    return jpegData(b, gradientImage(640, 480), 90)
+}
+
+func BenchmarkThumbnail(b *testing.B) {
+    th := New()
+    src, err := th.Decode(benchmarkSource(b))
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        th.Release(th.Thumbnail(src))
+    }
+}
+
+func BenchmarkProcessAs(b *testing.B) {
+    storage := newMemStorage()
+    storage.WriteFile("in/a.jpg", benchmarkSource(b))
+    th := New()
+    th.Storage = storage
+    th.Deduplicate = false
+
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        if _, err := th.ProcessAs(context.Background(), "in/a.jpg", "out", "a"); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
@@ -0,0 +1,371 @@
+package thumbnail
+
+import (
+    "bufio"
+    "image"
+    "image/color"
+    "io"
+    "math"
+)
+
+//=============================================================================
+
+// image/jpeg only writes baseline JPEGs, so Progressive gets this small
+// encoder instead. It's spectral selection only (no successive
+// approximation) with the standard Huffman tables and no chroma
+// subsampling: a browser paints the DC scan, a blocky preview, and
+// sharpens it as the AC scans arrive. Files come out a little bigger than
+// image/jpeg's 4:2:0, which is the price of keeping it short.
+
+// The Annex K quantization tables, in zig-zag order.
+var progressiveQuant = [2][64]int{
+    {
+        16, 11, 12, 14, 12, 10, 16, 14,
+        13, 14, 18, 17, 16, 19, 24, 40,
+        26, 24, 22, 22, 24, 49, 35, 37,
+        29, 40, 58, 51, 61, 60, 57, 51,
+        56, 55, 64, 72, 92, 78, 64, 68,
+        87, 69, 55, 56, 80, 109, 81, 87,
+        95, 98, 103, 104, 103, 62, 77, 113,
+        121, 112, 100, 120, 92, 101, 103, 99,
+    },
+    {
+        17, 18, 18, 24, 21, 24, 47, 26,
+        26, 47, 99, 66, 56, 66, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+        99, 99, 99, 99, 99, 99, 99, 99,
+    },
+}
+
+// zigzag[i] is the natural (row-major) index of the i'th coefficient.
+var zigzag = [64]int{
+    0, 1, 8, 16, 9, 2, 3, 10,
+    17, 24, 32, 25, 18, 11, 4, 5,
+    12, 19, 26, 33, 40, 48, 41, 34,
+    27, 20, 13, 6, 7, 14, 21, 28,
+    35, 42, 49, 56, 57, 50, 43, 36,
+    29, 22, 15, 23, 30, 37, 44, 51,
+    58, 59, 52, 45, 38, 31, 39, 46,
+    53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type huffmanSpec struct {
+    counts [16]byte // Codes of each length, 1-16 bits.
+    values []byte
+}
+
+// The Annex K Huffman tables: luminance DC and AC, then chrominance.
+var progressiveHuffman = [4]huffmanSpec{
+    {
+        [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+        []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+    },
+    {
+        [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+        []byte{
+            0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+            0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+            0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+            0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+            0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+            0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+            0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+            0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+            0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+            0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+            0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+            0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+            0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+            0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+            0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+            0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+            0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+            0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+            0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+            0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+            0xf9, 0xfa,
+        },
+    },
+    {
+        [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+        []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+    },
+    {
+        [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+        []byte{
+            0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+            0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+            0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+            0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+            0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+            0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+            0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+            0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+            0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+            0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+            0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+            0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+            0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+            0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+            0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+            0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+            0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+            0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+            0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+            0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+            0xf9, 0xfa,
+        },
+    },
+}
+
+// A scan is one pass over the coefficients Ss-Se of some components.
+type progressiveScan struct {
+    components []int
+    ss, se     int
+}
+
+// DC first, then the low luma frequencies, chroma, and the rest of luma.
+var progressiveScans = []progressiveScan{
+    {[]int{0, 1, 2}, 0, 0},
+    {[]int{0}, 1, 5},
+    {[]int{1}, 1, 63},
+    {[]int{2}, 1, 63},
+    {[]int{0}, 6, 63},
+}
+
+type huffmanCode struct {
+    code uint32
+    size uint
+}
+
+func (s huffmanSpec) codes() map[byte]huffmanCode {
+    codes := make(map[byte]huffmanCode)
+    code, k := uint32(0), 0
+    for i, n := range s.counts {
+        for j := 0; j < int(n); j++ {
+            codes[s.values[k]] = huffmanCode{code, uint(i + 1)}
+            code++
+            k++
+        }
+        code <<= 1
+    }
+    return codes
+}
+
+// bitWriter packs entropy-coded data, stuffing a zero after every 0xFF.
+type bitWriter struct {
+    w     *bufio.Writer
+    bits  uint32
+    nBits uint
+}
+
+func (b *bitWriter) write(bits uint32, n uint) {
+    b.bits = b.bits << n | bits & (1 << n - 1)
+    b.nBits += n
+    for b.nBits >= 8 {
+        c := byte(b.bits >> (b.nBits - 8))
+        b.w.WriteByte(c)
+        if c == 0xFF {
+            b.w.WriteByte(0)
+        }
+        b.nBits -= 8
+    }
+}
+
+// flush pads the last byte with ones, as a scan must end.
+func (b *bitWriter) flush() {
+    if b.nBits > 0 {
+        b.write(0xFF, 8 - b.nBits)
+    }
+    b.bits = 0
+}
+
+// magnitude is v's JPEG size category and its bits within it.
+func magnitude(v int) (uint, uint32) {
+    a := v
+    if a < 0 {
+        a = -a
+        v -= 1
+    }
+    size := uint(0)
+    for a > 0 {
+        size++
+        a >>= 1
+    }
+    return size, uint32(v)
+}
+
+var dctCos = func() (c [8][8]float64) {
+    for x := 0; x < 8; x++ {
+        for u := 0; u < 8; u++ {
+            c[x][u] = math.Cos(float64(2 * x + 1) * float64(u) * math.Pi / 16)
+        }
+    }
+    return c
+}()
+
+// fdct transforms a level-shifted block in place.
+func fdct(block *[64]float64) {
+    var out [64]float64
+    for v := 0; v < 8; v++ {
+        for u := 0; u < 8; u++ {
+            s := 0.0
+            for y := 0; y < 8; y++ {
+                for x := 0; x < 8; x++ {
+                    s += block[y * 8 + x] * dctCos[x][u] * dctCos[y][v]
+                }
+            }
+            cu, cv := 1.0, 1.0
+            if u == 0 {
+                cu = math.Sqrt2 / 2
+            }
+            if v == 0 {
+                cv = math.Sqrt2 / 2
+            }
+            out[v * 8 + u] = s * cu * cv / 4
+        }
+    }
+    *block = out
+}
+
+// scaledQuant is progressiveQuant at quality, the way libjpeg scales it.
+func scaledQuant(quality int) (q [2][64]int) {
+    quality = int(math.Max(1, math.Min(100, float64(quality))))
+    scale := 200 - quality * 2
+    if quality < 50 {
+        scale = 5000 / quality
+    }
+    for i := range q {
+        for j, v := range progressiveQuant[i] {
+            q[i][j] = int(math.Max(1, math.Min(255, float64((v * scale + 50) / 100))))
+        }
+    }
+    return q
+}
+
+// encodeProgressive writes img as a progressive YCbCr JPEG.
+func encodeProgressive(w io.Writer, img image.Image, quality int) error {
+    b := img.Bounds()
+    bw, bh := (b.Dx() + 7) / 8, (b.Dy() + 7) / 8
+    quant := scaledQuant(quality)
+
+    // Every scan revisits every block, so they're all transformed first,
+    // quantized and in zig-zag order. Edge blocks repeat the last pixel.
+    coefs := [3][][64]int{}
+    for c := range coefs {
+        coefs[c] = make([][64]int, bw * bh)
+    }
+    for by := 0; by < bh; by++ {
+        for bx := 0; bx < bw; bx++ {
+            var planes [3][64]float64
+            for y := 0; y < 8; y++ {
+                for x := 0; x < 8; x++ {
+                    px := b.Min.X + int(math.Min(float64(bx * 8 + x), float64(b.Dx() - 1)))
+                    py := b.Min.Y + int(math.Min(float64(by * 8 + y), float64(b.Dy() - 1)))
+                    r, g, bl, _ := img.At(px, py).RGBA()
+                    yy, cb, cr := color.RGBToYCbCr(uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8))
+                    planes[0][y * 8 + x] = float64(yy) - 128
+                    planes[1][y * 8 + x] = float64(cb) - 128
+                    planes[2][y * 8 + x] = float64(cr) - 128
+                }
+            }
+            for c := range planes {
+                fdct(&planes[c])
+                table := quant[min(c, 1)]
+                for k := 0; k < 64; k++ {
+                    coefs[c][by * bw + bx][k] = int(math.Round(planes[c][zigzag[k]] / float64(table[k])))
+                }
+            }
+        }
+    }
+
+    out := bufio.NewWriter(w)
+    marker := func(m byte, payload ...byte) {
+        out.Write([]byte{0xFF, m, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+        out.Write(payload)
+    }
+
+    out.Write([]byte{0xFF, 0xD8})
+
+    dqt := []byte{}
+    for i := range quant {
+        dqt = append(dqt, byte(i))
+        for _, v := range quant[i] {
+            dqt = append(dqt, byte(v))
+        }
+    }
+    marker(0xDB, dqt...)
+
+    // SOF2, 8-bit, three components at 1x1 sampling; Y on table 0.
+    marker(0xC2, 8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), 3,
+        1, 0x11, 0, 2, 0x11, 1, 3, 0x11, 1)
+
+    dht := []byte{}
+    for i, spec := range progressiveHuffman {
+        dht = append(dht, byte(i % 2 << 4 | i / 2))
+        dht = append(dht, spec.counts[:]...)
+        dht = append(dht, spec.values...)
+    }
+    marker(0xC4, dht...)
+
+    var codes [4]map[byte]huffmanCode
+    for i, spec := range progressiveHuffman {
+        codes[i] = spec.codes()
+    }
+    bits := &bitWriter{w: out}
+    emit := func(table int, symbol byte) {
+        h := codes[table][symbol]
+        bits.write(h.code, h.size)
+    }
+
+    for _, scan := range progressiveScans {
+        sos := []byte{byte(len(scan.components))}
+        for _, c := range scan.components {
+            table := byte(min(c, 1))
+            sos = append(sos, byte(c + 1), table << 4 | table)
+        }
+        marker(0xDA, append(sos, byte(scan.ss), byte(scan.se), 0)...)
+
+        var pred [3]int
+        for i := 0; i < bw * bh; i++ {
+            for _, c := range scan.components {
+                block := &coefs[c][i]
+                table := min(c, 1) * 2
+
+                if scan.ss == 0 {
+                    size, v := magnitude(block[0] - pred[c])
+                    pred[c] = block[0]
+                    emit(table, byte(size))
+                    bits.write(v, size)
+                    continue
+                }
+
+                run := 0
+                for k := scan.ss; k <= scan.se; k++ {
+                    if block[k] == 0 {
+                        run++
+                        continue
+                    }
+                    for ; run > 15; run -= 16 {
+                        emit(table + 1, 0xF0)
+                    }
+                    size, v := magnitude(block[k])
+                    emit(table + 1, byte(run << 4) | byte(size))
+                    bits.write(v, size)
+                    run = 0
+                }
+                if run > 0 {
+                    emit(table + 1, 0x00) // EOB.
+                }
+            }
+        }
+        bits.flush()
+    }
+
+    out.Write([]byte{0xFF, 0xD9})
+    return out.Flush()
+}
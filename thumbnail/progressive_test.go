@@ -0,0 +1,66 @@
+package thumbnail
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+)
+
+//=============================================================================
+
+// jpegMarkers lists a JPEG's markers up to the end of image: the segments,
+// then each scan's SOS, skipping over the entropy-coded data between.
+func jpegMarkers(data []byte) []byte {
+    var markers []byte
+    for pos := 2; pos + 4 <= len(data); {
+        if data[pos] != 0xFF {
+            pos++
+            continue
+        }
+        kind := data[pos + 1]
+        switch {
+        case kind == 0x00 || kind == 0xFF || kind >= 0xD0 && kind <= 0xD7:
+            // Stuffing, fill and restarts, within a scan.
+            pos += 2
+            continue
+        case kind == 0xD9:
+            return append(markers, kind)
+        }
+        markers = append(markers, kind)
+        pos += 2 + int(binary.BigEndian.Uint16(data[pos + 2:]))
+    }
+    return markers
+}
+
+func TestProgressive(t *testing.T) {
+    for _, size := range [][2]int{{64, 48}, {37, 23}, {1, 1}} {
+        src := gradientImage(size[0], size[1])
+        th := New()
+        th.Format = "jpeg"
+        th.Quality = 90
+        th.Progressive = true
+        var buf bytes.Buffer
+        if err := th.Encode(&buf, src); err != nil {
+            t.Fatal(err)
+        }
+
+        markers := jpegMarkers(buf.Bytes())
+        if bytes.IndexByte(markers, 0xC2) < 0 || bytes.IndexByte(markers, 0xC0) >= 0 {
+            t.Errorf("%v: markers % X, want SOF2 and no SOF0", size, markers)
+        }
+        if scans := bytes.Count(markers, []byte{0xDA}); scans < 2 {
+            t.Errorf("%v: %d scans, want a DC one and AC ones after", size, scans)
+        }
+        if d := maxDiff(t, src, decodeData(t, buf.Bytes())); d > 8 {
+            t.Errorf("%v: off by up to %d at quality 90", size, d)
+        }
+
+        // Baseline without it.
+        th.Progressive = false
+        buf.Reset()
+        th.Encode(&buf, src)
+        if markers := jpegMarkers(buf.Bytes()); bytes.IndexByte(markers, 0xC0) < 0 || bytes.IndexByte(markers, 0xC2) >= 0 {
+            t.Errorf("%v: baseline markers % X", size, markers)
+        }
+    }
+}
@@ -0,0 +1,132 @@
+package thumbnail
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+)
+
+//=============================================================================
+
+// Exact dedup hashes every input whole. Inputs are read whole anyway to be
+// decoded, but some reads are only for the checksum: SkipExisting's, for
+// inputs it skips, and Remember's, for a whole earlier batch. On big files
+// that's most of a run's IO. With QuickHash, dedup first goes by a quick
+// key instead, hashing just a file's size and its first and last 64KB,
+// which is all those reads fetch (from a Storage that's a RangeReader).
+// Distinct images almost never agree on it; only for ones that do are both
+// read whole, and compared by their checksums, as dedup always has. Local
+// files and S3 objects can be read in part; other Storages are read whole.
+
+// How much of each end of a file goes into its quick key.
+const quickHashBytes = 64 << 10
+
+// A RangeReader is a Storage that can also read part of a file: n bytes
+// from offset, or fewer at its end.
+type RangeReader interface {
+    ReadRange(path string, offset int64, n int) ([]byte, error)
+}
+
+// fileKeys are what dedup knows a file by, each empty if it's unknown.
+type fileKeys struct {
+    checksum string // Of all of it, under Hash.
+    quick    string // With QuickHash, of its size and ends.
+}
+
+// quickKey is the quick key of a file of size bytes, starting with head
+// and ending with tail.
+func (t *Thumbnailer) quickKey(size int64, head, tail []byte) string {
+    h := HASHES[t.Hash]()
+    h.Write(binary.BigEndian.AppendUint64(nil, uint64(size)))
+    h.Write(head)
+    h.Write(tail)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// dedupeKeys are path's keys for a caller that only needs dedup's view of
+// it: with QuickHash, just the quick key, found without reading it whole
+// if it can be.
+func (t *Thumbnailer) dedupeKeys(path string) (fileKeys, error) {
+    ranges, ok := t.storage().(RangeReader)
+    if !t.QuickHash || !ok {
+        _, keys, err := t.readFile(path)
+        return keys, err
+    }
+
+    var size int64
+    err := t.retry("read", path, func() (err error) {
+        size, err = t.storage().Size(path)
+        return err
+    })
+    if err != nil {
+        return fileKeys{}, err
+    }
+    // The ends would overlap; it costs no more to read it all.
+    if size <= 2 * quickHashBytes {
+        _, keys, err := t.readFile(path)
+        return keys, err
+    }
+
+    var head, tail []byte
+    err = t.retry("read", path, func() (err error) {
+        if head, err = ranges.ReadRange(path, 0, quickHashBytes); err != nil {
+            return err
+        }
+        tail, err = ranges.ReadRange(path, size - quickHashBytes, quickHashBytes)
+        return err
+    })
+    if err != nil {
+        return fileKeys{}, err
+    }
+    return fileKeys{quick: t.quickKey(size, head, tail)}, nil
+}
+
+// isQuickDupe is isDupe under QuickHash, with t.dedupe locked. Whichever
+// checksums a quick key match needs are read here, under the lock, which
+// holds up the other workers; it's only for what are nearly always true
+// duplicates.
+func (t *Thumbnailer) isQuickDupe(keys fileKeys, path string) (string, bool) {
+    d := &t.dedupe
+    if d.checksums == nil {
+        d.checksums = make(map[string]string)
+        d.quick = make(map[string][]string)
+        d.sums = make(map[string]string)
+    }
+
+    // Checksums, when they're known, so keys from a manifest match too.
+    if keys.checksum != "" {
+        if original, found := d.checksums[keys.checksum]; found {
+            return original, true
+        }
+    }
+
+    if earlier := d.quick[keys.quick]; keys.quick != "" && len(earlier) > 0 {
+        if keys.checksum == "" {
+            if checksum, err := t.Checksum(path); err == nil {
+                keys.checksum = checksum
+            }
+        }
+        for _, p := range earlier {
+            if _, found := d.sums[p]; found {
+                continue
+            }
+            if checksum, err := t.Checksum(p); err == nil {
+                d.sums[p] = checksum
+                if _, found := d.checksums[checksum]; !found {
+                    d.checksums[checksum] = p
+                }
+            }
+        }
+        if original, found := d.checksums[keys.checksum]; found && keys.checksum != "" {
+            return original, true
+        }
+    }
+
+    if keys.quick != "" {
+        d.quick[keys.quick] = append(d.quick[keys.quick], path)
+    }
+    if keys.checksum != "" {
+        d.sums[path] = keys.checksum
+        d.checksums[keys.checksum] = path
+    }
+    return "", false
+}
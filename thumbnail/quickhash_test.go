@@ -0,0 +1,94 @@
+package thumbnail
+
+import (
+    "fmt"
+    "math/rand"
+    "testing"
+)
+
+//=============================================================================
+
+// countingStorage is a memStorage that's a RangeReader, and counts how
+// often files are read whole and in part.
+type countingStorage struct {
+    *memStorage
+    full   int
+    ranged int
+}
+
+func (s *countingStorage) ReadFile(path string) ([]byte, error) {
+    s.full++
+    return s.memStorage.ReadFile(path)
+}
+
+func (s *countingStorage) ReadRange(path string, offset int64, n int) ([]byte, error) {
+    s.ranged++
+    data, err := s.memStorage.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return data[offset:min(int(offset) + n, len(data))], nil
+}
+
+// Remembering distinct big files reads none of them whole with QuickHash;
+// a match on the quick key still reads both to be sure.
+func TestQuickHash(t *testing.T) {
+    storage := &countingStorage{memStorage: newMemStorage()}
+    var files [][]byte
+    for i := 0; i < 4; i++ {
+        data := make([]byte, 3 * quickHashBytes)
+        rand.New(rand.NewSource(int64(i))).Read(data)
+        files = append(files, data)
+        storage.WriteFile(fmt.Sprintf("in/%d", i), data)
+    }
+
+    for _, quick := range []bool{false, true} {
+        storage.full, storage.ranged = 0, 0
+        th := New()
+        th.Storage = storage
+        th.QuickHash = quick
+        for i := range files {
+            if err := th.Remember(fmt.Sprintf("in/%d", i)); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if want := map[bool]int{false: 4, true: 0}[quick]; storage.full != want {
+            t.Errorf("QuickHash %v: %d full reads, want %d", quick, storage.full, want)
+        }
+        if !quick {
+            continue
+        }
+        if storage.ranged != 8 {
+            t.Errorf("%d ranged reads, want both ends of each", storage.ranged)
+        }
+
+        // Exactly in/0, and in/1 but for its middle, which the quick key
+        // doesn't see.
+        storage.WriteFile("in/copy", files[0])
+        near := append([]byte(nil), files[1]...)
+        near[len(near) / 2]++
+        storage.WriteFile("in/near", near)
+        tests := []struct {
+            path     string
+            original string
+            dupe     bool
+        }{
+            {"in/copy", "in/0", true},
+            {"in/near", "", false},
+        }
+        for _, test := range tests {
+            storage.full = 0
+            keys, err := th.dedupeKeys(test.path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            original, dupe := th.isDupe(keys, nil, test.path)
+            if original != test.original || dupe != test.dupe {
+                t.Errorf("%s: got %q, %v, want %q, %v", test.path, original, dupe, test.original, test.dupe)
+            }
+            if storage.full != 2 {
+                t.Errorf("%s: %d full reads, want it and its match", test.path, storage.full)
+            }
+        }
+    }
+}
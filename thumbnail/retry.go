@@ -0,0 +1,61 @@
+package thumbnail
+
+import (
+    "errors"
+    "io/fs"
+    "net"
+    "os"
+    "syscall"
+    "time"
+)
+
+//=============================================================================
+
+// With Retries, reads of inputs and writes of thumbnails that fail in a
+// way that might not happen again (a network filesystem blip, a 503 from
+// S3) are tried again, waiting RetryDelay, then twice that, and so on.
+// Anything else fails at once: a missing file or a bad decode is the same
+// on the tenth try.
+
+// ErrTransient is for Storage implementations to wrap errors with when
+// they know a retry may succeed, like an HTTP 5xx.
+var ErrTransient = errors.New("transient failure")
+
+func retryable(err error) bool {
+    if errors.Is(err, ErrTransient) || errors.Is(err, os.ErrDeadlineExceeded) {
+        return true
+    }
+    if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+        return false
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+
+    var errno syscall.Errno
+    if errors.As(err, &errno) {
+        switch errno {
+        case syscall.EAGAIN, syscall.EINTR, syscall.EIO, syscall.EBUSY, syscall.ETIMEDOUT,
+            syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ESTALE:
+            return true
+        }
+    }
+    return false
+}
+
+// retry runs op until it succeeds, fails for good, or runs out of Retries.
+func (t *Thumbnailer) retry(what, path string, op func() error) error {
+    delay := t.RetryDelay
+    for attempt := 0; ; attempt++ {
+        err := op()
+        if err == nil || attempt >= t.Retries || !retryable(err) {
+            return err
+        }
+
+        t.warnf("Retrying %s %s in %v: %v", what, path, delay, err)
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
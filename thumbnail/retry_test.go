@@ -0,0 +1,84 @@
+package thumbnail
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "syscall"
+    "testing"
+)
+
+//=============================================================================
+
+// flakyStorage is a memStorage whose reads and writes fail the first
+// failures times each, with err.
+type flakyStorage struct {
+    *memStorage
+    err      error
+    failures int
+    reads    int
+    writes   int
+}
+
+func (s *flakyStorage) ReadFile(path string) ([]byte, error) {
+    s.reads++
+    if s.reads <= s.failures {
+        return nil, s.err
+    }
+    return s.memStorage.ReadFile(path)
+}
+
+func (s *flakyStorage) WriteFile(path string, data []byte) error {
+    s.writes++
+    if s.writes <= s.failures {
+        return s.err
+    }
+    return s.memStorage.WriteFile(path, data)
+}
+
+func TestRetry(t *testing.T) {
+    transient := fmt.Errorf("503 from upstream: %w", ErrTransient)
+    tests := []struct {
+        name     string
+        err      error
+        failures int
+        retries  int
+        ok       bool
+        tries    int
+    }{
+        {"recovers", transient, 2, 3, true, 3},
+        {"runs out", transient, 5, 2, false, 3},
+        {"no retries", transient, 1, 0, false, 1},
+        {"errno", syscall.EIO, 1, 1, true, 2},
+        {"for good", os.ErrNotExist, 1, 3, false, 1},
+    }
+
+    for _, test := range tests {
+        mem := newMemStorage()
+        mem.WriteFile("in/a.png", pngData(t, gradientImage(32, 32)))
+        storage := &flakyStorage{memStorage: mem, err: test.err, failures: test.failures}
+
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        th.Retries = test.retries
+        th.RetryDelay = 0
+        var warnings int
+        th.Warn = func(string, ...interface{}) { warnings++ }
+
+        err := th.ProcessFile("in/a.png", "out")
+        if (err == nil) != test.ok {
+            t.Errorf("%s: got %v", test.name, err)
+        }
+        if !test.ok && !errors.Is(err, test.err) {
+            t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+        }
+        if storage.reads != test.tries || warnings < test.tries - 1 {
+            t.Errorf("%s: %d reads, %d warnings, want %d reads", test.name, storage.reads, warnings, test.tries)
+        }
+        // The first write fails as the read did, until a retry gets it
+        // through; the other five go straight out.
+        if test.ok && storage.writes != test.failures + 6 {
+            t.Errorf("%s: %d writes, want %d", test.name, storage.writes, test.failures + 6)
+        }
+    }
+}
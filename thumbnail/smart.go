@@ -0,0 +1,77 @@
+package thumbnail
+
+import (
+    "image"
+    "image/draw"
+)
+
+//=============================================================================
+
+// Content-aware anchors pick their crop window per image rather than at a
+// fixed position. They live in Anchors like the static ones (ParseAnchors
+// files them under CenterAnchor, which is also their fallback) and are
+// only run when asked for, since they're far more expensive.
+var SMART_ANCHORS = map[string]func(src image.Image, w, h int) image.Rectangle{
+    "smart": energyCrop,
+    "smart-face": faceCrop,
+}
+
+// energyCrop returns the w x h window of src holding the most detail,
+// measured as the sum of luminance gradients. Ties (say, a flat image) go
+// to the centered window.
+func energyCrop(src image.Image, w, h int) image.Rectangle {
+    b := src.Bounds()
+    W, H := b.Dx(), b.Dy()
+    if w >= W && h >= H {
+        return b
+    }
+
+    gray := image.NewGray(image.Rect(0, 0, W, H))
+    draw.Draw(gray, gray.Bounds(), src, b.Min, draw.Src)
+
+    // A summed-area table makes every candidate window O(1) to score.
+    stride := W + 1
+    integral := make([]float64, stride * (H + 1))
+    for y := 0; y < H; y++ {
+        for x := 0; x < W; x++ {
+            i := y * gray.Stride + x
+            var e float64
+            if x + 1 < W {
+                e += absDiff(gray.Pix[i], gray.Pix[i + 1])
+            }
+            if y + 1 < H {
+                e += absDiff(gray.Pix[i], gray.Pix[i + gray.Stride])
+            }
+            integral[(y + 1) * stride + x + 1] = e +
+                integral[y * stride + x + 1] +
+                integral[(y + 1) * stride + x] -
+                integral[y * stride + x]
+        }
+    }
+
+    score := func(x, y int) float64 {
+        return integral[(y + h) * stride + x + w] -
+            integral[y * stride + x + w] -
+            integral[(y + h) * stride + x] +
+            integral[y * stride + x]
+    }
+
+    bestX, bestY := (W - w) / 2, (H - h) / 2
+    best := score(bestX, bestY)
+    for y := 0; y <= H - h; y++ {
+        for x := 0; x <= W - w; x++ {
+            if s := score(x, y); s > best {
+                best, bestX, bestY = s, x, y
+            }
+        }
+    }
+
+    return image.Rect(bestX, bestY, bestX + w, bestY + h).Add(b.Min)
+}
+
+func absDiff(a, b uint8) float64 {
+    if a > b {
+        return float64(a - b)
+    }
+    return float64(b - a)
+}
@@ -0,0 +1,71 @@
+package thumbnail
+
+import (
+    "context"
+    "image"
+)
+
+//=============================================================================
+
+// With Sprite, each input's thumbnails (every anchor, flipped or not) are
+// packed into one image, a row of them or a column, named like a variant
+// called sprite: photo_sprite.png. They go in variants order, anchors
+// sorted and each unflipped then flipped, so the layout is the same for
+// every input of a run. Each variant still gets its own Output, all with
+// the sprite's path, and a Tile saying where in it the variant is.
+
+const spriteKey = "sprite"
+
+// Sprite layouts.
+var SPRITE_LAYOUTS = map[string]bool{
+    "horizontal": true,
+    "vertical": true,
+}
+
+// writeSprite is the tail of ProcessAs under Sprite.
+func (t *Thumbnailer) writeSprite(ctx context.Context, result *Result, thumbs map[string]image.Image, path, format string, exif []byte) (*Result, error) {
+    variants := t.variants()
+    images := make([]image.Image, len(variants))
+    for i, v := range variants {
+        images[i] = thumbs[v.Key()]
+    }
+
+    cols := len(images)
+    if t.Sprite == "vertical" {
+        cols = 1
+    }
+    sprite, tiles := t.montage(images, cols)
+
+    data, err := t.encodeThumb(sprite, format, exif, result.Checksum)
+    if err == nil {
+        err = abandoned(ctx, result.Input)
+    }
+    written := false
+    if err == nil && !t.Deferred {
+        t.logf("Saving %s", path)
+        err = t.writeThumb(path, data)
+        data = nil
+        written = err == nil
+        // Taken back if it outlasted ctx, as ProcessAs does.
+        if err == nil {
+            if err = abandoned(ctx, result.Input); err != nil {
+                t.storage().Remove(path)
+            }
+        }
+    }
+    if err != nil {
+        return result, err
+    }
+
+    for i, v := range variants {
+        output := Output{Variant: v, Path: path, Tile: tiles[i], Color: t.dominantHex(images[i])}
+        // Just the once; Commit skips outputs without, and removeOutputs
+        // the ones not written.
+        if i == 0 {
+            output.Data = data
+            output.written = written
+        }
+        result.Outputs = append(result.Outputs, output)
+    }
+    return result, nil
+}
@@ -0,0 +1,51 @@
+package thumbnail
+
+import (
+    "bytes"
+    "context"
+    "testing"
+)
+
+//=============================================================================
+
+// Discarding an input only takes back what it wrote: nothing while its
+// outputs are kept back under Deferred, so a sprite from an earlier run
+// stays; the sprite, once Commit has written it.
+func TestDiscardDeferredSprite(t *testing.T) {
+    for _, sprite := range []string{"", "horizontal"} {
+        storage := newMemStorage()
+        storage.WriteFile("in/a.png", pngData(t, gradientImage(64, 48)))
+        earlier := []byte("an earlier run's")
+        names := []string{"out/a_center.png", "out/a_sprite.png"}
+        for _, name := range names {
+            storage.WriteFile(name, earlier)
+        }
+
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        th.Sprite = sprite
+        th.Deferred = true
+        result, err := th.ProcessAs(context.Background(), "in/a.png", "out", "a")
+        if err != nil {
+            t.Fatal(err)
+        }
+        th.Discard(result)
+        for _, name := range names {
+            if got, _ := storage.ReadFile(name); !bytes.Equal(got, earlier) {
+                t.Errorf("Sprite %q: discarding deferred outputs took %s", sprite, name)
+            }
+        }
+
+        result, err = th.ProcessAs(context.Background(), "in/a.png", "out", "a")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := th.Commit(result); err != nil {
+            t.Fatal(err)
+        }
+        th.Discard(result)
+        if got := outputsUnder(storage, "out"); len(got) != 1 {
+            t.Errorf("Sprite %q: left %v after discarding committed outputs", sprite, got)
+        }
+    }
+}
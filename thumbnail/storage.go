@@ -0,0 +1,130 @@
+package thumbnail
+
+import (
+    "errors"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+//=============================================================================
+
+// Storage is everything Process needs from wherever inputs and thumbnails
+// live. The zero Thumbnailer uses LocalStorage; other backends (the CLI's
+// S3 support, say) plug in here.
+type Storage interface {
+    ReadFile(path string) ([]byte, error)
+    WriteFile(path string, data []byte) error
+    Size(path string) (int64, error)
+    Remove(path string) error
+    MkdirAll(dir string) error
+}
+
+type LocalStorage struct{}
+
+func (LocalStorage) ReadFile(path string) ([]byte, error) {
+    return os.ReadFile(path)
+}
+
+// WriteFile writes into a temp file beside path and renames it into
+// place, so whatever is at path is always complete: a crash mid-write
+// leaves at most a stray .tmp, never a truncated file that SkipExisting
+// would take for done.
+func (LocalStorage) WriteFile(path string, data []byte) error {
+    fp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) + ".*.tmp")
+    if err != nil {
+        return err
+    }
+
+    // CreateTemp's 0600 is stricter than a thumbnail's.
+    if err = fp.Chmod(0644); err == nil {
+        _, err = fp.Write(data)
+    }
+    if closeErr := fp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(fp.Name(), path)
+    }
+    if err != nil {
+        os.Remove(fp.Name())
+    }
+    return err
+}
+
+func (LocalStorage) Size(path string) (int64, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return 0, err
+    }
+    return info.Size(), nil
+}
+
+func (LocalStorage) ReadRange(path string, offset int64, n int) ([]byte, error) {
+    fp, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer fp.Close()
+
+    data := make([]byte, n)
+    read, err := fp.ReadAt(data, offset)
+    if errors.Is(err, io.EOF) {
+        err = nil
+    }
+    return data[:read], err
+}
+
+func (LocalStorage) Remove(path string) error {
+    return os.Remove(path)
+}
+
+func (LocalStorage) MkdirAll(dir string) error {
+    return os.MkdirAll(dir, os.ModePerm)
+}
+
+func (t *Thumbnailer) storage() Storage {
+    if t.Storage == nil {
+        return LocalStorage{}
+    }
+    return t.Storage
+}
+
+//=============================================================================
+
+// Paths with a scheme (s3://bucket/key) are slash-separated on every OS,
+// and filepath would collapse their "//", so they get path instead.
+
+func splitScheme(p string) (scheme, rest string) {
+    if i := strings.Index(p, "://"); i > 0 {
+        return p[:i + 3], p[i + 3:]
+    }
+    return "", p
+}
+
+// JoinPath is filepath.Join for paths that may be URIs.
+func JoinPath(elem ...string) string {
+    if len(elem) == 0 {
+        return ""
+    }
+    scheme, rest := splitScheme(elem[0])
+    if scheme == "" {
+        return filepath.Join(elem...)
+    }
+    parts := []string{rest}
+    for _, e := range elem[1:] {
+        parts = append(parts, filepath.ToSlash(e))
+    }
+    return scheme + path.Join(parts...)
+}
+
+// DirPath is filepath.Dir for paths that may be URIs.
+func DirPath(p string) string {
+    scheme, rest := splitScheme(p)
+    if scheme == "" {
+        return filepath.Dir(p)
+    }
+    return scheme + path.Dir(rest)
+}
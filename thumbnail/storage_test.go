@@ -0,0 +1,68 @@
+package thumbnail
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+//=============================================================================
+
+// leftovers lists what's in dir besides keep.
+func leftovers(t *testing.T, dir, keep string) []string {
+    t.Helper()
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, e := range entries {
+        if e.Name() != keep {
+            got = append(got, e.Name())
+        }
+    }
+    return got
+}
+
+func TestLocalWriteFile(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "a.png")
+    for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+        if err := (LocalStorage{}).WriteFile(path, data); err != nil {
+            t.Fatal(err)
+        }
+        if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+            t.Errorf("Got %q, want %q", got, data)
+        }
+    }
+
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.Mode().Perm() != 0644 {
+        t.Errorf("Mode %v, want 0644", info.Mode().Perm())
+    }
+    if got := leftovers(t, dir, "a.png"); len(got) != 0 {
+        t.Errorf("Left %v", got)
+    }
+}
+
+// A write that fails after the data is out, here because a directory is
+// in the way of the rename, leaves nothing at the path or beside it.
+func TestLocalWriteFileFails(t *testing.T) {
+    dir := t.TempDir()
+    writeFile(t, dir, "a.png/in the way", nil)
+    if err := (LocalStorage{}).WriteFile(filepath.Join(dir, "a.png"), []byte("lost")); err == nil {
+        t.Fatal("Renamed over a directory")
+    }
+    if got := leftovers(t, dir, "a.png"); len(got) != 0 {
+        t.Errorf("Left %v", got)
+    }
+
+    // A directory that isn't there fails up front.
+    if err := (LocalStorage{}).WriteFile(filepath.Join(dir, "missing", "a.png"), nil); err == nil {
+        t.Error("Wrote into a missing directory")
+    }
+}
@@ -0,0 +1,778 @@
+// Package thumbnail turns images into fixed-size, anchor-cropped thumbnails.
+//
+// The thumbnailer CLI is a thin wrapper over this package; anything it can
+// do is available to other pipelines through a Thumbnailer.
+package thumbnail
+
+import (
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "github.com/disintegration/gift"
+    "hash"
+    "hash/crc32"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+//=============================================================================
+
+type Dim [2]int
+
+func (p *Dim) String() string {
+    return fmt.Sprintf("%d,%d", p[0], p[1])
+}
+
+func (p *Dim) Set(raw string) error {
+    parts := strings.Split(raw, ",")
+    if len(parts) != 2 {
+        return errors.New("Dimensions argument expected string like `X,Y`")
+    }
+
+    for i, s := range parts {
+        v, err := strconv.ParseInt(s, 10, 32)
+        if err != nil {
+            return fmt.Errorf("%q not an integer in %q", s, raw)
+        }
+        p[i] = int(v)
+    }
+
+    return nil
+}
+
+// Default is the receptor field for VGG16.
+var DefaultDim = Dim{224, 224}
+
+// ParseHexColor accepts RRGGBB or RRGGBBAA, with or without a leading #.
+func ParseHexColor(raw string) (color.NRGBA, error) {
+    hex := strings.TrimPrefix(raw, "#")
+    if len(hex) != 6 && len(hex) != 8 {
+        return color.NRGBA{}, fmt.Errorf("Color %q expected like `#RRGGBB` or `#RRGGBBAA`", raw)
+    }
+
+    v, err := strconv.ParseUint(hex, 16, 32)
+    if err != nil {
+        return color.NRGBA{}, fmt.Errorf("Color %q is not hex", raw)
+    }
+    if len(hex) == 6 {
+        v = v << 8 | 0xFF
+    }
+
+    return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// ParseRect reads an `X,Y,W,H` rectangle the way Dim reads `X,Y`.
+func ParseRect(raw string) (image.Rectangle, error) {
+    parts := strings.Split(raw, ",")
+    if len(parts) != 4 {
+        return image.Rectangle{}, errors.New("Rectangle argument expected string like `X,Y,W,H`")
+    }
+
+    var v [4]int
+    for i, s := range parts {
+        n, err := strconv.ParseInt(s, 10, 32)
+        if err != nil {
+            return image.Rectangle{}, fmt.Errorf("%q not an integer in %q", s, raw)
+        }
+        v[i] = int(n)
+    }
+    if v[2] <= 0 || v[3] <= 0 {
+        return image.Rectangle{}, fmt.Errorf("Rectangle %q expected a positive width and height", raw)
+    }
+
+    return image.Rect(v[0], v[1], v[0] + v[2], v[1] + v[3]), nil
+}
+
+//=============================================================================
+
+var ANCHORINGS = map[string]gift.Anchor{
+    "left": gift.LeftAnchor,
+    "right": gift.RightAnchor,
+    "center": gift.CenterAnchor,
+    "top": gift.TopAnchor,
+    "bottom": gift.BottomAnchor,
+    "top-left": gift.TopLeftAnchor,
+    "top-right": gift.TopRightAnchor,
+    "bottom-left": gift.BottomLeftAnchor,
+    "bottom-right": gift.BottomRightAnchor,
+}
+
+// The anchors emitted before they were selectable.
+const DefaultAnchors = "left,right,center"
+
+// ParseAnchors turns a comma-separated list of ANCHORINGS or SMART_ANCHORS
+// keys into the map a Thumbnailer iterates over.
+func ParseAnchors(spec string) (map[string]gift.Anchor, error) {
+    anchors := make(map[string]gift.Anchor)
+
+    for _, name := range strings.Split(spec, ",") {
+        name = strings.TrimSpace(name)
+        anchor, found := ANCHORINGS[name]
+        if _, smart := SMART_ANCHORS[name]; smart {
+            anchor, found = gift.CenterAnchor, true
+        }
+        if !found {
+            return nil, fmt.Errorf("Unknown anchor %q, expected one of %s, %s",
+                name, optionList(ANCHORINGS), optionList(SMART_ANCHORS))
+        }
+        anchors[name] = anchor
+    }
+
+    return anchors, nil
+}
+
+var RESAMPLINGS = map[string]gift.Resampling{
+    "nearest": gift.NearestNeighborResampling,
+    "box": gift.BoxResampling,
+    "linear": gift.LinearResampling,
+    "cubic": gift.CubicResampling,
+    "lanczos": gift.LanczosResampling,
+}
+
+var MODES = map[string]bool{
+    "crop": true, // Fill the box, then crop at each anchor.
+    "fit": true,  // Fit inside the box and pad with PadColor (or Background).
+    "stretch": true, // Resize to exactly the box, distorting if need be.
+    "square": true, // Take the largest centered square, then resize to the box.
+}
+
+// Checksums of the raw input bytes, for dedup and provenance. CRC32 is
+// fast but collides often enough on big datasets to drop a few distinct
+// images as "duplicates"; SHA-256 is slower but won't.
+var HASHES = map[string]func() hash.Hash{
+    "crc32": func() hash.Hash { return crc32.NewIEEE() },
+    "sha256": sha256.New,
+}
+
+// Maps Format values to output file extensions.
+var FORMAT_EXTENSIONS = map[string]string{
+    "png": ".png",
+    "jpeg": ".jpg",
+    "webp": ".webp",
+}
+
+// zlib levels for PNGs. Output size only moves a few percent between them,
+// but encode time does, so speed suits big batches and best archives.
+var PNG_COMPRESSIONS = map[string]png.CompressionLevel{
+    "default": png.DefaultCompression,
+    "speed": png.BestSpeed,
+    "best": png.BestCompression,
+    "none": png.NoCompression,
+}
+
+//=============================================================================
+
+// A Thumbnailer holds the options for one run. Set the exported fields
+// before the first call; the other state (dedup checksums) is shared by
+// every call, so one Thumbnailer dedups across a whole dataset and is safe
+// for concurrent use.
+type Thumbnailer struct {
+    Dim               Dim
+    Anchors           map[string]gift.Anchor
+    Flip              bool            // Also emit a copy of each crop mirrored left to right.
+    FlipVertical      bool            // Also emit one mirrored top to bottom.
+    Single            bool            // Name the one output per input after it alone, without the variant key.
+    Sprite            string          // A key of SPRITE_LAYOUTS to pack each input's outputs into one image (see sprite.go); empty doesn't.
+    Format            string          // A key of FORMAT_EXTENSIONS.
+    MatchFormat       bool            // Write each source's own format where there's an encoder, else Format.
+    Quality           int             // JPEG quality, 1-100; WebP rounds colors off below 81.
+    Progressive       bool            // Write progressive JPEGs (see progressive.go).
+    PreserveMetadata  bool            // Copy a few EXIF tags (see metadata.go) into JPEG thumbnails.
+    EmbedChecksum     bool            // Put the source's checksum in a tEXt chunk of PNG thumbnails (see pngtext.go).
+    DPI               int             // Pixel density recorded in PNG and JPEG thumbnails (see density.go); 0 records none.
+    PNGCompression    string          // A key of PNG_COMPRESSIONS.
+    BitDepth          int             // 16 keeps 16-bit sources 16-bit through to PNG outputs (see depth.go); 8 or 0 doesn't.
+    Optimize          bool            // Losslessly shrink PNGs further, at some cost in CPU (see optimize.go).
+    Deduplicate       bool
+    DedupeMode        string          // crc32 or phash.
+    DedupeDistance    int             // Max phash Hamming distance counted as a dupe.
+    Hash              string          // A key of HASHES.
+    QuickHash         bool            // Dedup by a hash of each file's size and ends first, reading it all only on a match (see quickhash.go).
+    AutoOrient        bool            // Undo the EXIF Orientation of JPEGs on read.
+    ICCConvert        bool            // Convert JPEGs and PNGs with a non-sRGB ICC profile to sRGB on read (see icc.go).
+    Resample          string          // A key of RESAMPLINGS.
+    LinearResize      bool            // Resample in linear light rather than sRGB; slower, but truer.
+    Mode              string          // A key of MODES.
+    MaxSide           int             // If above 0, scale the longer side to this instead, ignoring Dim and Mode.
+    Scale             float64         // If above 0, resize to this fraction of the source instead, ignoring Dim and Mode.
+    Augment           int             // If above 0, make this many randomly turned and cropped thumbnails instead (see augment.go).
+    AugmentRotate     float64         // Most degrees either way Augment turns by, 0-45.
+    AugmentSeed       int64           // Seeds Augment, along with each input's checksum.
+    Background        color.Color     // Padding for fit mode, and what montages leave uncovered.
+    PadColor          color.Color     // Fit mode's padding instead, if non-nil; a translucent one stays so in PNGs.
+    AllowUpscale      bool            // Thumbnail inputs smaller than Dim instead of skipping.
+    MaxPixels         int64           // Refuse to decode sources with more pixels than this; 0 is no limit.
+    SkipExisting      bool            // Don't redo inputs whose outputs all exist.
+    DryRun            bool            // Go through the motions but write nothing.
+    GifFrame          string          // first, middle, last, or a frame index.
+    RejectAnimated    bool            // Skip inputs with more than one frame instead (see animated.go).
+    InMemory          bool            // Return thumbnails in Process's Result instead of writing them.
+    Deferred          bool            // Encode outputs into Process's Result, but leave writing them to Commit.
+    Sharpen           float64         // Unsharp mask amount after resizing; 0 is off.
+    Grayscale         bool            // Luminance-only thumbnails (8-bit gray PNGs).
+    Brightness        float64         // Percent, -100 to 100; 0 is unchanged.
+    Contrast          float64         // Percent, -100 to 100; 0 is unchanged.
+    Gamma             float64         // Above 0; 1 is unchanged, higher is lighter.
+    FlattenBackground color.Color     // What transparency becomes in JPEGs (or PNGs with Flatten).
+    Flatten           bool            // Flatten PNGs (and WebPs) too, instead of keeping their alpha.
+    Palette           int             // Colors in an indexed PNG, 2-256; 0 is full color.
+    BorderWidth       int             // Border in pixels, drawn inside Dim; 0 is none.
+    BorderColor       color.Color
+    Radius            int             // Corner radius in pixels; the corners become transparent.
+    Passthrough       bool            // Don't resample sources that are exactly Dim already.
+    Crop              image.Rectangle // Source region to keep before anything else; empty keeps it all.
+    AutoTrim          bool            // Crop away uniform margins (after Crop) before resizing.
+    TrimTolerance     int             // Per-channel difference from the corner still counted as margin, 0-255.
+    Watermark         image.Image     // Stamped on every thumbnail if non-nil.
+    WatermarkAnchor   string          // A key of ANCHORINGS.
+    WatermarkOpacity  float64         // 0-1.
+    WatermarkScale    float64         // Watermark width as a fraction of the thumbnail's, 0-1.
+    BlurHashX         int             // BlurHash components across, 1-9; 0 skips BlurHash.
+    BlurHashY         int             // And down.
+    DominantColors    bool            // Report each thumbnail's dominant color in its Output.
+    Storage           Storage         // Where paths are read and written; nil is LocalStorage.
+    Retries           int             // Extra tries for reads and writes that fail transiently.
+    RetryDelay        time.Duration   // Wait before the first retry, doubling after each.
+
+    // If non-nil, ProcessFile reports each file it saves here.
+    Log func(format string, v ...interface{})
+
+    // If non-nil, gets problems that don't stop an input, like a Crop that
+    // had to be clamped to fit it.
+    Warn func(format string, v ...interface{})
+
+    // If non-nil, where ProcessAs really puts an input's outputs, given the
+    // outputDir it was passed and the input's checksum; e.g. to shard them.
+    // ProcessAs then reads each input whole up front, SkipExisting's too,
+    // and decodes it from that same read.
+    OutputDir func(inputPath, outputDir, checksum string) string
+
+    dedupe dedupeState
+
+    // Bytes Optimize saved, for OptimizeSaved.
+    optimized atomic.Int64
+}
+
+// DefaultMaxPixels is 400MB as NRGBA: more than any camera makes, but a
+// long way short of what a small bomb of a PNG can ask for.
+const DefaultMaxPixels = 100_000_000
+
+// New returns a Thumbnailer with the same defaults as the CLI.
+func New() *Thumbnailer {
+    anchors, _ := ParseAnchors(DefaultAnchors)
+
+    return &Thumbnailer{
+        Dim: DefaultDim,
+        Anchors: anchors,
+        Flip: true,
+        Format: "png",
+        Quality: 90,
+        PNGCompression: "default",
+        Deduplicate: true,
+        DedupeMode: "crc32",
+        DedupeDistance: 10,
+        Hash: "crc32",
+        AutoOrient: true,
+        Resample: "lanczos",
+        Mode: "crop",
+        Background: color.Black,
+        FlattenBackground: color.White,
+        BorderColor: color.White,
+        TrimTolerance: 16,
+        MaxPixels: DefaultMaxPixels,
+        WatermarkAnchor: "bottom-right",
+        WatermarkOpacity: 0.5,
+        WatermarkScale: 0.25,
+        GifFrame: "first",
+        Gamma: 1,
+        AugmentRotate: 10,
+    }
+}
+
+// Validate reports the first option that can't be used.
+func (t *Thumbnailer) Validate() error {
+    if t.DedupeMode != "crc32" && t.DedupeMode != "phash" {
+        return fmt.Errorf("Unknown dedupe mode %q, expected crc32 or phash", t.DedupeMode)
+    }
+
+    if _, found := HASHES[t.Hash]; !found {
+        return fmt.Errorf("Unknown hash %q, expected one of %s", t.Hash, optionList(HASHES))
+    }
+    if t.QuickHash && t.DedupeMode == "phash" {
+        return errors.New("Quick hash needs dedupe mode crc32, phash reads every image whole")
+    }
+
+    if _, found := FORMAT_EXTENSIONS[t.Format]; !found {
+        return fmt.Errorf("Unknown format %q, expected png, jpeg or webp", t.Format)
+    }
+
+    if t.Quality < 1 || t.Quality > 100 {
+        return fmt.Errorf("Quality %d out of range, expected 1-100", t.Quality)
+    }
+
+    // With MatchFormat, PNG sources just keep getting PNGs.
+    if t.Progressive && t.Format != "jpeg" && !t.MatchFormat {
+        return fmt.Errorf("Progressive needs jpeg format, not %q", t.Format)
+    }
+
+    if t.BitDepth != 0 && t.BitDepth != 8 && t.BitDepth != 16 {
+        return fmt.Errorf("Bit depth %d out of range, expected 8 or 16", t.BitDepth)
+    }
+    if t.BitDepth == 16 && t.Format != "png" && !t.MatchFormat {
+        return fmt.Errorf("Bit depth 16 needs png format, not %q", t.Format)
+    }
+
+    if len(t.Anchors) == 0 {
+        return errors.New("No anchors selected")
+    }
+
+    if !MODES[t.Mode] {
+        return fmt.Errorf("Unknown mode %q, expected one of %s", t.Mode, optionList(MODES))
+    }
+
+    if t.Sprite != "" && !SPRITE_LAYOUTS[t.Sprite] {
+        return fmt.Errorf("Unknown sprite layout %q, expected one of %s", t.Sprite, optionList(SPRITE_LAYOUTS))
+    }
+
+    if n := len(t.variants()); t.Single && n != 1 {
+        return fmt.Errorf("Single needs one output per input, not %d (check the anchors and flip)", n)
+    }
+
+    if t.Sharpen < 0 {
+        return fmt.Errorf("Sharpen %g out of range, expected 0 or more", t.Sharpen)
+    }
+
+    if t.Brightness < -100 || t.Brightness > 100 {
+        return fmt.Errorf("Brightness %g out of range, expected -100 to 100", t.Brightness)
+    }
+
+    if t.Contrast < -100 || t.Contrast > 100 {
+        return fmt.Errorf("Contrast %g out of range, expected -100 to 100", t.Contrast)
+    }
+
+    if t.BorderWidth < 0 {
+        return fmt.Errorf("Border %d out of range, expected 0 or more", t.BorderWidth)
+    }
+
+    if t.Radius < 0 {
+        return fmt.Errorf("Radius %d out of range, expected 0 or more", t.Radius)
+    }
+
+    if t.Retries < 0 {
+        return fmt.Errorf("Retries %d out of range, expected 0 or more", t.Retries)
+    }
+
+    if t.DPI < 0 || t.DPI > maxDPI {
+        return fmt.Errorf("DPI %d out of range, expected 0-%d", t.DPI, maxDPI)
+    }
+
+    if t.MaxPixels < 0 {
+        return fmt.Errorf("Max pixels %d out of range, expected 0 or more", t.MaxPixels)
+    }
+
+    if t.TrimTolerance < 0 || t.TrimTolerance > 255 {
+        return fmt.Errorf("Trim tolerance %d out of range, expected 0-255", t.TrimTolerance)
+    }
+
+    if t.Watermark != nil {
+        if _, found := ANCHORINGS[t.WatermarkAnchor]; !found {
+            return fmt.Errorf("Unknown watermark anchor %q, expected one of %s", t.WatermarkAnchor, optionList(ANCHORINGS))
+        }
+        if t.WatermarkOpacity < 0 || t.WatermarkOpacity > 1 {
+            return fmt.Errorf("Watermark opacity %g out of range, expected 0-1", t.WatermarkOpacity)
+        }
+        if t.WatermarkScale <= 0 || t.WatermarkScale > 1 {
+            return fmt.Errorf("Watermark scale %g out of range, expected above 0, up to 1", t.WatermarkScale)
+        }
+    }
+
+    if t.Palette != 0 && (t.Palette < 2 || t.Palette > 256) {
+        return fmt.Errorf("Palette %d out of range, expected 2-256 (or 0 for none)", t.Palette)
+    }
+
+    if t.Gamma <= 0 {
+        return fmt.Errorf("Gamma %g out of range, expected above 0", t.Gamma)
+    }
+
+    if t.BlurHashX != 0 || t.BlurHashY != 0 {
+        if t.BlurHashX < 1 || t.BlurHashX > 9 || t.BlurHashY < 1 || t.BlurHashY > 9 {
+            return fmt.Errorf("BlurHash components %dx%d out of range, expected 1-9 each", t.BlurHashX, t.BlurHashY)
+        }
+    }
+
+    if t.MaxSide < 0 {
+        return fmt.Errorf("Max side %d out of range, expected 0 or more", t.MaxSide)
+    }
+
+    if t.Scale < 0 || t.Scale > 1 && !t.AllowUpscale {
+        return fmt.Errorf("Scale %g out of range, expected above 0, up to 1 (or more with upscaling allowed)", t.Scale)
+    }
+    if t.Scale > 0 && t.MaxSide > 0 {
+        return errors.New("Scale and max side can't both be set")
+    }
+
+    if t.Augment < 0 {
+        return fmt.Errorf("Augment %d out of range, expected 0 or more", t.Augment)
+    }
+    if t.Augment > 0 {
+        if t.AugmentRotate < 0 || t.AugmentRotate > 45 {
+            return fmt.Errorf("Augment rotation %g out of range, expected 0-45", t.AugmentRotate)
+        }
+        if t.MaxSide > 0 || t.Scale > 0 {
+            return errors.New("Augment can't be used with max side or scale")
+        }
+    }
+
+    if err := validGifFrame(t.GifFrame); err != nil {
+        return err
+    }
+
+    if _, found := PNG_COMPRESSIONS[t.PNGCompression]; !found {
+        return fmt.Errorf("Unknown PNG compression %q, expected one of %s", t.PNGCompression, optionList(PNG_COMPRESSIONS))
+    }
+
+    if _, found := RESAMPLINGS[t.Resample]; !found {
+        return fmt.Errorf("Unknown resampling %q, expected one of %s", t.Resample, optionList(RESAMPLINGS))
+    }
+
+    return nil
+}
+
+// optionList formats a map's keys for error messages.
+func optionList[V any](options map[string]V) string {
+    var keys []string
+    for k := range options {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return strings.Join(keys, ", ")
+}
+
+func (t *Thumbnailer) logf(format string, v ...interface{}) {
+    if t.Log != nil {
+        t.Log(format, v...)
+    }
+}
+
+func (t *Thumbnailer) warnf(format string, v ...interface{}) {
+    if t.Warn != nil {
+        t.Warn(format, v...)
+    }
+}
+
+// cropBounds is Crop placed on bounds, clamped to them.
+func (t *Thumbnailer) cropBounds(bounds image.Rectangle) image.Rectangle {
+    return t.Crop.Add(bounds.Min).Intersect(bounds)
+}
+
+//=============================================================================
+
+// calcResizeBounds returns the smallest size that preserves the source's
+// aspect ratio while covering t.Dim on both axes, so the crop that
+// follows never runs out of pixels.
+func (t *Thumbnailer) calcResizeBounds(src image.Image) (int, int) {
+    bounds := src.Bounds()
+
+    x, y := float64(bounds.Dx()), float64(bounds.Dy())
+    w, h := float64(t.Dim[0]), float64(t.Dim[1])
+
+    s := math.Max(w / x, h / y)
+
+    // Rounding can land a pixel short of the target on the tight axis.
+    rx := int(math.Max(math.Round(x * s), w))
+    ry := int(math.Max(math.Round(y * s), h))
+
+    return rx, ry
+}
+
+func (t *Thumbnailer) subImage(src image.Image) image.Image {
+    x, y := t.calcResizeBounds(src)
+
+    g := gift.New(t.resampling(src, gift.Resize(x, y, RESAMPLINGS[t.Resample])))
+    dst := t.canvas(src, g.Bounds(src.Bounds()))
+    g.Draw(dst, src)
+
+    return dst
+}
+
+// flipOps are the Flipped and Vertical halves of each name's variants:
+// as is, then mirrored left to right, then top to bottom.
+func (t *Thumbnailer) flipOps() []Variant {
+    ops := []Variant{{}}
+    if t.Flip {
+        ops = append(ops, Variant{Flipped: true})
+    }
+    if t.FlipVertical {
+        ops = append(ops, Variant{Flipped: true, Vertical: true})
+    }
+    return ops
+}
+
+// padColor is what fit mode pads with.
+func (t *Thumbnailer) padColor() color.Color {
+    if t.PadColor == nil {
+        return t.Background
+    }
+    return t.PadColor
+}
+
+// fitImage shrinks (or grows) src to fit inside t.Dim, then centers it on
+// a t.Dim canvas of t.padColor().
+func (t *Thumbnailer) fitImage(src image.Image) image.Image {
+    g := gift.New(t.resampling(src, gift.ResizeToFit(t.Dim[0], t.Dim[1], RESAMPLINGS[t.Resample])))
+    fitted := t.canvas(src, g.Bounds(src.Bounds()))
+    g.Draw(fitted, src)
+    defer release(fitted)
+
+    dst := t.canvas(src, image.Rect(0, 0, t.Dim[0], t.Dim[1]))
+    draw.Draw(dst, dst.Bounds(), image.NewUniform(t.padColor()), image.Point{}, draw.Src)
+
+    offset := image.Pt((t.Dim[0] - fitted.Bounds().Dx()) / 2, (t.Dim[1] - fitted.Bounds().Dy()) / 2)
+    draw.Draw(dst, fitted.Bounds().Add(offset), fitted, image.Point{}, draw.Over)
+
+    return dst
+}
+
+// A Variant identifies one of the thumbnails made from each input.
+type Variant struct {
+    Name     string // The anchor, or the mode when nothing is cropped.
+    Flipped  bool
+    Vertical bool // With Flipped, mirrored top to bottom rather than left to right.
+}
+
+// Key is the variant's key in Thumbnail's map and its output name suffix.
+// Left-right mirrors keep the plain _flipped they always had.
+func (v Variant) Key() string {
+    if v.Flipped && v.Vertical {
+        return v.Name + "_vflipped"
+    }
+    if v.Flipped {
+        return v.Name + "_flipped"
+    }
+    return v.Name
+}
+
+// variants lists what Thumbnail will return, without computing it, in a
+// stable order: anchors sorted, each unflipped then flipped (left to right,
+// then top to bottom).
+func (t *Thumbnailer) variants() []Variant {
+    if t.Augment > 0 {
+        return t.augmentVariants()
+    }
+
+    names := []string{t.Mode}
+    if t.MaxSide > 0 {
+        names = []string{"max"}
+    } else if t.Scale > 0 {
+        names = []string{"scaled"}
+    } else if t.Mode == "crop" {
+        names = names[:0]
+        for k := range t.Anchors {
+            names = append(names, k)
+        }
+        // Map order is random; logs and manifests shouldn't be.
+        sort.Strings(names)
+    }
+
+    var variants []Variant
+    for _, name := range names {
+        for _, op := range t.flipOps() {
+            variants = append(variants, Variant{name, op.Flipped, op.Vertical})
+        }
+    }
+    return variants
+}
+
+// Sharpening maps onto an unsharp mask with a fixed one-pixel radius, which
+// suits thumbnail-sized detail; Sharpen is the mask's amount.
+const sharpenSigma = 1.0
+
+// adjustments are the filters every thumbnail gets once it's at its final
+// size, in the order they're applied. Running them after the resize and
+// crop is much cheaper than on the source, and the tone adjustments are
+// per-pixel, so the only difference is subtle resampling of a curve.
+func (t *Thumbnailer) adjustments() []gift.Filter {
+    var filters []gift.Filter
+    if t.Brightness != 0 {
+        filters = append(filters, gift.Brightness(float32(t.Brightness)))
+    }
+    if t.Contrast != 0 {
+        filters = append(filters, gift.Contrast(float32(t.Contrast)))
+    }
+    if t.Gamma != 1 {
+        filters = append(filters, gift.Gamma(float32(t.Gamma)))
+    }
+    if t.Sharpen > 0 {
+        filters = append(filters, gift.UnsharpMask(sharpenSigma, float32(t.Sharpen), 0))
+    }
+    if t.Grayscale {
+        filters = append(filters, gift.Grayscale())
+    }
+    return filters
+}
+
+// addVariants applies filters then adjustments to src and stores the
+// result under name, along with flipped copies per Flip and FlipVertical.
+func (t *Thumbnailer) addVariants(thumbs map[string]image.Image, name string, src image.Image, filters ...gift.Filter) {
+    filters = append(filters[:len(filters):len(filters)], t.adjustments()...)
+    if d := t.decoration(); d != nil {
+        d.deep = t.deep(src)
+        filters = append(filters, d)
+    }
+
+    for _, op := range t.flipOps() {
+        outputName := Variant{name, op.Flipped, op.Vertical}.Key()
+
+        // Capped so the appends below never write into the caller's array.
+        filters := filters[:len(filters):len(filters)]
+        if op.Flipped && op.Vertical {
+            filters = append(filters, gift.FlipVertical())
+        } else if op.Flipped {
+            filters = append(filters, gift.FlipHorizontal())
+        }
+        if w := t.watermark(); w != nil {
+            filters = append(filters, w)
+        }
+        g := gift.New(filters...)
+        dst := t.canvas(src, g.Bounds(src.Bounds()))
+        g.Draw(dst, src)
+
+        thumbs[outputName] = dst
+    }
+}
+
+// squareSide is the side of the largest square that fits in size.
+func squareSide(size image.Point) int {
+    if size.X < size.Y {
+        return size.X
+    }
+    return size.Y
+}
+
+// longSide is size's longer side.
+func longSide(size image.Point) int {
+    if size.X > size.Y {
+        return size.X
+    }
+    return size.Y
+}
+
+// Undersized reports whether a source of the given size (after Crop) would
+// have to be upscaled, which ProcessFile skips unless AllowUpscale is set.
+func (t *Thumbnailer) Undersized(size image.Point) bool {
+    if t.MaxSide > 0 {
+        return longSide(size) < t.MaxSide
+    }
+    if t.Scale > 0 {
+        return false // Validate keeps upscales behind AllowUpscale.
+    }
+    if t.Mode == "square" {
+        side := squareSide(size)
+        size = image.Pt(side, side)
+    }
+    return size.X < t.Dim[0] || size.Y < t.Dim[1]
+}
+
+// Thumbnail returns one thumbnail per anchor (and flip), keyed by the name
+// ProcessFile appends to the output filename. Only crop mode uses Anchors;
+// the others make a single thumbnail (and flip) named after the mode. With
+// MaxSide, Dim and Mode are ignored too, and the one thumbnail is "max",
+// whatever size keeps the source's aspect ratio. Scale is the same, but
+// the thumbnail is "scaled". With Augment, they're aug0 onwards instead.
+func (t *Thumbnailer) Thumbnail(src image.Image) map[string]image.Image {
+    return t.thumbnail(src, "")
+}
+
+// thumbnail is Thumbnail, with Augment's randomness keyed on key.
+func (t *Thumbnailer) thumbnail(src image.Image, key string) map[string]image.Image {
+    thumbs := make(map[string]image.Image)
+
+    // Intermediate copies, none of them among thumbs.
+    var scratch []image.Image
+    defer func() {
+        for _, img := range scratch {
+            release(img)
+        }
+    }()
+
+    // Crop comes first, so anchors, fit and stretch only ever see the
+    // region; an anchor then picks its window within it.
+    if !t.Crop.Empty() {
+        g := gift.New(gift.Crop(t.cropBounds(src.Bounds())))
+        dst := t.canvas(src, g.Bounds(src.Bounds()))
+        g.Draw(dst, src)
+        src = dst
+        scratch = append(scratch, dst)
+    }
+
+    if t.AutoTrim {
+        if r := trimBounds(src, t.TrimTolerance); r != src.Bounds() {
+            g := gift.New(gift.Crop(r))
+            dst := t.canvas(src, g.Bounds(src.Bounds()))
+            g.Draw(dst, src)
+            src = dst
+            scratch = append(scratch, dst)
+        }
+    }
+
+    if t.Augment > 0 {
+        t.addAugmented(thumbs, src, key)
+        return thumbs
+    }
+
+    if t.MaxSide > 0 {
+        // Resize fills in the zero side so the aspect ratio holds.
+        resize := gift.Resize(t.MaxSide, 0, RESAMPLINGS[t.Resample])
+        if size := src.Bounds().Size(); size.Y > size.X {
+            resize = gift.Resize(0, t.MaxSide, RESAMPLINGS[t.Resample])
+        }
+        t.addVariants(thumbs, "max", src, t.resampling(src, resize))
+        return thumbs
+    }
+
+    if t.Scale > 0 {
+        size := src.Bounds().Size()
+        w := int(math.Max(1, math.Round(float64(size.X) * t.Scale)))
+        h := int(math.Max(1, math.Round(float64(size.Y) * t.Scale)))
+        t.addVariants(thumbs, "scaled", src, t.resampling(src, gift.Resize(w, h, RESAMPLINGS[t.Resample])))
+        return thumbs
+    }
+
+    switch t.Mode {
+    case "fit":
+        fitted := t.fitImage(src)
+        scratch = append(scratch, fitted)
+        t.addVariants(thumbs, "fit", fitted)
+        return thumbs
+    case "stretch":
+        t.addVariants(thumbs, "stretch", src, t.resampling(src, gift.Resize(t.Dim[0], t.Dim[1], RESAMPLINGS[t.Resample])))
+        return thumbs
+    case "square":
+        side := squareSide(src.Bounds().Size())
+        t.addVariants(thumbs, "square", src,
+            gift.CropToSize(side, side, gift.CenterAnchor),
+            t.resampling(src, gift.Resize(t.Dim[0], t.Dim[1], RESAMPLINGS[t.Resample])))
+        return thumbs
+    }
+
+    src = t.subImage(src)
+    scratch = append(scratch, src)
+
+    for k, anchor := range t.Anchors {
+        if pick, smart := SMART_ANCHORS[k]; smart {
+            t.addVariants(thumbs, k, src, gift.Crop(pick(src, t.Dim[0], t.Dim[1])))
+            continue
+        }
+        t.addVariants(thumbs, k, src, gift.CropToSize(t.Dim[0], t.Dim[1], anchor))
+    }
+
+    return thumbs
+}
@@ -0,0 +1,734 @@
+package thumbnail
+
+import (
+    "bytes"
+    "flag"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "image/png"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "sync"
+    "testing"
+)
+
+//=============================================================================
+
+// Tests build their inputs in memory, and write to t.TempDir() or a
+// memStorage. Resize and crop outputs are also compared with golden PNGs
+// in testdata, which `go test -update` rewrites after a deliberate change.
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// Per-channel difference from a golden image still counted as a match, for
+// resampling that rounds differently across Go versions.
+const goldenTolerance = 2
+
+// gradientImage is w x h with red rising left to right and green top to
+// bottom, so every crop and flip of it looks different.
+func gradientImage(w, h int) *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / max(w - 1, 1)), uint8(y * 255 / max(h - 1, 1)), 128, 255})
+        }
+    }
+    return img
+}
+
+func solidImage(w, h int, c color.Color) *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            img.Set(x, y, c)
+        }
+    }
+    return img
+}
+
+func pngData(t testing.TB, img image.Image) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    if err := png.Encode(&b, img); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+func jpegData(t testing.TB, img image.Image, quality int) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+// writeFile writes data to name under dir and returns its path.
+func writeFile(t testing.TB, dir, name string, data []byte) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func decodeFile(t testing.TB, path string) image.Image {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return decodeData(t, data)
+}
+
+// decodeData decodes an encoded image in any of the registered formats.
+func decodeData(t testing.TB, data []byte) image.Image {
+    t.Helper()
+    img, _, err := image.Decode(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return img
+}
+
+// maxDiff is the largest difference in any channel of any pixel of a and
+// b, which have to be the same size, at 8 bits.
+func maxDiff(t testing.TB, a, b image.Image) int {
+    t.Helper()
+    if a.Bounds().Size() != b.Bounds().Size() {
+        t.Fatalf("Sizes differ: %v and %v", a.Bounds().Size(), b.Bounds().Size())
+    }
+    worst := 0
+    da := a.Bounds().Min.Sub(b.Bounds().Min)
+    for y := b.Bounds().Min.Y; y < b.Bounds().Max.Y; y++ {
+        for x := b.Bounds().Min.X; x < b.Bounds().Max.X; x++ {
+            ca := color.NRGBAModel.Convert(a.At(x + da.X, y + da.Y)).(color.NRGBA)
+            cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+            for _, d := range []int{int(ca.R) - int(cb.R), int(ca.G) - int(cb.G), int(ca.B) - int(cb.B), int(ca.A) - int(cb.A)} {
+                worst = max(worst, d, -d)
+            }
+        }
+    }
+    return worst
+}
+
+// checkGolden compares img with testdata/name.png, or rewrites that with
+// -update.
+func checkGolden(t *testing.T, name string, img image.Image) {
+    t.Helper()
+    path := filepath.Join("testdata", name + ".png")
+    if *update {
+        writeFile(t, ".", path, pngData(t, img))
+        return
+    }
+    if d := maxDiff(t, decodeFile(t, path), img); d > goldenTolerance {
+        t.Errorf("%s: off by up to %d from %s", name, d, path)
+    }
+}
+
+// memStorage is a Storage in memory, for tests that needn't touch disk.
+type memStorage struct {
+    mutex  sync.Mutex
+    files  map[string][]byte
+    writes []string // Every path written, in order.
+}
+
+func newMemStorage() *memStorage {
+    return &memStorage{files: make(map[string][]byte)}
+}
+
+func (s *memStorage) ReadFile(path string) ([]byte, error) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    data, found := s.files[path]
+    if !found {
+        return nil, &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+    }
+    return data, nil
+}
+
+func (s *memStorage) WriteFile(path string, data []byte) error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.files[path] = append([]byte(nil), data...)
+    s.writes = append(s.writes, path)
+    return nil
+}
+
+func (s *memStorage) Size(path string) (int64, error) {
+    data, err := s.ReadFile(path)
+    return int64(len(data)), err
+}
+
+func (s *memStorage) Remove(path string) error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    delete(s.files, path)
+    return nil
+}
+
+func (s *memStorage) MkdirAll(dir string) error {
+    return nil
+}
+
+// paths lists what's stored, sorted.
+func (s *memStorage) paths() []string {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    var paths []string
+    for p := range s.files {
+        paths = append(paths, p)
+    }
+    sort.Strings(paths)
+    return paths
+}
+
+// testThumbnailer is New with a small Dim, so goldens stay tiny.
+func testThumbnailer(w, h int) *Thumbnailer {
+    th := New()
+    th.Dim = Dim{w, h}
+    return th
+}
+
+//=============================================================================
+
+func TestCalcResizeBounds(t *testing.T) {
+    tests := []struct {
+        dim  Dim
+        src  image.Point
+        want image.Point
+    }{
+        {Dim{224, 224}, image.Pt(224, 224), image.Pt(224, 224)},
+        {Dim{224, 224}, image.Pt(640, 480), image.Pt(299, 224)},
+        {Dim{224, 224}, image.Pt(480, 640), image.Pt(224, 299)},
+        {Dim{100, 50}, image.Pt(300, 300), image.Pt(100, 100)},
+        {Dim{50, 100}, image.Pt(300, 300), image.Pt(100, 100)},
+        // Rounding would land a pixel short of Dim; it's held at Dim.
+        {Dim{3, 3}, image.Pt(10, 7), image.Pt(4, 3)},
+        {Dim{7, 7}, image.Pt(1000, 999), image.Pt(7, 7)},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(test.dim[0], test.dim[1])
+        x, y := th.calcResizeBounds(image.Rectangle{Max: test.src})
+        if got := image.Pt(x, y); got != test.want {
+            t.Errorf("Dim %v, source %v: got %v, want %v", test.dim, test.src, got, test.want)
+        }
+    }
+}
+
+// Every shape of source, bigger and smaller than Dim, comes out covering
+// Dim exactly on its tight axis, with its aspect ratio kept to a pixel.
+func TestCalcResizeBoundsShapes(t *testing.T) {
+    sources := map[string][]image.Point{
+        "portrait": {image.Pt(600, 900), image.Pt(100, 150)},
+        "landscape": {image.Pt(900, 600), image.Pt(150, 100)},
+        "square": {image.Pt(800, 800), image.Pt(120, 120)},
+    }
+    dims := []Dim{{224, 224}, {300, 200}, {200, 300}}
+
+    for shape, sizes := range sources {
+        for _, size := range sizes {
+            for _, dim := range dims {
+                th := testThumbnailer(dim[0], dim[1])
+                x, y := th.calcResizeBounds(image.Rectangle{Max: size})
+                if x < dim[0] || y < dim[1] {
+                    t.Errorf("%s %v into %v: %dx%d doesn't cover it", shape, size, dim, x, y)
+                }
+                if x != dim[0] && y != dim[1] {
+                    t.Errorf("%s %v into %v: %dx%d is bigger than it needs to be", shape, size, dim, x, y)
+                }
+                // Within a pixel of the source's aspect ratio, on the long axis.
+                if dx := float64(y) * float64(size.X) / float64(size.Y) - float64(x); dx > 1 || dx < -1 {
+                    t.Errorf("%s %v into %v: %dx%d is off its aspect ratio by %.1f pixels", shape, size, dim, x, y, dx)
+                }
+            }
+        }
+    }
+}
+
+func TestSubImage(t *testing.T) {
+    th := testThumbnailer(16, 16)
+    src := gradientImage(48, 32)
+
+    dst := th.subImage(src)
+    x, y := th.calcResizeBounds(src)
+    if got := dst.Bounds().Size(); got != image.Pt(x, y) {
+        t.Fatalf("Got %v, want calcResizeBounds's %dx%d", got, x, y)
+    }
+    checkGolden(t, "subimage", dst)
+}
+
+func TestThumbnailVariants(t *testing.T) {
+    tests := []struct {
+        anchors     string
+        flip, vflip bool
+        want        []string
+    }{
+        {"center", false, false, []string{"center"}},
+        {"left,right", false, false, []string{"left", "right"}},
+        {"left,right,center", true, false, []string{"center", "center_flipped", "left", "left_flipped", "right", "right_flipped"}},
+        {"top", false, true, []string{"top", "top_vflipped"}},
+        {"center", true, true, []string{"center", "center_flipped", "center_vflipped"}},
+    }
+
+    src := gradientImage(48, 32)
+    for _, test := range tests {
+        th := testThumbnailer(16, 16)
+        th.Anchors, _ = ParseAnchors(test.anchors)
+        th.Flip, th.FlipVertical = test.flip, test.vflip
+
+        thumbs := th.Thumbnail(src)
+        var got []string
+        for k, img := range thumbs {
+            got = append(got, k)
+            if size := img.Bounds().Size(); size != image.Pt(16, 16) {
+                t.Errorf("%s %s: size %v, want 16x16", test.anchors, k, size)
+            }
+        }
+        sort.Strings(got)
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s, flip %v, vflip %v: got %v, want %v", test.anchors, test.flip, test.vflip, got, test.want)
+        }
+    }
+}
+
+// Landscape sources are cropped across, portrait ones down; either way
+// each crop is a window of subImage, and a flip its mirror.
+func TestThumbnailGolden(t *testing.T) {
+    tests := []struct {
+        name    string
+        anchors string
+        src     image.Image
+        offsets map[string]image.Point
+    }{
+        {"landscape", "left,center,right", gradientImage(48, 32), map[string]image.Point{"left": {0, 0}, "center": {4, 0}, "right": {8, 0}}},
+        {"portrait", "top,bottom", gradientImage(32, 48), map[string]image.Point{"top": {0, 0}, "bottom": {0, 8}}},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(16, 16)
+        th.Anchors, _ = ParseAnchors(test.anchors)
+        resized := th.subImage(test.src)
+
+        thumbs := th.Thumbnail(test.src)
+        for anchor, offset := range test.offsets {
+            thumb := thumbs[anchor]
+            checkGolden(t, "thumb_" + test.name + "_" + anchor, thumb)
+
+            window := resized.(*image.NRGBA).SubImage(image.Rectangle{offset, offset.Add(image.Pt(16, 16))})
+            if d := maxDiff(t, window, thumb); d != 0 {
+                t.Errorf("%s %s: off by up to %d from subImage at %v", test.name, anchor, d, offset)
+            }
+            if d := maxDiff(t, mirror(thumb), thumbs[anchor + "_flipped"]); d != 0 {
+                t.Errorf("%s %s: flipped is off by up to %d from the mirror", test.name, anchor, d)
+            }
+        }
+    }
+}
+
+// MaxSide bounds the longer side, whichever it is, and the other follows
+// the source's aspect ratio; Dim and Mode don't come into it.
+func TestMaxSide(t *testing.T) {
+    tests := []struct {
+        src  image.Point
+        want image.Point
+    }{
+        {image.Pt(300, 200), image.Pt(100, 67)},
+        {image.Pt(200, 300), image.Pt(67, 100)},
+        {image.Pt(150, 150), image.Pt(100, 100)},
+        {image.Pt(1000, 10), image.Pt(100, 1)},
+        {image.Pt(100, 40), image.Pt(100, 40)},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(16, 16)
+        th.Mode = "square"
+        th.MaxSide = 100
+        thumbs := th.Thumbnail(gradientImage(test.src.X, test.src.Y))
+        if len(thumbs) != 2 {
+            t.Errorf("%v: got %d thumbnails, want max and max_flipped", test.src, len(thumbs))
+        }
+        for key, img := range thumbs {
+            if size := img.Bounds().Size(); size != test.want {
+                t.Errorf("%v %s: size %v, want %v", test.src, key, size, test.want)
+            }
+        }
+    }
+
+    th := New()
+    th.MaxSide = 100
+    if !th.Undersized(image.Pt(99, 40)) || th.Undersized(image.Pt(40, 100)) {
+        t.Error("Undersized doesn't go by the longer side")
+    }
+}
+
+// Scale multiplies both sides, rounding, and never below one pixel; past
+// 1 it's an upscale, which has to be allowed.
+func TestScale(t *testing.T) {
+    tests := []struct {
+        scale float64
+        src   image.Point
+        want  image.Point
+    }{
+        {0.5, image.Pt(300, 200), image.Pt(150, 100)},
+        {0.25, image.Pt(101, 55), image.Pt(25, 14)},
+        {0.001, image.Pt(300, 200), image.Pt(1, 1)},
+        {1, image.Pt(30, 20), image.Pt(30, 20)},
+        {2, image.Pt(30, 20), image.Pt(60, 40)},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(16, 16)
+        th.Scale = test.scale
+        th.AllowUpscale = test.scale > 1
+        if err := th.Validate(); err != nil {
+            t.Fatal(err)
+        }
+        thumbs := th.Thumbnail(gradientImage(test.src.X, test.src.Y))
+        for _, key := range []string{"scaled", "scaled_flipped"} {
+            if size := thumbs[key].Bounds().Size(); size != test.want {
+                t.Errorf("%g of %v: %s is %v, want %v", test.scale, test.src, key, size, test.want)
+            }
+        }
+        if th.Undersized(test.src) {
+            t.Errorf("%g of %v: undersized", test.scale, test.src)
+        }
+    }
+
+    th := New()
+    for _, scale := range []float64{-0.5, 1.5} {
+        th.Scale = scale
+        if th.Validate() == nil {
+            t.Errorf("Scale %g accepted without AllowUpscale", scale)
+        }
+    }
+    th.Scale, th.MaxSide = 0.5, 100
+    if th.Validate() == nil {
+        t.Error("Scale accepted with MaxSide")
+    }
+}
+
+// mirror is img flipped left to right.
+func mirror(img image.Image) *image.NRGBA {
+    b := img.Bounds()
+    dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            dst.Set(b.Dx() - 1 - x, y, img.At(b.Min.X + x, b.Min.Y + y))
+        }
+    }
+    return dst
+}
+
+// Square mode is the largest centered square, stretched to the box: square
+// to square, or squashed to a non-square Dim.
+func TestSquareMode(t *testing.T) {
+    tests := []struct {
+        name string
+        src  *image.NRGBA
+        dim  Dim
+        want image.Rectangle
+    }{
+        {"landscape", gradientImage(60, 40), Dim{16, 16}, image.Rect(10, 0, 50, 40)},
+        {"portrait", gradientImage(40, 60), Dim{16, 16}, image.Rect(0, 10, 40, 50)},
+        {"odd", gradientImage(41, 40), Dim{16, 16}, image.Rect(0, 0, 40, 40)},
+        {"wide", gradientImage(60, 40), Dim{24, 16}, image.Rect(10, 0, 50, 40)},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(test.dim[0], test.dim[1])
+        th.Mode = "square"
+        thumbs := th.Thumbnail(test.src)
+        if test.name == "landscape" {
+            checkGolden(t, "square", thumbs["square"])
+        }
+
+        want := stretched(testThumbnailer(0, 0), test.src.SubImage(test.want), test.dim[0], test.dim[1])
+        if d := maxDiff(t, want, thumbs["square"]); d != 0 {
+            t.Errorf("%s: off by up to %d from the crop at %v", test.name, d, test.want)
+        }
+        if d := maxDiff(t, mirror(want), thumbs["square_flipped"]); d != 0 {
+            t.Errorf("%s: flipped is off by up to %d from the mirror", test.name, d)
+        }
+    }
+}
+
+// Fit mode's margins are PadColor, alpha and all, in a PNG, or Background
+// without one; a JPEG flattens them onto FlattenBackground.
+func TestPadColor(t *testing.T) {
+    red := color.NRGBA{255, 0, 0, 255}
+    translucent := color.NRGBA{0, 0, 255, 128}
+    tests := []struct {
+        name   string
+        pad    color.Color
+        format string
+        want   color.NRGBA
+    }{
+        {"background", nil, "png", red},
+        {"translucent", translucent, "png", translucent},
+        {"transparent", color.Transparent, "png", color.NRGBA{}},
+        {"jpeg", color.Transparent, "jpeg", color.NRGBA{255, 255, 255, 255}},
+    }
+
+    for _, test := range tests {
+        storage := newMemStorage()
+        storage.WriteFile("in/a.png", pngData(t, gradientImage(48, 24)))
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        th.Mode = "fit"
+        th.Flip = false
+        th.Background = red
+        th.PadColor = test.pad
+        th.Format = test.format
+        if err := th.ProcessFile("in/a.png", "out"); err != nil {
+            t.Fatal(err)
+        }
+        data, err := storage.ReadFile("out/a_fit" + FORMAT_EXTENSIONS[test.format])
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        // Fitted to 16x8, between margins four rows deep, which JPEG's
+        // blocks blur a little into.
+        img := decodeData(t, data)
+        tolerance := map[string]int{"png": 0, "jpeg": 8}[test.format]
+        for _, p := range []image.Point{{0, 0}, {8, 2}, {15, 3}, {0, 12}, {8, 13}, {15, 15}} {
+            got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+            want := test.want
+            if diff(got.R, want.R) > tolerance || diff(got.G, want.G) > tolerance || diff(got.B, want.B) > tolerance || got.A != want.A {
+                t.Errorf("%s: %v is %v, want %v", test.name, p, got, want)
+            }
+        }
+    }
+}
+
+// _flipped mirrors left to right, _vflipped top to bottom, each from the
+// unflipped thumbnail.
+func TestFlipAxes(t *testing.T) {
+    th := testThumbnailer(32, 16)
+    th.Mode = "stretch"
+    th.FlipVertical = true
+    thumbs := th.Thumbnail(quadrants())
+    base := thumbs["stretch"]
+
+    upsideDown := image.NewNRGBA(base.Bounds())
+    h := base.Bounds().Dy()
+    for y := 0; y < h; y++ {
+        for x := 0; x < base.Bounds().Dx(); x++ {
+            upsideDown.Set(x, h - 1 - y, base.At(x, y))
+        }
+    }
+    if d := maxDiff(t, mirror(base), thumbs["stretch_flipped"]); d != 0 {
+        t.Errorf("Flipped is off by up to %d from the mirror", d)
+    }
+    if d := maxDiff(t, upsideDown, thumbs["stretch_vflipped"]); d != 0 {
+        t.Errorf("Vflipped is off by up to %d from upside down", d)
+    }
+    // Red is top left; flipped it's top right, vflipped bottom left.
+    red := color.NRGBA{255, 0, 0, 255}
+    for key, at := range map[string]image.Point{"stretch": {4, 4}, "stretch_flipped": {28, 4}, "stretch_vflipped": {4, 12}} {
+        if got := color.NRGBAModel.Convert(thumbs[key].At(at.X, at.Y)); got != red {
+            t.Errorf("%s: %v at %v, want red", key, got, at)
+        }
+    }
+}
+
+func TestChecksum(t *testing.T) {
+    path := writeFile(t, t.TempDir(), "a.bin", []byte("thumbnailer"))
+    tests := map[string]string{
+        "crc32": "3d718fcd",
+        "sha256": "1663afd1416d5e6f4e6a65f611b81970e7abc2df4d318a0f4916ed71ab0192c1",
+    }
+
+    for hash, want := range tests {
+        th := New()
+        th.Hash = hash
+        if got, err := th.Checksum(path); err != nil || got != want {
+            t.Errorf("%s: got %q, %v, want %q", hash, got, err, want)
+        }
+    }
+
+    if _, err := New().Checksum(filepath.Join(t.TempDir(), "missing")); err == nil {
+        t.Error("Checksum of a missing file succeeded")
+    }
+}
+
+func TestIsDupe(t *testing.T) {
+    th := New()
+    if original, dupe := th.isDupe(fileKeys{checksum: "aa"}, nil, "a"); dupe {
+        t.Fatalf("First sighting is a duplicate of %q", original)
+    }
+    if _, dupe := th.isDupe(fileKeys{checksum: "bb"}, nil, "b"); dupe {
+        t.Fatal("Distinct checksum is a duplicate")
+    }
+    if original, dupe := th.isDupe(fileKeys{checksum: "aa"}, nil, "c"); !dupe || original != "a" {
+        t.Fatalf("Got %q, %v, want a duplicate of a", original, dupe)
+    }
+}
+
+// stretched is src's one stretch-mode thumbnail at w x h under th, which
+// crops nothing, so adjustments can be compared pixel for pixel.
+func stretched(th *Thumbnailer, src image.Image, w, h int) *image.NRGBA {
+    th.Dim = Dim{w, h}
+    th.Mode = "stretch"
+    th.Flip = false
+    return th.Thumbnail(src)["stretch"].(*image.NRGBA)
+}
+
+// edgeImage is dark on the left and light on the right: one hard edge,
+// for resizing to soften.
+func edgeImage() *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, 32, 8))
+    for y := 0; y < 8; y++ {
+        for x := 0; x < 32; x++ {
+            v := uint8(64)
+            if x >= 16 {
+                v = 192
+            }
+            img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+        }
+    }
+    return img
+}
+
+// rowRange is the darkest and lightest red in img's middle row.
+func rowRange(img *image.NRGBA) (int, int) {
+    lo, hi := 255, 0
+    y := img.Bounds().Dy() / 2
+    for x := 0; x < img.Bounds().Dx(); x++ {
+        v := int(img.NRGBAAt(x, y).R)
+        lo, hi = min(lo, v), max(hi, v)
+    }
+    return lo, hi
+}
+
+// Sharpening overshoots on both sides of an edge; 0 adds no filter at all.
+func TestSharpen(t *testing.T) {
+    plain := stretched(New(), edgeImage(), 16, 4)
+    lo, hi := rowRange(plain)
+
+    th := New()
+    th.Sharpen = 2
+    sharp := stretched(th, edgeImage(), 16, 4)
+    sharpLo, sharpHi := rowRange(sharp)
+    if sharpLo >= lo || sharpHi <= hi {
+        t.Errorf("Sharpened range %d-%d, want wider than %d-%d", sharpLo, sharpHi, lo, hi)
+    }
+
+    th.Sharpen = 0
+    if n := len(th.adjustments()); n != 0 {
+        t.Errorf("Sharpen 0 added %d filters", n)
+    }
+    if d := maxDiff(t, plain, stretched(th, edgeImage(), 16, 4)); d != 0 {
+        t.Errorf("Sharpen 0 is off by up to %d", d)
+    }
+
+    th.Sharpen = -1
+    if th.Validate() == nil {
+        t.Error("Negative sharpen validated")
+    }
+}
+
+func TestGrayscale(t *testing.T) {
+    th := New()
+    th.Grayscale = true
+    img := stretched(th, gradientImage(32, 32), 16, 16)
+    for i := 0; i < len(img.Pix); i += 4 {
+        if r, g, b := img.Pix[i], img.Pix[i + 1], img.Pix[i + 2]; r != g || g != b {
+            t.Fatalf("Pixel %d is %d,%d,%d, not gray", i / 4, r, g, b)
+        }
+    }
+
+    data, err := th.encodeThumb(img, "png", nil, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    config, err := png.DecodeConfig(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if config.ColorModel != color.GrayModel {
+        t.Errorf("Encoded as %T, want 8-bit gray", config.ColorModel)
+    }
+}
+
+func TestToneRanges(t *testing.T) {
+    tests := []struct {
+        name string
+        set  func(*Thumbnailer)
+        ok   bool
+    }{
+        {"brightness -100", func(th *Thumbnailer) { th.Brightness = -100 }, true},
+        {"brightness 100", func(th *Thumbnailer) { th.Brightness = 100 }, true},
+        {"brightness -101", func(th *Thumbnailer) { th.Brightness = -101 }, false},
+        {"brightness 100.5", func(th *Thumbnailer) { th.Brightness = 100.5 }, false},
+        {"contrast -100", func(th *Thumbnailer) { th.Contrast = -100 }, true},
+        {"contrast 100", func(th *Thumbnailer) { th.Contrast = 100 }, true},
+        {"contrast -101", func(th *Thumbnailer) { th.Contrast = -101 }, false},
+        {"contrast 101", func(th *Thumbnailer) { th.Contrast = 101 }, false},
+        {"gamma 0.1", func(th *Thumbnailer) { th.Gamma = 0.1 }, true},
+        {"gamma 5", func(th *Thumbnailer) { th.Gamma = 5 }, true},
+        {"gamma 0", func(th *Thumbnailer) { th.Gamma = 0 }, false},
+        {"gamma -1", func(th *Thumbnailer) { th.Gamma = -1 }, false},
+    }
+
+    for _, test := range tests {
+        th := New()
+        test.set(th)
+        if err := th.Validate(); (err == nil) != test.ok {
+            t.Errorf("%s: got %v, want ok %v", test.name, err, test.ok)
+        }
+    }
+}
+
+// Each tone adjustment moves a mid gray, and a gradient's spread, the way
+// its sign says; the neutral values add no filters.
+func TestToneEffects(t *testing.T) {
+    gray := solidImage(16, 16, color.NRGBA{128, 128, 128, 255})
+    level := func(set func(*Thumbnailer)) int {
+        th := New()
+        set(th)
+        return int(stretched(th, gray, 8, 8).NRGBAAt(4, 4).R)
+    }
+    spread := func(set func(*Thumbnailer)) int {
+        th := New()
+        set(th)
+        lo, hi := rowRange(stretched(th, gradientImage(16, 16), 8, 8))
+        return hi - lo
+    }
+
+    base := level(func(*Thumbnailer) {})
+    if got := level(func(th *Thumbnailer) { th.Brightness = 30 }); got <= base {
+        t.Errorf("Brightness 30: %d, want above %d", got, base)
+    }
+    if got := level(func(th *Thumbnailer) { th.Brightness = -30 }); got >= base {
+        t.Errorf("Brightness -30: %d, want below %d", got, base)
+    }
+    if got := level(func(th *Thumbnailer) { th.Gamma = 2 }); got <= base {
+        t.Errorf("Gamma 2: %d, want above %d", got, base)
+    }
+    if got := level(func(th *Thumbnailer) { th.Gamma = 0.5 }); got >= base {
+        t.Errorf("Gamma 0.5: %d, want below %d", got, base)
+    }
+
+    baseSpread := spread(func(*Thumbnailer) {})
+    if got := spread(func(th *Thumbnailer) { th.Contrast = 50 }); got <= baseSpread {
+        t.Errorf("Contrast 50: spread %d, want above %d", got, baseSpread)
+    }
+    if got := spread(func(th *Thumbnailer) { th.Contrast = -50 }); got >= baseSpread {
+        t.Errorf("Contrast -50: spread %d, want below %d", got, baseSpread)
+    }
+
+    if n := len(New().adjustments()); n != 0 {
+        t.Errorf("Defaults add %d filters", n)
+    }
+}
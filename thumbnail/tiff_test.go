@@ -0,0 +1,120 @@
+package thumbnail
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "image"
+    "image/color"
+    "io"
+    "os"
+    "testing"
+)
+
+//=============================================================================
+
+// The TIFFs in testdata are 48x32 and uncompressed: gray8.tif an 8-bit
+// gray ramp, x * 5 + y * 2; pages.tif that, then its inverse as a second
+// page; rgb16.tif 16-bit RGB of x * 1365 + y, y * 2000 + 7 and
+// 0x1234 + x * y.
+
+func readTestdata(t *testing.T, name string) []byte {
+    t.Helper()
+    data, err := os.ReadFile("testdata/" + name)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+// Each TIFF decodes at its own depth, the first page of a multi-page one,
+// and thumbnails through ProcessAs, 16-bit ones to 16-bit PNGs.
+func TestTIFF(t *testing.T) {
+    tests := []struct {
+        name string
+        deep bool
+        at   color.Color // At (10, 5).
+    }{
+        {"gray8.tif", false, color.Gray{60}},
+        {"pages.tif", false, color.Gray{60}},
+        {"rgb16.tif", true, color.RGBA64{13650 + 5, 10007, 0x1234 + 50, 0xFFFF}},
+    }
+
+    for _, test := range tests {
+        storage := newMemStorage()
+        storage.WriteFile("in/a.tif", readTestdata(t, test.name))
+        th := testThumbnailer(16, 16)
+        th.Storage = storage
+        th.Single = true
+        th.Flip = false
+        th.BitDepth = 16
+        result, err := th.ProcessAs(context.Background(), "in/a.tif", "out", "a")
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if result.Format != "tiff" || result.Size != image.Pt(48, 32) {
+            t.Errorf("%s: decoded as %s, %v", test.name, result.Format, result.Size)
+        }
+
+        img, _, err := decodeImage(readTestdata(t, test.name))
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if got := img.At(10, 5); !equalColors(got, test.at) {
+            t.Errorf("%s: (10, 5) is %v, want %v", test.name, got, test.at)
+        }
+        data, err := storage.ReadFile("out/a.png")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if isDeep(img) != test.deep || isDeep(decodeData(t, data)) != test.deep {
+            t.Errorf("%s: decodes as %T, thumbnailed as %T", test.name, img, decodeData(t, data))
+        }
+    }
+
+    th := New()
+    th.RejectAnimated = true
+    if _, err := th.Decode(readTestdata(t, "pages.tif")); !errors.Is(err, ErrAnimated) {
+        t.Errorf("Got %v for a second page, want ErrAnimated", err)
+    }
+}
+
+func equalColors(a, b color.Color) bool {
+    r1, g1, b1, a1 := a.RGBA()
+    r2, g2, b2, a2 := b.RGBA()
+    return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+// A TIFF the decoder can't handle is an error, and not ErrCorrupt.
+func TestTIFFUnsupported(t *testing.T) {
+    data := readTestdata(t, "gray8.tif")
+    // Its compression tag, uncompressed, made an unknown scheme.
+    entry := bytes.Index(data, []byte{0x03, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00})
+    if entry == -1 {
+        t.Fatal("No compression tag")
+    }
+    data = append([]byte(nil), data...)
+    binary.LittleEndian.PutUint16(data[entry + 8:], 99)
+
+    storage := newMemStorage()
+    storage.WriteFile("in/a.tif", data)
+    th := testThumbnailer(16, 16)
+    th.Storage = storage
+    err := th.ProcessFile("in/a.tif", "out")
+    if err == nil || errors.Is(err, ErrCorrupt) {
+        t.Errorf("Got %v, want an unsupported error", err)
+    }
+}
+
+// A decoder panicking is an error too.
+func TestDecoderPanic(t *testing.T) {
+    image.RegisterFormat("panicky", "PANIC!", func(io.Reader) (image.Image, error) {
+        panic("odd subtype")
+    }, func(io.Reader) (image.Config, error) {
+        return image.Config{}, nil
+    })
+    if _, _, err := decodeImage([]byte("PANIC!")); !errors.Is(err, errUnsupported) {
+        t.Errorf("Got %v, want errUnsupported", err)
+    }
+}
@@ -0,0 +1,68 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+)
+
+//=============================================================================
+
+// AutoTrim crops away the flat margins of scans and screenshots, so the
+// thumbnail is of the page rather than the paper around it. A margin is
+// any run of edge rows or columns within TrimTolerance (per channel) of
+// the top-left corner's color. It happens after Crop, on the region, and
+// after the undersized check, which only ever sees the untrimmed size.
+
+func withinTolerance(a, b color.NRGBA, tolerance int) bool {
+    diff := func(x, y uint8) int {
+        if x > y {
+            return int(x - y)
+        }
+        return int(y - x)
+    }
+    return diff(a.R, b.R) <= tolerance && diff(a.G, b.G) <= tolerance &&
+        diff(a.B, b.B) <= tolerance && diff(a.A, b.A) <= tolerance
+}
+
+// trimBounds is img's bounds less its uniform margins. An image that's all
+// margin is left whole.
+func trimBounds(img image.Image, tolerance int) image.Rectangle {
+    b := img.Bounds()
+    if b.Empty() {
+        return b
+    }
+
+    at := func(x, y int) color.NRGBA {
+        return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+    }
+    corner := at(b.Min.X, b.Min.Y)
+
+    uniform := func(r image.Rectangle) bool {
+        for y := r.Min.Y; y < r.Max.Y; y++ {
+            for x := r.Min.X; x < r.Max.X; x++ {
+                if !withinTolerance(at(x, y), corner, tolerance) {
+                    return false
+                }
+            }
+        }
+        return true
+    }
+
+    r := b
+    for r.Min.Y < r.Max.Y && uniform(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y + 1)) {
+        r.Min.Y++
+    }
+    if r.Empty() {
+        return b
+    }
+    for uniform(image.Rect(r.Min.X, r.Max.Y - 1, r.Max.X, r.Max.Y)) {
+        r.Max.Y--
+    }
+    for uniform(image.Rect(r.Min.X, r.Min.Y, r.Min.X + 1, r.Max.Y)) {
+        r.Min.X++
+    }
+    for uniform(image.Rect(r.Max.X - 1, r.Min.Y, r.Max.X, r.Max.Y)) {
+        r.Max.X--
+    }
+    return r
+}
@@ -0,0 +1,55 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "testing"
+)
+
+//=============================================================================
+
+// page is a 40x30 white sheet with a dark block on it at content, and a
+// speck of near-white at (1, 1).
+func page(content image.Rectangle) *image.NRGBA {
+    img := solidImage(40, 30, color.White)
+    draw.Draw(img, content, image.NewUniform(color.NRGBA{40, 60, 80, 255}), image.Point{}, draw.Src)
+    img.SetNRGBA(1, 1, color.NRGBA{245, 245, 245, 255})
+    return img
+}
+
+func TestTrimBounds(t *testing.T) {
+    content := image.Rect(5, 3, 30, 20)
+    tests := []struct {
+        name      string
+        img       image.Image
+        tolerance int
+        want      image.Rectangle
+    }{
+        // The speck isn't margin until it's within tolerance.
+        {"exact", page(content), 0, image.Rect(1, 1, 30, 20)},
+        {"tolerant", page(content), 10, content},
+        {"too tolerant", page(content), 255, image.Rect(0, 0, 40, 30)},
+        {"no margin", gradientImage(20, 20), 0, image.Rect(0, 0, 20, 20)},
+        {"all margin", solidImage(20, 20, color.White), 0, image.Rect(0, 0, 20, 20)},
+        {"offset bounds", page(content).SubImage(image.Rect(2, 2, 40, 30)), 0, content},
+    }
+    for _, test := range tests {
+        if got := trimBounds(test.img, test.tolerance); got != test.want {
+            t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+        }
+    }
+}
+
+// With AutoTrim, thumbnails are of the content alone.
+func TestAutoTrim(t *testing.T) {
+    content := image.Rect(5, 3, 30, 20)
+    src := page(content)
+    th := New()
+    th.AutoTrim = true
+    th.TrimTolerance = 10
+    want := stretched(New(), src.SubImage(content), 16, 8)
+    if d := maxDiff(t, want, stretched(th, src, 16, 8)); d != 0 {
+        t.Errorf("Off by up to %d from the content's thumbnail", d)
+    }
+}
@@ -0,0 +1,81 @@
+package thumbnail
+
+import (
+    "github.com/disintegration/gift"
+    "image"
+    "image/color"
+    "image/draw"
+)
+
+//=============================================================================
+
+// Watermarks are stamped last, after any flip, so their text never comes
+// out mirrored. They're sized relative to each thumbnail rather than drawn
+// at their own size, so one logo works across every -d.
+
+type watermarkFilter struct {
+    mark    image.Image
+    anchor  gift.Anchor
+    opacity float64
+    scale   float64
+}
+
+// watermark is the filter for the Watermark options, or nil without one.
+func (t *Thumbnailer) watermark() gift.Filter {
+    if t.Watermark == nil {
+        return nil
+    }
+    return &watermarkFilter{t.Watermark, ANCHORINGS[t.WatermarkAnchor], t.WatermarkOpacity, t.WatermarkScale}
+}
+
+func (f *watermarkFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+    return image.Rect(0, 0, srcBounds.Dx(), srcBounds.Dy())
+}
+
+// anchorOffset is where a box of size inner goes within outer, per anchor.
+func anchorOffset(anchor gift.Anchor, outer, inner image.Point) image.Point {
+    free := outer.Sub(inner)
+    x, y := free.X / 2, free.Y / 2
+
+    switch anchor {
+    case gift.LeftAnchor, gift.TopLeftAnchor, gift.BottomLeftAnchor:
+        x = 0
+    case gift.RightAnchor, gift.TopRightAnchor, gift.BottomRightAnchor:
+        x = free.X
+    }
+    switch anchor {
+    case gift.TopAnchor, gift.TopLeftAnchor, gift.TopRightAnchor:
+        y = 0
+    case gift.BottomAnchor, gift.BottomLeftAnchor, gift.BottomRightAnchor:
+        y = free.Y
+    }
+
+    return image.Pt(x, y)
+}
+
+func (f *watermarkFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+    b := src.Bounds()
+    draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+    // Scale's share of the thumbnail's width, but never spilling over its
+    // height either.
+    mark := f.mark.Bounds().Size()
+    w := int(float64(b.Dx()) * f.scale + 0.5)
+    h := mark.Y * w / mark.X
+    if h > b.Dy() {
+        h = b.Dy()
+        w = mark.X * h / mark.Y
+    }
+    if w < 1 || h < 1 {
+        return
+    }
+
+    g := gift.New(gift.Resize(w, h, gift.LanczosResampling))
+    scaled := image.NewNRGBA(g.Bounds(f.mark.Bounds()))
+    g.Draw(scaled, f.mark)
+
+    at := dst.Bounds().Min.Add(anchorOffset(f.anchor, b.Size(), scaled.Bounds().Size()))
+    r := image.Rectangle{at, at.Add(scaled.Bounds().Size())}
+    opacity := image.NewUniform(color.Alpha{uint8(f.opacity * 255 + 0.5)})
+    draw.DrawMask(dst, r, scaled, image.Point{}, opacity, image.Point{}, draw.Over)
+}
@@ -0,0 +1,86 @@
+package thumbnail
+
+import (
+    "image"
+    "image/color"
+    "testing"
+)
+
+//=============================================================================
+
+// stamped is the box of img's pixels that aren't black, and the red of
+// its middle one.
+func stamped(img image.Image) (image.Rectangle, uint8) {
+    var box image.Rectangle
+    b := img.Bounds()
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            if c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA); c.R > 0 {
+                box = box.Union(image.Rect(x, y, x + 1, y + 1))
+            }
+        }
+    }
+    if box.Empty() {
+        return box, 0
+    }
+    mid := box.Min.Add(box.Size().Div(2))
+    return box, color.NRGBAModel.Convert(img.At(mid.X, mid.Y)).(color.NRGBA).R
+}
+
+// A white mark on black thumbnails: where it lands, how big, and how
+// strongly.
+func TestWatermark(t *testing.T) {
+    black := solidImage(200, 100, color.Black)
+    tests := []struct {
+        anchor  string
+        opacity float64
+        scale   float64
+        mark    image.Point
+        want    image.Rectangle
+    }{
+        {"bottom-right", 0.5, 0.25, image.Pt(20, 10), image.Rect(75, 38, 100, 50)},
+        {"top-left", 1, 0.5, image.Pt(20, 10), image.Rect(0, 0, 50, 25)},
+        {"center", 0.25, 0.1, image.Pt(20, 20), image.Rect(45, 20, 55, 30)},
+        // Too tall at its share of the width, so it's fitted to the height.
+        {"right", 1, 0.5, image.Pt(10, 40), image.Rect(88, 0, 100, 50)},
+    }
+
+    for _, test := range tests {
+        th := testThumbnailer(100, 50)
+        th.Mode = "stretch"
+        th.Watermark = solidImage(test.mark.X, test.mark.Y, color.White)
+        th.WatermarkAnchor = test.anchor
+        th.WatermarkOpacity = test.opacity
+        th.WatermarkScale = test.scale
+        if err := th.Validate(); err != nil {
+            t.Fatal(err)
+        }
+
+        // Stamped after the flip, so in the same place on both.
+        for key, img := range th.Thumbnail(black) {
+            box, red := stamped(img)
+            if box != test.want {
+                t.Errorf("%s %s: mark at %v, want %v", test.anchor, key, box, test.want)
+            }
+            if want := int(test.opacity * 255 + 0.5); diff(red, uint8(want)) > 1 {
+                t.Errorf("%s %s: red %d at opacity %g, want %d", test.anchor, key, red, test.opacity, want)
+            }
+        }
+    }
+}
+
+func TestWatermarkBounds(t *testing.T) {
+    th := New()
+    th.Watermark = solidImage(10, 10, color.White)
+    for _, scale := range []float64{0, 1.5} {
+        th.WatermarkScale = scale
+        if th.Validate() == nil {
+            t.Errorf("Scale %g accepted", scale)
+        }
+    }
+    th.WatermarkScale = 0.25
+    th.WatermarkOpacity = -0.1
+    if th.Validate() == nil {
+        t.Error("Negative opacity accepted")
+    }
+}
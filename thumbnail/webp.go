@@ -0,0 +1,641 @@
+package thumbnail
+
+import (
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/draw"
+    "io"
+    "sort"
+)
+
+//=============================================================================
+
+// golang.org/x/image only decodes WebP, so this is a small encoder for the
+// lossless flavor, VP8L: subtract-green and per-tile predictor transforms,
+// then backward references to the pixel left or above, a color cache, and
+// Huffman codes built per image. That's most of what libwebp does at its
+// fast settings, minus the searching. Quality below 81 turns it near
+// lossless: the low bits of the colors are rounded off first, which costs
+// a little banding and, on photos, buys a lot of size, more the lower it
+// goes. (Smooth synthetic ramps can come out bigger: rounding breaks up
+// the runs of equal steps the predictor thrives on.)
+
+const (
+    webpMaxSide    = 16384
+    webpTileBits   = 4
+    webpCacheBits  = 10
+    webpMinMatch   = 3
+    webpMaxMatch   = 4096
+    webpCacheMult  = 0x1e35a7bd
+    webpLiterals   = 256
+    webpLengthSyms = 24
+)
+
+// Predictor modes tried on each tile; all but 0, opaque black.
+var webpPredictors = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+
+// As the decoder reads the code length code's lengths.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpWriter is a VP8L bit stream, which is packed least significant bit
+// first.
+type webpWriter struct {
+    buf   []byte
+    acc   uint64
+    nBits uint
+}
+
+func (w *webpWriter) write(v uint32, n int) {
+    w.acc |= uint64(v) << w.nBits
+    w.nBits += uint(n)
+    for w.nBits >= 8 {
+        w.buf = append(w.buf, byte(w.acc))
+        w.acc >>= 8
+        w.nBits -= 8
+    }
+}
+
+func (w *webpWriter) bytes() []byte {
+    if w.nBits > 0 {
+        w.buf = append(w.buf, byte(w.acc))
+        w.acc, w.nBits = 0, 0
+    }
+    return w.buf
+}
+
+// huffmanLengths is a length-limited Huffman code for hist. Codes that
+// come out too long are retried with the counts flattened.
+func huffmanLengths(hist []int, limit int) []int {
+    counts := append([]int(nil), hist...)
+    for {
+        lengths := huffmanDepths(counts)
+        longest := 0
+        for _, l := range lengths {
+            longest = max(longest, l)
+        }
+        if longest <= limit {
+            return lengths
+        }
+        for i, c := range counts {
+            if c > 0 {
+                counts[i] = (c + 1) / 2
+            }
+        }
+    }
+}
+
+// huffmanDepths is each symbol's depth in a Huffman tree for counts, by
+// the two-queue method. A lone symbol gets depth 1.
+func huffmanDepths(counts []int) []int {
+    type node struct {
+        weight, parent int
+    }
+    var nodes []node
+    var leaves []int
+    for sym, c := range counts {
+        if c > 0 {
+            leaves = append(leaves, sym)
+        }
+    }
+    sort.SliceStable(leaves, func(i, j int) bool { return counts[leaves[i]] < counts[leaves[j]] })
+
+    depths := make([]int, len(counts))
+    if len(leaves) == 1 {
+        depths[leaves[0]] = 1
+    }
+    if len(leaves) < 2 {
+        return depths
+    }
+
+    for _, sym := range leaves {
+        nodes = append(nodes, node{counts[sym], -1})
+    }
+    nLeaves := len(nodes)
+    leaf, inner := 0, nLeaves
+    pick := func() int {
+        if leaf < nLeaves && (inner >= len(nodes) || nodes[leaf].weight <= nodes[inner].weight) {
+            leaf++
+            return leaf - 1
+        }
+        inner++
+        return inner - 1
+    }
+    for len(nodes) < 2 * nLeaves - 1 {
+        a, b := pick(), pick()
+        nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, -1})
+        nodes[a].parent, nodes[b].parent = len(nodes) - 1, len(nodes) - 1
+    }
+
+    for i, sym := range leaves {
+        for n := i; nodes[n].parent >= 0; n = nodes[n].parent {
+            depths[sym]++
+        }
+    }
+    return depths
+}
+
+// A prefixCode is how each symbol of one alphabet is written. With a
+// single symbol in use, nothing is: the decoder knows what it must be.
+type prefixCode struct {
+    codes   []uint32 // Bit-reversed, ready for webpWriter.
+    lengths []int
+}
+
+func (c *prefixCode) put(w *webpWriter, sym int) {
+    if c.lengths[sym] > 0 {
+        w.write(c.codes[sym], c.lengths[sym])
+    }
+}
+
+// canonicalCode assigns codes to lengths the way the decoder expects.
+func canonicalCode(lengths []int) *prefixCode {
+    c := &prefixCode{codes: make([]uint32, len(lengths)), lengths: append([]int(nil), lengths...)}
+    var counts [16]int
+    for _, l := range lengths {
+        counts[l]++
+    }
+    counts[0] = 0
+
+    var next [16]uint32
+    code := uint32(0)
+    for l := 1; l < 16; l++ {
+        code = (code + uint32(counts[l - 1])) << 1
+        next[l] = code
+    }
+    for sym, l := range lengths {
+        if l == 0 {
+            continue
+        }
+        rev := uint32(0)
+        for i, v := 0, next[l]; i < l; i++ {
+            rev = rev << 1 | v >> i & 1
+        }
+        c.codes[sym] = rev
+        next[l]++
+    }
+    return c
+}
+
+// writePrefixCode writes a code for hist and returns it.
+func writePrefixCode(w *webpWriter, hist []int) *prefixCode {
+    var used []int
+    for sym, n := range hist {
+        if n > 0 {
+            used = append(used, sym)
+        }
+    }
+    if len(used) == 0 {
+        used = []int{0}
+    }
+
+    // Up to two 8-bit symbols can go as a "simple" code.
+    if len(used) <= 2 && used[len(used) - 1] < 256 {
+        w.write(1, 1)
+        w.write(uint32(len(used) - 1), 1)
+        if used[0] < 2 {
+            w.write(0, 1)
+            w.write(uint32(used[0]), 1)
+        } else {
+            w.write(1, 1)
+            w.write(uint32(used[0]), 8)
+        }
+        lengths := make([]int, len(hist))
+        if len(used) == 2 {
+            w.write(uint32(used[1]), 8)
+            lengths[used[0]], lengths[used[1]] = 1, 1
+        }
+        return canonicalCode(lengths)
+    }
+
+    lengths := huffmanLengths(hist, 15)
+    w.write(0, 1)
+    writeCodeLengths(w, lengths)
+    if len(used) == 1 {
+        lengths[used[0]] = 0
+    }
+    return canonicalCode(lengths)
+}
+
+// writeCodeLengths writes lengths with the code length code, runs of
+// zeros as its repeat symbols 17 and 18.
+func writeCodeLengths(w *webpWriter, lengths []int) {
+    type token struct {
+        sym, extra, extraBits int
+    }
+    var tokens []token
+    for i := 0; i < len(lengths); {
+        if lengths[i] != 0 {
+            tokens = append(tokens, token{lengths[i], 0, 0})
+            i++
+            continue
+        }
+        run := 1
+        for i + run < len(lengths) && lengths[i + run] == 0 {
+            run++
+        }
+        i += run
+        for run > 0 {
+            switch {
+            case run >= 11:
+                n := min(run, 138)
+                tokens = append(tokens, token{18, n - 11, 7})
+                run -= n
+            case run >= 3:
+                tokens = append(tokens, token{17, run - 3, 3})
+                run = 0
+            default:
+                tokens = append(tokens, token{0, 0, 0})
+                run--
+            }
+        }
+    }
+
+    hist := make([]int, 19)
+    for _, t := range tokens {
+        hist[t.sym]++
+    }
+    clLengths := huffmanLengths(hist, 7)
+
+    n := 4
+    for i, sym := range webpCodeLengthOrder {
+        if clLengths[sym] > 0 {
+            n = max(n, i + 1)
+        }
+    }
+    w.write(uint32(n - 4), 4)
+    for _, sym := range webpCodeLengthOrder[:n] {
+        w.write(uint32(clLengths[sym]), 3)
+    }
+    w.write(0, 1) // Every symbol's length is coded; no max_symbol.
+
+    single := 0
+    for _, l := range clLengths {
+        if l > 0 {
+            single++
+        }
+    }
+    if single == 1 {
+        for i := range clLengths {
+            clLengths[i] = 0
+        }
+    }
+    code := canonicalCode(clLengths)
+    for _, t := range tokens {
+        code.put(w, t.sym)
+        if t.extraBits > 0 {
+            w.write(uint32(t.extra), t.extraBits)
+        }
+    }
+}
+
+// lz77Prefix splits an LZ77 length or distance code into its prefix
+// symbol and extra bits.
+func lz77Prefix(v int) (sym, extraBits, extra int) {
+    n := v - 1
+    if n < 4 {
+        return n, 0, 0
+    }
+    h := 0
+    for 1 << (h + 1) <= n {
+        h++
+    }
+    second := n >> (h - 1) & 1
+    extraBits = h - 1
+    return 2 * h + second, extraBits, n - ((2 + second) << extraBits)
+}
+
+// A webpToken is a literal pixel, a color cache hit or a backward
+// reference.
+type webpToken struct {
+    kind     byte // 'l', 'c' or 'r'.
+    argb     uint32
+    index    int
+    length   int
+    distCode int
+}
+
+// writeEntropyImage writes argb (w wide) as VP8L entropy-coded image
+// data. Only the main image can have a color cache or meta codes; this
+// never uses meta codes.
+func writeEntropyImage(w *webpWriter, argb []uint32, width int, main bool) {
+    cacheBits := 0
+    if main {
+        cacheBits = webpCacheBits
+    }
+    var cache []uint32
+    if cacheBits > 0 {
+        cache = make([]uint32, 1 << cacheBits)
+    }
+    hash := func(p uint32) int {
+        return int((p * webpCacheMult) >> (32 - cacheBits))
+    }
+    insert := func(p uint32) {
+        if cache != nil {
+            cache[hash(p)] = p
+        }
+    }
+
+    var tokens []webpToken
+    for i := 0; i < len(argb); {
+        bestLen, bestCode := 0, 0
+        for _, ref := range []struct{ dist, code int }{{1, 2}, {width, 1}} {
+            if i < ref.dist {
+                continue
+            }
+            n := 0
+            for i + n < len(argb) && n < webpMaxMatch && argb[i + n] == argb[i + n - ref.dist] {
+                n++
+            }
+            if n > bestLen {
+                bestLen, bestCode = n, ref.code
+            }
+        }
+
+        switch {
+        case bestLen >= webpMinMatch:
+            tokens = append(tokens, webpToken{kind: 'r', length: bestLen, distCode: bestCode})
+            for _, p := range argb[i:i + bestLen] {
+                insert(p)
+            }
+            i += bestLen
+        case cache != nil && cache[hash(argb[i])] == argb[i]:
+            tokens = append(tokens, webpToken{kind: 'c', index: hash(argb[i])})
+            i++
+        default:
+            tokens = append(tokens, webpToken{kind: 'l', argb: argb[i]})
+            insert(argb[i])
+            i++
+        }
+    }
+
+    green := make([]int, webpLiterals + webpLengthSyms + len(cache))
+    red, blue, alpha := make([]int, 256), make([]int, 256), make([]int, 256)
+    dist := make([]int, 40)
+    for _, t := range tokens {
+        switch t.kind {
+        case 'l':
+            green[t.argb >> 8 & 0xff]++
+            red[t.argb >> 16 & 0xff]++
+            blue[t.argb & 0xff]++
+            alpha[t.argb >> 24]++
+        case 'c':
+            green[webpLiterals + webpLengthSyms + t.index]++
+        case 'r':
+            sym, _, _ := lz77Prefix(t.length)
+            green[webpLiterals + sym]++
+            sym, _, _ = lz77Prefix(t.distCode)
+            dist[sym]++
+        }
+    }
+
+    if main {
+        w.write(1, 1)
+        w.write(uint32(cacheBits), 4)
+        w.write(0, 1) // No meta prefix codes.
+    } else {
+        w.write(0, 1)
+    }
+    codes := [5]*prefixCode{}
+    for i, hist := range [][]int{green, red, blue, alpha, dist} {
+        codes[i] = writePrefixCode(w, hist)
+    }
+
+    for _, t := range tokens {
+        switch t.kind {
+        case 'l':
+            codes[0].put(w, int(t.argb >> 8 & 0xff))
+            codes[1].put(w, int(t.argb >> 16 & 0xff))
+            codes[2].put(w, int(t.argb & 0xff))
+            codes[3].put(w, int(t.argb >> 24))
+        case 'c':
+            codes[0].put(w, webpLiterals + webpLengthSyms + t.index)
+        case 'r':
+            sym, extraBits, extra := lz77Prefix(t.length)
+            codes[0].put(w, webpLiterals + sym)
+            w.write(uint32(extra), extraBits)
+            sym, extraBits, extra = lz77Prefix(t.distCode)
+            codes[4].put(w, sym)
+            w.write(uint32(extra), extraBits)
+        }
+    }
+}
+
+func avgChannels(a, b uint32) uint32 {
+    var out uint32
+    for shift := 0; shift < 32; shift += 8 {
+        out |= ((a >> shift & 0xff + b >> shift & 0xff) / 2) << shift
+    }
+    return out
+}
+
+func subChannels(a, b uint32) uint32 {
+    var out uint32
+    for shift := 0; shift < 32; shift += 8 {
+        out |= ((a >> shift - b >> shift) & 0xff) << shift
+    }
+    return out
+}
+
+// residualCost is roughly how many bits a residual will take.
+func residualCost(r uint32) int {
+    cost := 0
+    for shift := 0; shift < 32; shift += 8 {
+        v := int(int8(r >> shift))
+        if v < 0 {
+            v = -v
+        }
+        cost += v
+    }
+    return cost
+}
+
+// perChannel applies f to each channel of a, b and c.
+func perChannel(a, b, c uint32, f func(a, b, c int) int) uint32 {
+    var out uint32
+    for shift := 0; shift < 32; shift += 8 {
+        v := f(int(a >> shift & 0xff), int(b >> shift & 0xff), int(c >> shift & 0xff))
+        out |= uint32(max(0, min(255, v))) << shift
+    }
+    return out
+}
+
+// predict is the decoder's prediction for pixel i under mode. Past the
+// right edge, TR is the first pixel of the row, as in the decoder.
+func predict(argb []uint32, i, width, mode int) uint32 {
+    l, t, tl, tr := argb[i - 1], argb[i - width], argb[i - width - 1], argb[i - width + 1]
+    switch mode {
+    case 1:
+        return l
+    case 2:
+        return t
+    case 3:
+        return tr
+    case 4:
+        return tl
+    case 5:
+        return avgChannels(avgChannels(l, tr), t)
+    case 6:
+        return avgChannels(l, tl)
+    case 7:
+        return avgChannels(l, t)
+    case 8:
+        return avgChannels(tl, t)
+    case 9:
+        return avgChannels(t, tr)
+    case 10:
+        return avgChannels(avgChannels(l, tl), avgChannels(t, tr))
+    case 11:
+        // Whichever of L and T is on the side of the smaller gradient.
+        toL, toT := 0, 0
+        perChannel(l, t, tl, func(l, t, tl int) int {
+            toL += abs(tl - t)
+            toT += abs(tl - l)
+            return 0
+        })
+        if toL < toT {
+            return l
+        }
+        return t
+    case 12:
+        return perChannel(l, t, tl, func(l, t, tl int) int { return l + t - tl })
+    }
+    return perChannel(avgChannels(l, t), tl, 0, func(a, tl, _ int) int { return a + (a - tl) / 2 })
+}
+
+func abs(v int) int {
+    if v < 0 {
+        return -v
+    }
+    return v
+}
+
+// webpPredict replaces argb with its residuals, returning each tile's
+// mode as the predictor transform's sub-image.
+func webpPredict(argb []uint32, width, height int) (modes []uint32, tilesX int) {
+    tile := 1 << webpTileBits
+    tilesX, tilesY := (width + tile - 1) / tile, (height + tile - 1) / tile
+    modes = make([]uint32, tilesX * tilesY)
+
+    for ty := 0; ty < tilesY; ty++ {
+        for tx := 0; tx < tilesX; tx++ {
+            best, bestCost := webpPredictors[0], -1
+            for _, mode := range webpPredictors {
+                cost := 0
+                for y := max(ty * tile, 1); y < min((ty + 1) * tile, height); y++ {
+                    for x := max(tx * tile, 1); x < min((tx + 1) * tile, width); x++ {
+                        i := y * width + x
+                        cost += residualCost(subChannels(argb[i], predict(argb, i, width, mode)))
+                    }
+                }
+                if bestCost < 0 || cost < bestCost {
+                    best, bestCost = mode, cost
+                }
+            }
+            modes[ty * tilesX + tx] = 0xff000000 | uint32(best) << 8
+        }
+    }
+
+    // Into a copy, so every prediction sees original pixels.
+    residuals := make([]uint32, len(argb))
+    for i := range argb {
+        x, y := i % width, i / width
+        var pred uint32
+        switch {
+        case i == 0:
+            pred = 0xff000000
+        case y == 0:
+            pred = argb[i - 1]
+        case x == 0:
+            pred = argb[i - width]
+        default:
+            pred = predict(argb, i, width, int(modes[(y >> webpTileBits) * tilesX + x >> webpTileBits] >> 8 & 0xf))
+        }
+        residuals[i] = subChannels(argb[i], pred)
+    }
+    copy(argb, residuals)
+    return modes, tilesX
+}
+
+// nearLosslessBits is how many low bits of each color quality rounds off.
+func nearLosslessBits(quality int) int {
+    return max(0, min(3, (100 - quality) / 20))
+}
+
+// encodeWebP writes img as a lossless (or, below quality 81, near
+// lossless) WebP.
+func encodeWebP(out io.Writer, img image.Image, quality int) error {
+    b := img.Bounds()
+    width, height := b.Dx(), b.Dy()
+    if width > webpMaxSide || height > webpMaxSide {
+        return fmt.Errorf("%dx%d is too big for webp, max %d on a side", width, height, webpMaxSide)
+    }
+
+    nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+    draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+    bits := nearLosslessBits(quality)
+    round := func(v uint8) uint32 {
+        if bits == 0 {
+            return uint32(v)
+        }
+        return uint32(min(255, (int(v) + 1 << (bits - 1)) >> bits << bits))
+    }
+
+    argb := make([]uint32, width * height)
+    hasAlpha := false
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            c := nrgba.NRGBAAt(x, y)
+            if c.A != 0xff {
+                hasAlpha = true
+            }
+            g := round(c.G)
+            // Subtract green, here while the channels are apart.
+            r, bl := (round(c.R) - g) & 0xff, (round(c.B) - g) & 0xff
+            argb[y * width + x] = uint32(c.A) << 24 | r << 16 | g << 8 | bl
+        }
+    }
+
+    w := &webpWriter{}
+    w.write(0x2f, 8)
+    w.write(uint32(width - 1), 14)
+    w.write(uint32(height - 1), 14)
+    if hasAlpha {
+        w.write(1, 1)
+    } else {
+        w.write(0, 1)
+    }
+    w.write(0, 3)
+
+    // Transforms, undone by the decoder in reverse: subtract green, then
+    // the predictor.
+    w.write(1, 1)
+    w.write(2, 2)
+
+    modes, tilesX := webpPredict(argb, width, height)
+    w.write(1, 1)
+    w.write(0, 2)
+    w.write(webpTileBits - 2, 3)
+    writeEntropyImage(w, modes, tilesX, false)
+
+    w.write(0, 1)
+    writeEntropyImage(w, argb, width, true)
+    data := w.bytes()
+
+    chunk := len(data) + len(data) % 2
+    header := make([]byte, 20)
+    copy(header, "RIFF")
+    binary.LittleEndian.PutUint32(header[4:], uint32(4 + 8 + chunk))
+    copy(header[8:], "WEBPVP8L")
+    binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+    if len(data) % 2 == 1 {
+        data = append(data, 0)
+    }
+
+    if _, err := out.Write(header); err != nil {
+        return err
+    }
+    _, err := out.Write(data)
+    return err
+}
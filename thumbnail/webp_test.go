@@ -0,0 +1,66 @@
+package thumbnail
+
+import (
+    "bytes"
+    "golang.org/x/image/webp"
+    "image"
+    "image/color"
+    "os"
+    "testing"
+)
+
+//=============================================================================
+
+// Thumbnails encoded as WebP decode back, with golang.org/x/image's
+// decoder, at their size and within what Quality rounds off: nothing at
+// 81 and up, then half of 2, 4 and 8 levels.
+func TestWebP(t *testing.T) {
+    data, err := os.ReadFile("testdata/cmyk.jpg")
+    if err != nil {
+        t.Fatal(err)
+    }
+    photo, err := New().Decode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    translucent := gradientImage(40, 30)
+    for i := 3; i < len(translucent.Pix); i += 4 {
+        translucent.Pix[i] = uint8(i)
+    }
+    sources := map[string]image.Image{
+        "gradient": gradientImage(64, 48),
+        "photo": photo,
+        "translucent": translucent,
+        "strip": gradientImage(300, 1),
+        "dot": solidImage(1, 1, color.NRGBA{1, 2, 3, 255}),
+    }
+    tolerance := map[int]int{100: 0, 81: 0, 80: 1, 60: 2, 0: 4}
+
+    for name, src := range sources {
+        sizes := make(map[int]int)
+        for quality, want := range tolerance {
+            var buf bytes.Buffer
+            if err := encodeWebP(&buf, src, quality); err != nil {
+                t.Fatal(err)
+            }
+            sizes[quality] = buf.Len()
+
+            img, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+            if err != nil {
+                t.Fatalf("%s at %d: %v", name, quality, err)
+            }
+            if img.Bounds().Size() != src.Bounds().Size() {
+                t.Errorf("%s at %d: size %v, want %v", name, quality, img.Bounds().Size(), src.Bounds().Size())
+                continue
+            }
+            if d := maxDiff(t, src, img); d > want {
+                t.Errorf("%s at %d: off by up to %d, want %d at most", name, quality, d, want)
+            }
+        }
+
+        // Only the photo; see webp.go on smooth ramps.
+        if name == "photo" && !(sizes[100] > sizes[80] && sizes[80] > sizes[60] && sizes[60] > sizes[0]) {
+            t.Errorf("Photo sizes by quality %v", sizes)
+        }
+    }
+}
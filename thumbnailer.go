@@ -1,198 +1,506 @@
 package main
 
 import (
-    "bytes"
+    "bufio"
+    "context"
     "errors"
     "flag"
     "fmt"
-    "gopkg.in/cheggaaa/pb.v1"
-    "github.com/disintegration/gift"
-    "hash/crc32"
+    "github.com/jbn/thumbnailer/thumbnail"
     "image"
-    "image/png"
-    "io"
+    "image/color"
     "log"
+    "log/slog"
     "math/rand"
     "os"
+    "os/signal"
     "path/filepath"
     "runtime"
     "strings"
-    "strconv"
     "sync"
     "time"
-    _ "image/gif"
-    _ "image/jpeg"
 )
 
 //=============================================================================
 
-var inputDir     = flag.String("i", "image_packs", "input directory")
-var outputDir    = flag.String("o", "image_thumbs", "output directory")
+var inputDir     = flag.String("i", "image_packs", "input directory, single image, or s3://bucket/prefix (or object)")
+var outputDir    = flag.String("o", "image_thumbs", "output directory or s3://bucket/prefix")
 var deduplicate  = flag.Bool("n", true, "skip duplicates")
 var shufflePaths = flag.Bool("s", true, "shuffle image paths")
-var flipVertical = flag.Bool("f", true, "flip vertical")
+var legacyFlip   = flag.Bool("f", true, "deprecated: same as -flip-horizontal, which is all it ever did")
+var flipHoriz    = flag.Bool("flip-horizontal", true, "also write each thumbnail mirrored left to right, suffixed _flipped")
+var flipVertical = flag.Bool("flip-vertical", false, "also write each thumbnail mirrored top to bottom, suffixed _vflipped")
 var verbose      = flag.Bool("v", false, "verbose output")
+var single       = flag.Bool("single", false, "write one thumbnail per input, named after it alone, e.g. photo.png (implies -anchors center -flip-horizontal=false unless they're given)")
+var dedupeMode   = flag.String("dedupe-mode", "crc32", "dedupe by `crc32` (exact bytes, hashed per -hash) or phash (near-duplicates)")
+var dedupeDist   = flag.Int("dedupe-distance", 10, "max phash Hamming distance (of 63 bits) counted as a duplicate")
+var hashName     = flag.String("hash", "crc32", "input checksum: `crc32` (fast) or sha256 (no false duplicates)")
+var quickHash    = flag.Bool("quick-hash", false, "dedupe by a -hash of each file's size and first and last 64KB, reading whole only files that match, to spare IO on big inputs skipped by -skip-existing or read for -dedupe-against")
+var outputFormat = flag.String("format", "png", "thumbnail format: `png`, jpeg or webp (lossless, or near lossless below -quality 81)")
+var matchFormat  = flag.Bool("match-format", false, "write each thumbnail in its source's format (jpeg or png), falling back to -format")
+var pngCompress  = flag.String("png-compression", "default", "png compression: `default`, speed, best or none")
+var bitDepth     = flag.Int("bit-depth", 8, "bits per channel of png thumbnails of 16-bit sources, 8 or 16 (8-bit sources always get 8)")
+var optimizePNG  = flag.Bool("optimize", false, "losslessly shrink png thumbnails further, as gray or indexed color where the pixels allow and at best compression (slower)")
+var keepMetadata = flag.Bool("preserve-metadata", false, "copy orientation, dates and copyright from the source's EXIF into jpeg thumbnails (default: strip everything)")
+var dpi          = flag.Int("dpi", 0, "record this pixel density in png (pHYs) and jpeg (JFIF) thumbnails, e.g. 300 (0 records none)")
+var embedSum     = flag.Bool("embed-checksum", false, "record each source's -hash checksum in its png thumbnails, as a Source-CRC32 (or Source-SHA256) tEXt chunk")
+var jpegQuality  = flag.Int("quality", 90, "JPEG and webp quality, 1-100 (no effect on png)")
+var progressive  = flag.Bool("progressive", false, "write progressive JPEGs, which browsers show coarse-to-fine (needs -format jpeg or -match-format)")
+var autoOrient   = flag.Bool("auto-orient", true, "rotate JPEGs upright using their EXIF orientation")
+var resample     = flag.String("resample", "lanczos", "resampling filter: nearest, box, linear, cubic or `lanczos`")
+var linearResize = flag.Bool("linear-resize", false, "resize in linear light, which keeps fine detail from darkening (slower)")
+var iccConvert   = flag.Bool("icc-convert", false, "convert sources tagged with a non-sRGB ICC profile, like Adobe RGB, to sRGB; untagged ones are taken as sRGB")
+var anchorSpec   = flag.String("anchors", thumbnail.DefaultAnchors, "comma-separated crop anchors: center, left, right, top, bottom, top-left, ..., smart or smart-face")
+var resizeMode   = flag.String("mode", "crop", "`crop` to fill the box, fit to letterbox the whole image, stretch to ignore aspect ratio, or square for the largest centered square (fit, stretch and square ignore -anchors)")
+var maxSide      = flag.Int("max-side", 0, "scale the longer side to this, keeping aspect ratio, instead of filling -d (ignores -d, -mode and -anchors)")
+var scale        = flag.Float64("scale", 0, "resize to this fraction of each input, e.g. 0.25, instead of filling -d (above 1 needs -allow-upscale)")
+var augment      = flag.Bool("augment", false, "write -augment-count randomly rotated, offset and (per -flip-horizontal and -flip-vertical) mirrored thumbnails per input instead, suffixed _aug0 onwards, for training data (multiplies outputs per input by the count; ignores -mode and -anchors)")
+var augmentCount = flag.Int("augment-count", 5, "thumbnails per input with -augment")
+var augmentAngle = flag.Float64("augment-rotate", 10, "most degrees -augment rotates by either way, 0-45")
+var squareMode   = flag.Bool("square", false, "shorthand for -mode square")
+var background   = flag.String("bg", "#000000", "hex background for montages, and padding for fit mode unless -pad-color")
+var padColor     = flag.String("pad-color", "", "hex padding color for fit mode, `#RRGGBB` or #RRGGBBAA; a transparent one stays transparent in png, and is flattened onto -flatten-bg in jpeg (default: -bg)")
+var flattenBg    = flag.String("flatten-bg", "#FFFFFF", "hex color transparency is flattened onto for jpeg (or png with -flatten)")
+var flattenPNG   = flag.Bool("flatten", false, "flatten png output onto -flatten-bg instead of keeping alpha")
+var pngPalette   = flag.Int("png-palette", 0, "write indexed png with this many colors, 2-256 (0 is full color)")
+var borderWidth  = flag.Int("border", 0, "border width in pixels, drawn inside -d")
+var borderColor  = flag.String("border-color", "#FFFFFF", "hex border color")
+var cornerRadius = flag.Int("radius", 0, "round the corners to this radius in pixels (transparent in png, -flatten-bg in jpeg)")
+var maxPixels    = flag.Int64("max-pixels", thumbnail.DefaultMaxPixels, "skip inputs whose header declares more pixels than this, before decoding them (0 is no limit)")
+var allowUpscale = flag.Bool("allow-upscale", false, "thumbnail images smaller than -d instead of skipping them")
+var skipExisting = flag.Bool("skip-existing", false, "skip inputs whose thumbnails all exist already")
+var manifestPath = flag.String("manifest", "", "write a manifest mapping inputs to outputs here")
+var manifestFmt  = flag.String("manifest-format", "csv", "manifest format, `csv` or json")
+var dryRun       = flag.Bool("dry-run", false, "report what would be written without writing thumbnails")
+var shuffleSeed  = flag.Int64("seed", 0, "shuffle and -augment seed, for reproducible runs (default: time-based, and printed)")
+var gifFrame     = flag.String("gif-frame", "first", "animated GIF frame to use: `first`, middle, last, or an index")
+var noAnimated   = flag.Bool("reject-animated", false, "skip animated GIFs, APNGs and WebPs and multi-page TIFFs, counted apart, instead of thumbnailing one frame")
+var sharpen      = flag.Float64("sharpen", 0, "unsharp mask amount applied after resizing, e.g. 0.5 (0 is off)")
+var grayscale    = flag.Bool("grayscale", false, "write luminance-only thumbnails")
+var brightness   = flag.Float64("brightness", 0, "brightness adjustment in percent, -100 to 100")
+var contrast     = flag.Float64("contrast", 0, "contrast adjustment in percent, -100 to 100")
+var gamma        = flag.Float64("gamma", 1, "gamma correction; above 1 lightens, below darkens")
+var spriteLayout = flag.String("sprite", "", "pack each input's thumbnails into one image, `horizontal` or vertical, in sorted anchor order (layout in -manifest and -sidecar files)")
+var montage      = flag.Bool("montage", false, "write one montage per directory instead of individual thumbnails")
+var montageCols  = flag.Int("montage-cols", 10, "columns per montage")
+var extensions   = flag.String("ext", "jpg,jpeg,png,gif,tif,tiff,bmp", "comma-separated input extensions to consider")
+var withHidden   = flag.Bool("include-hidden", false, "also consider files whose names start with a dot (dot directories are always walked)")
+var minFileSize  = flag.Int64("min-file-size", 1, "skip files smaller than this many bytes; 0 takes empty ones too")
+var readStdin    = flag.Bool("stdin", false, "read newline-delimited input paths from stdin instead of walking -i")
+var flatOutput   = flag.Bool("flat", false, "write every thumbnail directly into -o, named after its input's relative path")
+var maxDepth     = flag.Int("max-depth", -1, "directory levels below -i to descend; 0 is -i only (default: unlimited)")
+var passthrough  = flag.Bool("passthrough", false, "don't resample inputs already exactly -d; copy them when the format matches")
+var cropSpec     = flag.String("crop", "", "source region `X,Y,W,H` to keep before anchoring or fitting, clamped to each image")
+var autoTrim     = flag.Bool("autotrim", false, "crop away uniform margins (the top-left corner's color) before thumbnailing")
+var trimTol      = flag.Int("trim-tolerance", 16, "per-channel difference from the corner color still trimmed as margin, 0-255")
+var markPath     = flag.String("watermark", "", "PNG (with alpha) to stamp on every thumbnail")
+var markAnchor   = flag.String("watermark-anchor", "bottom-right", "where the watermark goes, as in -anchors")
+var markOpacity  = flag.Float64("watermark-opacity", 0.5, "watermark opacity, 0-1")
+var markScale    = flag.Float64("watermark-scale", 0.25, "watermark width as a fraction of the thumbnail's")
+var blurHash     = flag.Bool("blurhash", false, "record a BlurHash placeholder of each input in -manifest and -sidecar files")
+var blurHashX    = flag.Int("blurhash-x", 4, "BlurHash components across, 1-9")
+var blurHashY    = flag.Int("blurhash-y", 3, "BlurHash components down, 1-9")
+var dominant     = flag.Bool("dominant-color", false, "record each thumbnail's dominant color in -manifest and -sidecar files")
+var fileTimeout  = flag.Duration("timeout", 0, "give up on a file after this long, e.g. 30s (0 is no limit)")
+var retries      = flag.Int("retries", 0, "retry reads and writes that fail transiently (timeouts, S3 5xx) this many times")
+var retryDelay   = flag.Duration("retry-delay", 500 * time.Millisecond, "wait before the first retry, doubling after each")
+
+var thumbDim = thumbnail.DefaultDim
 
-// This isn't a flag. But, it's populated based on flipVertical.
-var flipOps      = []bool{false}
+func init() {
+    flag.Var(&thumbDim, "d", "Thumbnail Dimensions")
+}
 
-type dim_t [2]int
+// Built from the flags in main.
+var thumbnailer *thumbnail.Thumbnailer
 
-func (p *dim_t) String() string {
-    return fmt.Sprintf("%d,%d", p[0], p[1])
+// Loaded once, since newThumbnailer runs per request under -serve.
+var watermarkOnce sync.Once
+var watermarkImage image.Image
+var watermarkErr error
+
+func loadWatermark(path string) (image.Image, error) {
+    watermarkOnce.Do(func() {
+        fp, err := os.Open(path)
+        if err != nil {
+            watermarkErr = err
+            return
+        }
+        defer fp.Close()
+        watermarkImage, _, watermarkErr = image.Decode(fp)
+    })
+    return watermarkImage, watermarkErr
 }
 
-func (p *dim_t) Set(raw string) error {
-    parts := strings.Split(raw, ",")
-    if len(parts) != 2 {
-        return errors.New("Dimensions argument expected string like `X,Y`")
+func newThumbnailer() (*thumbnail.Thumbnailer, error) {
+    anchors, err := thumbnail.ParseAnchors(*anchorSpec)
+    if err != nil {
+        return nil, err
     }
 
-    for i, s := range parts {
-        v, err := strconv.ParseInt(s, 10, 32)
-        if err != nil {
-            return fmt.Errorf("%q not an integer in %q", v, raw)
+    bg, err := thumbnail.ParseHexColor(*background)
+    if err != nil {
+        return nil, err
+    }
+
+    var pad color.Color
+    if *padColor != "" {
+        if pad, err = thumbnail.ParseHexColor(*padColor); err != nil {
+            return nil, err
         }
-        p[i] = int(v)
     }
 
-    return nil
-}
+    flatBg, err := thumbnail.ParseHexColor(*flattenBg)
+    if err != nil {
+        return nil, err
+    }
 
-// Default is the receptor field for VGG16.
-var thumbDim dim_t = dim_t{224, 224}
+    border, err := thumbnail.ParseHexColor(*borderColor)
+    if err != nil {
+        return nil, err
+    }
 
-func init() {
-    flag.Var(&thumbDim, "d", "Thumbnail Dimensions")
-}
+    var crop image.Rectangle
+    if *cropSpec != "" {
+        if crop, err = thumbnail.ParseRect(*cropSpec); err != nil {
+            return nil, err
+        }
+    }
 
-//=============================================================================
+    var mark image.Image
+    if *markPath != "" {
+        if mark, err = loadWatermark(*markPath); err != nil {
+            return nil, fmt.Errorf("Loading watermark: %w", err)
+        }
+    }
 
-// XXX: TODO: Allow for CLI anchor spec.
-var ANCHORINGS = map[string]gift.Anchor{
-    "left": gift.LeftAnchor,
-    "right": gift.RightAnchor,
-    "center": gift.CenterAnchor,
+    t := thumbnail.New()
+    t.Dim = thumbDim
+    t.Anchors = anchors
+    t.Flip = *flipHoriz
+    if isFlagSet("f") && !isFlagSet("flip-horizontal") {
+        t.Flip = *legacyFlip
+    }
+    t.FlipVertical = *flipVertical
+    if *single {
+        t.Single = true
+        if !isFlagSet("anchors") {
+            t.Anchors, _ = thumbnail.ParseAnchors("center")
+        }
+        if !isFlagSet("f") && !isFlagSet("flip-horizontal") {
+            t.Flip = false
+        }
+    }
+    t.Format = *outputFormat
+    t.MatchFormat = *matchFormat
+    t.PNGCompression = *pngCompress
+    t.Optimize = *optimizePNG
+    t.BitDepth = *bitDepth
+    t.PreserveMetadata = *keepMetadata
+    t.EmbedChecksum = *embedSum
+    t.DPI = *dpi
+    t.Quality = *jpegQuality
+    t.Progressive = *progressive
+    t.Deduplicate = *deduplicate
+    t.DedupeMode = *dedupeMode
+    t.DedupeDistance = *dedupeDist
+    t.Hash = *hashName
+    t.QuickHash = *quickHash
+    t.AutoOrient = *autoOrient
+    t.Resample = *resample
+    t.Mode = *resizeMode
+    t.LinearResize = *linearResize
+    t.ICCConvert = *iccConvert
+    t.MaxSide = *maxSide
+    t.Scale = *scale
+    if *augment {
+        t.Augment = *augmentCount
+        t.AugmentRotate = *augmentAngle
+    }
+    if *squareMode {
+        t.Mode = "square"
+    }
+    t.Background = bg
+    t.PadColor = pad
+    t.AllowUpscale = *allowUpscale
+    t.MaxPixels = *maxPixels
+    t.SkipExisting = *skipExisting
+    if *shards > 0 {
+        t.OutputDir = shardOutput
+    }
+    t.DryRun = *dryRun
+    t.GifFrame = *gifFrame
+    t.RejectAnimated = *noAnimated
+    t.InMemory = *montage
+    t.Sprite = *spriteLayout
+    t.Deferred = *ordered || *archiveFormat != ""
+    t.Sharpen = *sharpen
+    t.Grayscale = *grayscale
+    t.Brightness = *brightness
+    t.Contrast = *contrast
+    t.Gamma = *gamma
+    t.FlattenBackground = flatBg
+    t.Flatten = *flattenPNG
+    t.Palette = *pngPalette
+    t.BorderWidth = *borderWidth
+    t.BorderColor = border
+    t.Radius = *cornerRadius
+    t.Passthrough = *passthrough
+    t.Crop = crop
+    t.AutoTrim = *autoTrim
+    t.TrimTolerance = *trimTol
+    t.Watermark = mark
+    t.WatermarkAnchor = *markAnchor
+    t.WatermarkOpacity = *markOpacity
+    t.WatermarkScale = *markScale
+    if *blurHash {
+        t.BlurHashX, t.BlurHashY = *blurHashX, *blurHashY
+    }
+    t.DominantColors = *dominant
+    t.Storage = uriStorage{}
+    t.Retries = *retries
+    t.RetryDelay = *retryDelay
+
+    // Dry runs print their plan; that's the point of them.
+    level := slog.LevelDebug
+    if *dryRun {
+        level = slog.LevelInfo
+    }
+    t.Log = func(format string, v ...interface{}) {
+        slog.Log(context.Background(), level, fmt.Sprintf(format, v...))
+    }
+    t.Warn = func(format string, v ...interface{}) {
+        slog.Warn(fmt.Sprintf(format, v...))
+    }
+
+    return t, t.Validate()
 }
 
 //=============================================================================
 
 var wg sync.WaitGroup
 
-func readImage(path string) (img image.Image, checksum int64, err error) {
-    fp, err := os.Open(path)
-    defer fp.Close()
+// Nil unless -manifest is given.
+var manifest *manifestWriter
 
-    if err != nil {
-        return nil, -1, err
-    }
+//=============================================================================
 
-    buf := bytes.NewBuffer(nil)
-    io.Copy(buf, fp)
-    checksum = int64(crc32.ChecksumIEEE(buf.Bytes()))
+// This is a channel because there are two execution strategies. If you use 
+// shuffling with deduplication, everything is loaded into memory first. That's 
+// not feasible for some datasets.
+//
+// For those, -shuffle-buffer shuffles through a bounded window instead:
+// each path emitted is a random pick from the buffer, replaced by the next
+// one walked. Memory is the buffer, but the shuffle is only local. A path
+// can't come out before the walk reaches it, so over spans much longer
+// than the buffer the walk's lexicographic order (and its dedup bias)
+// shows through. Make it as large as memory allows.
 
-    img, _, err = image.Decode(buf)
-    if err != nil {
-        return nil, -1, err
-    }
+var shuffleBuffer = flag.Int("shuffle-buffer", 0, "shuffle through a window of this many paths instead of loading them all (0 loads all)")
 
-    return img, int64(checksum), nil
-}
+// Streaming runs don't know their total, so by default they walk once
+// just to count. That's only directory listings, cheap next to decoding,
+// and it buys the bar its ETA. -stdin can't be read twice, so it goes
+// without.
+var countFirst = flag.Bool("count", true, "count inputs in a first walk so streaming runs get a progress total")
 
+var limit = flag.Int("limit", 0, "stop after this many inputs, a random sample when shuffling (0 is no limit)")
 
-func calcResizeBounds(src image.Image) (int, int) {
-    bounds := src.Bounds()
+var nProcessors = flag.Int("workers", runtime.NumCPU() * 2, "number of worker goroutines")
 
-    x, y := bounds.Max.X, bounds.Max.Y
+// Seeded in main. Which duplicate survives dedup depends on the shuffle
+// order, so a reproducible dataset needs a reproducible seed.
+var shuffleRand *rand.Rand
 
-    if x < y {
-        s := thumbDim[0] / x
-        return thumbDim[0], y * s
-    } else {
-        s := thumbDim[1] / y
-        return x * s, thumbDim[1]
-    }
-}
+// Made in main, once -workers and -queue are known.
+var filePaths chan string
 
-func subImage(src image.Image) image.Image {
-    x, y := calcResizeBounds(src)
+// Built from -ext in main. Keys are lowercase, without the dot.
+var allowedExts = make(map[string]bool)
 
-    g := gift.New(gift.Resize(x, y, gift.LanczosResampling))
-    dst := image.NewNRGBA(g.Bounds(src.Bounds()))
-    g.Draw(dst, src)
+func parseExtensions(spec string) {
+    for _, ext := range strings.Split(spec, ",") {
+        ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+        if ext != "" {
+            allowedExts[ext] = true
+        }
+    }
+}
 
-    return dst
+func isImageFile(path string, info os.FileInfo) bool {
+    return !info.IsDir() && isImagePath(path, info.Size()) && // Real files
+           modifiedSince(info.ModTime())
 }
 
+// isImagePath is isImageFile for listings without a FileInfo, like S3's.
+func isImagePath(path string, size int64) bool {
+    baseName := filepath.Base(path)
+    ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(baseName), "."))
+    return ((baseName[0] != '.' || *withHidden) &&  // No hidden files
+            size >= *minFileSize &&                 // Not just markers
+            allowedExts[ext] &&                     // Not READMEs and sidecars
+            !isPlacedThumb(path))                   // Not our own output
+}
 
-var progressBar *pb.ProgressBar = nil
+// beyondMaxDepth reports whether a directory rel (relative to -i, slash or
+// OS separated) is deeper than -max-depth allows.
+func beyondMaxDepth(rel string) bool {
+    if *maxDepth < 0 || rel == "." || rel == "" {
+        return false
+    }
+    return strings.Count(filepath.ToSlash(rel), "/") + 1 > *maxDepth
+}
 
-func createThumbs(src image.Image, anchors map[string]gift.Anchor) map[string]image.Image {
-    thumbs := make(map[string]image.Image)
+// Only touched by the producer; there's just the one.
+var enqueued int
 
-    src = subImage(src)
+// enqueue blocks until path is queued, or returns false if ctx is done or
+// -limit inputs are queued already. Workers drain the queue as usual
+// either way, so a limited run stops as cleanly as a finished one.
+func enqueue(ctx context.Context, path string) bool {
+    if *limit > 0 && enqueued >= *limit {
+        return false
+    }
 
-    for k, anchor := range anchors {
-        for _, flipped := range flipOps {
-            outputName := k
+    select {
+    case filePaths <- path:
+        enqueued += 1
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
 
-            filters := []gift.Filter{gift.CropToSize(thumbDim[0], thumbDim[1], anchor)}
-            if flipped {
-                outputName += "_flipped"
-                filters = append(filters, gift.FlipHorizontal())
+// walkInputs calls visit for every input until visit returns false or ctx
+// is done. Inputs are the image files under inputPath (a directory or an
+// s3:// prefix) or, with -stdin, the lines of stdin (or the inputs of
+// -map). Those are taken as given, since whatever produced the list
+// already chose them.
+func walkInputs(ctx context.Context, inputPath string, visit func (path string) bool) {
+    if *mapPath != "" {
+        for _, path := range mappedInputs {
+            if ctx.Err() != nil || !visit(path) {
+                return
             }
-            g := gift.New(filters...)
-            dst := image.NewNRGBA(g.Bounds(src.Bounds()))
-            g.Draw(dst, src)
+        }
+        return
+    }
 
-            thumbs[outputName] = dst
+    if *readStdin {
+        scanner := bufio.NewScanner(os.Stdin)
+        for scanner.Scan() && ctx.Err() == nil {
+            path := strings.TrimSuffix(scanner.Text(), "\r")
+            if path != "" && !visit(path) {
+                return
+            }
+        }
+        if err := scanner.Err(); err != nil {
+            slog.Error("Reading stdin", "err", err)
         }
+        return
     }
 
-    return thumbs
-}
+    if inputIsFile {
+        visit(inputPath)
+        return
+    }
 
-func saveThumb(filepath string, img image.Image) {
-    fp, err := os.Create(filepath)
-    defer fp.Close()
+    if isS3URI(inputPath) {
+        err := walkS3(inputPath, func (path string) bool {
+            return ctx.Err() == nil && visit(path)
+        })
+        if err != nil {
+            slog.Error("Listing inputs", "path", inputPath, "err", err)
+        }
+        return
+    }
 
-    if err != nil {
-        log.Fatal(err)
+    walk := filepath.Walk
+    if *followSymlinks {
+        walk = walkFollowingLinks
     }
-    err = png.Encode(fp, img)
-    if err != nil {
-        log.Fatal(err)
+
+    walk(inputPath, func (path string, info os.FileInfo, err error) error {
+        // Only seen without -follow-symlinks, which stats through links.
+        if err == nil && *verbose && info.Mode() & os.ModeSymlink != 0 {
+            if target, statErr := os.Stat(path); statErr == nil && target.IsDir() {
+                slog.Debug("Skipping symlinked directory", "path", path)
+            }
+        }
+        rel, relErr := filepath.Rel(inputPath, path)
+        rel = filepath.ToSlash(rel)
+        if err == nil && info.IsDir() && relErr == nil && (beyondMaxDepth(rel) || globExcludedDir(rel)) {
+            return filepath.SkipDir
+        }
+        if err == nil && isImageFile(path, info) && relErr == nil && globSelected(rel) && !visit(path) {
+            return filepath.SkipAll
+        }
+        if err == nil {
+            err = ctx.Err()
+        }
+        return err
+    })
+}
+
+// countInputs is the progress total for the streaming strategies, or zero
+// when it isn't worth a walk.
+func countInputs(ctx context.Context, inputPath string) int {
+    if !*countFirst || *readStdin {
+        return 0
     }
+
+    count := 0
+    walkInputs(ctx, inputPath, func (path string) bool {
+        count += 1
+        return *limit <= 0 || count < *limit
+    })
+    return count
 }
 
-//=============================================================================
+// limitTotal caps a progress total at -limit.
+func limitTotal(total int) int {
+    if *limit > 0 && total > *limit {
+        return *limit
+    }
+    return total
+}
 
-// This is a channel because there are two execution strategies. If you use 
-// shuffling with deduplication, everything is loaded into memory first. That's 
-// not feasible for some datasets.
+// All strategies stop producing as soon as ctx is cancelled.
+func produceInputs(ctx context.Context, inputPath string) {
 
-var nProcessors = runtime.NumCPU() * 2
+    if *shufflePaths && *shuffleBuffer > 0 {
+        progress = startProgress(countInputs(ctx, inputPath))
 
-var filePaths = make(chan string, 4*nProcessors)
+        wg.Add(1)
+        go func() {
+            defer func() { close(filePaths); defer wg.Done() }()
 
-func isImageFile(path string, info os.FileInfo) bool {
-    baseName := filepath.Base(path)
-    return (baseName[0] != '.' && // No hidden files
-            !info.IsDir() &&      // Real files
-            info.Size() > 0)      // Not just markers
-}
+            buffer := make([]string, 0, *shuffleBuffer)
+            walkInputs(ctx, inputPath, func (path string) bool {
+                if len(buffer) < cap(buffer) {
+                    buffer = append(buffer, path)
+                    return true
+                }
+                i := shuffleRand.Intn(len(buffer))
+                next := buffer[i]
+                buffer[i] = path
+                return enqueue(ctx, next)
+            })
 
-func produceInputs(inputPath string) {
+            for _, i := range shuffleRand.Perm(len(buffer)) {
+                if !enqueue(ctx, buffer[i]) {
+                    return
+                }
+            }
+        }()
 
-    if *shufflePaths {
+    } else if *shufflePaths {
         var paths []string
 
         // Gather all paths first.
-        filepath.Walk(inputPath, func (path string, info os.FileInfo, err error) error {
-            if err == nil && isImageFile(path, info) {
-                paths = append(paths, path)
-            }
-            return err
+        walkInputs(ctx, inputPath, func (path string) bool {
+            paths = append(paths, path)
+            return true
         })
 
         // Walk paths shuffled.
@@ -204,142 +512,619 @@ func produceInputs(inputPath string) {
         go func() {
             defer func() { close(filePaths); defer wg.Done() }()
 
-            for _, i := range rand.Perm(len(paths)) {
-                filePaths <- paths[i]
+            for _, i := range shuffleRand.Perm(len(paths)) {
+                if !enqueue(ctx, paths[i]) {
+                    return
+                }
             }
         }()
 
-        if !*verbose {
-            progressBar = pb.StartNew(len(paths))
-        }
+        progress = startProgress(limitTotal(len(paths)))
 
     } else {
+        progress = startProgress(countInputs(ctx, inputPath))
+
         wg.Add(1)
         go func() {
             defer func() { close(filePaths); defer wg.Done() }()
             // Write to the channel ASAP.
-            filepath.Walk(inputPath, func (path string, info os.FileInfo, err error) error {
-                if err == nil && isImageFile(path, info) {
-                    filePaths <- path
-                }
-                return err
+            walkInputs(ctx, inputPath, func (path string) bool {
+                return enqueue(ctx, path)
             })
         }()
     }
 }
 
-func outputPath(inputPath string, ensureDir bool) (string, error) {
-    srcDir, srcName := filepath.Split(inputPath)
-    parts := strings.Split(filepath.ToSlash(srcDir), "/")
-    dstDir := *outputDir
+// outputPath mirrors inputPath's place under -i into -o, unless -map says
+// where it goes. With -inplace it stays where it is, and with -orphan-dir
+// one that has no place under -i goes there. A -shard is only known once
+// ProcessAs has the checksum; see shardFile.
+func outputPath(inputPath string) (string, error) {
+    if output, found := mappedOutputs[inputPath]; found {
+        return output, nil
+    }
+    if *inPlace {
+        return inputPath, nil
+    }
 
-    if len(parts) > 1 {
-        dstDir = filepath.Join(dstDir, filepath.Join(parts[1:]...))
-    } else {
-        return "", fmt.Errorf("Can't split %s into parts", inputPath)
+    output, err := mirrorPath(*inputDir, *outputDir, inputPath, *flatOutput)
+    if errors.Is(err, errOutside) && *orphanDir != "" {
+        return orphanPath(inputPath), nil
     }
+    return output, err
+}
 
-    if ensureDir {
-        os.MkdirAll(dstDir, os.ModePerm)
+// mirrorPath is outputPath with the flags passed in, so it doesn't need a
+// whole run set up around it.
+func mirrorPath(root, out, inputPath string, flat bool) (string, error) {
+    rel, err := relativePath(root, inputPath)
+    if err != nil {
+        return "", err
     }
 
-    return filepath.Join(dstDir, srcName), nil
+    if flat {
+        return thumbnail.JoinPath(out, flatName(rel)), nil
+    }
+    return thumbnail.JoinPath(out, rel), nil
 }
 
+// Wrapped by relativePath's error for a path it has no place for.
+var errOutside = errors.New("outside")
 
-var dupesSkipped = 0
+// relativePath is inputPath's place under root, which for a file right in
+// root (or root itself, if it's a file) is just its name. Local paths are made absolute first so that
+// relative and absolute spellings of the same tree agree.
+func relativePath(root, inputPath string) (string, error) {
+    given, path := root, inputPath
+    if isS3URI(root) != isS3URI(path) {
+        return "", fmt.Errorf("%s is %w %s", inputPath, errOutside, given)
+    }
 
-var checksumMutex sync.Mutex
+    var err error
+    if !isS3URI(root) {
+        if root, err = filepath.Abs(root); err != nil {
+            return "", err
+        }
+        if path, err = filepath.Abs(path); err != nil {
+            return "", err
+        }
+    }
 
-var checksums = make(map[int64]bool)
+    rel, err := filepath.Rel(root, path)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+        return "", fmt.Errorf("%s is %w %s", inputPath, errOutside, given)
+    }
+    if rel == "." {
+        rel = filepath.Base(path)
+    }
+    return rel, nil
+}
 
-func isDupe(checksum int64) bool {
-    // This should be better than a RWLock for most cases.
-    // Usually, you have only a few dupes.
-    checksumMutex.Lock()
-    defer checksumMutex.Unlock()
+// flatName folds rel's directories into its file name, so packA/sub/b.png
+// becomes packA__sub__b.png. Anything in a directory name but letters,
+// digits and dashes (dots too, since the output stem ends at the first
+// one) is escaped as _ and two hex digits, as is an _ in the file name, so
+// __ only ever joins parts and no two paths share a name: a_b/x_y.png is
+// a_5fb__x_5fy.png.
+func flatName(rel string) string {
+    dir, name := filepath.Split(rel)
+    var parts []string
+    for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/") {
+        if part != "." && part != "" {
+            parts = append(parts, escapeName(part, false))
+        }
+    }
+    return strings.Join(append(parts, escapeName(name, true)), "__")
+}
 
-    if _, found := checksums[checksum]; found {
-        dupesSkipped += 1
-        return true
+// escapeName is flatName's escaping of one part of a path; a file name
+// keeps everything but its underscores.
+func escapeName(part string, file bool) string {
+    var b strings.Builder
+    for i := 0; i < len(part); i++ {
+        c := part[i]
+        safe := c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-'
+        if safe || file && c != '_' {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "_%02x", c)
+        }
     }
-    checksums[checksum] = true
-    return false
+    return b.String()
 }
 
+// Tallies for the end-of-run summary. Every worker updates it.
+type runStats struct {
+    mutex      sync.Mutex
+    succeeded  int
+    dupes      int
+    undersized int
+    existing   int
+    skipped    int
+    failed     int
+    corrupt    int
+    timedOut   int
+    missing    int // Only from -map.
+    present    int // Only from -dedupe-against.
+    orphaned   int // Of succeeded, only from -orphan-dir.
+    animated   int // Only from -reject-animated.
+}
 
-func processPath(inputFile string) {
-    if *verbose {
-        fmt.Println(inputFile)
+func (s *runStats) add(counter *int) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    *counter += 1
+}
+
+func (s *runStats) print() {
+    fmt.Printf("Succeeded: %d\n", s.succeeded)
+    fmt.Printf("Dupes Skipped: %d\n", s.dupes)
+    fmt.Printf("Undersized Skipped: %d\n", s.undersized)
+    if *noAnimated {
+        fmt.Printf("Animated Skipped: %d\n", s.animated)
+    }
+    fmt.Printf("Existing Skipped: %d\n", s.existing)
+    fmt.Printf("Skipped: %d\n", s.skipped)
+    fmt.Printf("Failed: %d\n", s.failed)
+    fmt.Printf("Corrupt: %d\n", s.corrupt)
+    fmt.Printf("Timed Out: %d\n", s.timedOut)
+    if *mapPath != "" {
+        fmt.Printf("Missing: %d\n", s.missing)
+    }
+    if *dedupeAgainst != "" {
+        fmt.Printf("Already Present: %d\n", s.present)
+    }
+    if *orphanDir != "" {
+        fmt.Printf("Orphaned: %d\n", s.orphaned)
     }
-    img, checksum, err := readImage(inputFile)
+    if *optimizePNG {
+        fmt.Printf("Optimize Saved: %d bytes\n", thumbnailer.OptimizeSaved())
+    }
+}
+
+func (s *runStats) allFailed() bool {
+    return s.failed + s.corrupt + s.timedOut + s.missing > 0 && s.succeeded + s.dupes + s.present + s.undersized + s.animated + s.existing + s.skipped == 0
+}
 
-    if *deduplicate && false && isDupe(checksum) {
-        if *verbose {
-            fmt.Println("Skipping", inputFile)
+// processWithTimeout is ProcessAs under -timeout. A file that overruns is
+// abandoned rather than killed (Go can't): its goroutine carries on until
+// ProcessAs next checks the context, then discards its work, so nothing
+// it leaves behind looks finished. One that gets all the way through just
+// as it's abandoned is discarded here instead.
+func processWithTimeout(inputFile, outputDir, stem string) (*thumbnail.Result, error) {
+    if *fileTimeout <= 0 {
+        return thumbnailer.ProcessAs(context.Background(), inputFile, outputDir, stem)
+    }
+
+    // Not the interrupt context; in-flight files get to finish on SIGINT.
+    ctx, cancel := context.WithTimeout(context.Background(), *fileTimeout)
+    defer cancel()
+
+    type outcome struct {
+        result *thumbnail.Result
+        err    error
+    }
+    done := make(chan outcome, 1) // Buffered so an abandoned send can't block.
+
+    // Settles whether the goroutine finished or was abandoned first.
+    var settle sync.Mutex
+    gaveUp := false
+
+    go func() {
+        result, err := thumbnailer.ProcessAs(ctx, inputFile, outputDir, stem)
+        settle.Lock()
+        defer settle.Unlock()
+        if gaveUp && err == nil {
+            thumbnailer.Discard(result)
+        }
+        done <- outcome{result, err}
+    }()
+
+    select {
+    case o := <-done:
+        return o.result, o.err
+    case <-ctx.Done():
+        settle.Lock()
+        defer settle.Unlock()
+        // Finishing right at the deadline still counts.
+        select {
+        case o := <-done:
+            return o.result, o.err
+        default:
+            gaveUp = true
+            return &thumbnail.Result{Input: inputFile}, fmt.Errorf("%s: %w", inputFile, ctx.Err())
         }
-        return
     }
+}
 
-    if err != nil{
-        log.Fatal(err)
+var stats runStats
+
+// processPath never aborts the run. One bad file in a scraped dataset 
+// shouldn't cost the other ten thousand, so errors are logged and counted.
+// It returns what's left once the thumbnails are made (writing them under
+// -ordered or -archive, and all the logging and accounting), or nil if
+// nothing is.
+func processPath(ctx context.Context, inputFile string) (finish func()) {
+    // Interrupted; leave whatever is still queued alone.
+    if ctx.Err() != nil {
+        return nil
     }
 
-    thumbs := createThumbs(img, ANCHORINGS)
+    slog.Debug("Processing", "path", inputFile)
 
-    outputFile, err := outputPath(inputFile, true)
+    if *validateOnly {
+        validatePath(inputFile)
+        return nil
+    }
+
+    // Only a path with no place in -o (or -orphan-dir) is skipped; anything
+    // else is a failure.
+    outputFile, err := outputPath(inputFile)
+    if errors.Is(err, errOutside) {
+        return func() {
+            stats.add(&stats.skipped)
+            manifest.record(&thumbnail.Result{Input: inputFile}, "skipped")
+            slog.Warn("Skipping", "path", inputFile, "reason", err)
+        }
+    }
     if err != nil {
-        return // Just skip processing
+        return func() {
+            stats.add(&stats.failed)
+            manifest.record(&thumbnail.Result{Input: inputFile}, "failed")
+            slog.Error("Failed", "path", inputFile, "err", err)
+        }
     }
 
-    d, name := filepath.Split(outputFile)
-    if j := strings.Index(name, "."); j != -1 {
-        name = name[:j]
+    // The stem comes from outputFile so that -flat names carry through.
+    stem := outputStem(outputFile)
+    result, err := processWithTimeout(inputFile, thumbnail.DirPath(outputFile), stem)
+    if *shards > 0 && result != nil && result.Checksum != "" {
+        outputFile = shardFile(inputFile, outputFile, result.Checksum)
     }
-    for k, v := range thumbs {
-        f_p := filepath.Join(d, name + "_" + k + ".png")
-        if *verbose {
-            fmt.Println("Saving", f_p)
+    return func() {
+        if err == nil && thumbnailer.Deferred {
+            err = thumbnailer.Commit(result)
         }
-        saveThumb(f_p, v)
+        recordResult(inputFile, outputFile, result, err)
     }
 }
 
-func consumer() {
-    defer wg.Done()
-    shouldDoProgress := *shufflePaths && !*verbose
+// recordResult logs and counts what became of inputFile.
+func recordResult(inputFile, outputFile string, result *thumbnail.Result, err error) {
+    if *montage && result != nil {
+        collectMontage(thumbnail.DirPath(outputFile), result)
+    }
+
+    var dupe *thumbnail.DuplicateError
+    if errors.As(err, &dupe) && priorPaths[dupe.Original] {
+        stats.add(&stats.present)
+        slog.Debug("Skipping already present", "path", inputFile, "original", dupe.Original)
+        manifest.record(result, "present")
+        return
+    }
+    if errors.As(err, &dupe) {
+        stats.add(&stats.dupes)
+        if *dupAction == "log" {
+            slog.Info("Duplicate", "path", inputFile, "original", dupe.Original)
+        } else {
+            slog.Debug("Skipping duplicate", "path", inputFile, "original", dupe.Original)
+        }
+        if *dupAction == "link" && !*montage && linkDuplicate(result, outputFile) {
+            manifest.record(result, "linked")
+        } else {
+            manifest.record(result, "skipped")
+        }
+        return
+    }
+
+    if errors.Is(err, thumbnail.ErrUndersized) {
+        stats.add(&stats.undersized)
+        manifest.record(result, "skipped")
+        slog.Debug("Skipping undersized", "path", inputFile, "size", fmt.Sprintf("%dx%d", result.Size.X, result.Size.Y))
+        return
+    }
+
+    if errors.Is(err, thumbnail.ErrAnimated) {
+        stats.add(&stats.animated)
+        manifest.record(result, "skipped")
+        slog.Debug("Skipping animated", "path", inputFile)
+        return
+    }
+
+    if errors.Is(err, thumbnail.ErrTooLarge) {
+        stats.add(&stats.skipped)
+        manifest.record(result, "skipped")
+        slog.Warn("Skipping too large", "path", inputFile, "err", err)
+        return
+    }
+
+    if errors.Is(err, thumbnail.ErrExists) {
+        stats.add(&stats.existing)
+        manifest.record(result, "skipped")
+        slog.Debug("Skipping existing", "path", inputFile)
+        return
+    }
 
-    for inputFile := range filePaths {
-        processPath(inputFile)
+    if errors.Is(err, thumbnail.ErrCorrupt) {
+        stats.add(&stats.corrupt)
+        manifest.record(result, "failed")
+        slog.Error("Corrupt", "path", inputFile, "err", err)
+        if *quarantineDir != "" {
+            quarantine(inputFile)
+        }
+        return
+    }
 
+    if errors.Is(err, context.DeadlineExceeded) {
+        stats.add(&stats.timedOut)
+        manifest.record(result, "failed")
+        slog.Error("Timed out", "path", inputFile, "after", fileTimeout.String())
+        return
+    }
+
+    if err != nil {
+        stats.add(&stats.failed)
+        manifest.record(result, "failed")
+        slog.Error("Failed", "path", inputFile, "err", err)
+        return
+    }
+
+    stats.add(&stats.succeeded)
+    orphaned := isOrphan(inputFile)
+    if orphaned {
+        stats.add(&stats.orphaned)
+    }
+    if *writeSidecars && !*dryRun && !*montage {
+        writeSidecarFiles(result)
+    }
+    if *dryRun {
+        manifest.record(result, "planned")
+    } else if orphaned {
+        manifest.record(result, "orphaned")
+    } else {
+        manifest.record(result, "written")
+    }
+}
+
+func consumer(ctx context.Context, worker int) {
+    defer wg.Done()
 
-        if shouldDoProgress {
-            progressBar.Increment()
+    for {
+        inputFile, index, ok := nextInput()
+        if !ok {
+            return
         }
+        finish := processPath(ctx, inputFile)
+        if *ordered {
+            reorder.settle(index, finish)
+        } else if finish != nil {
+            finish()
+        }
+        progress.increment()
+        workerDone[worker].Add(1)
     }
 }
 
-func receiveInputs() {
-    for i := 0; i < nProcessors; i++ {
+func receiveInputs(ctx context.Context) {
+    for i := 0; i < *nProcessors; i++ {
         wg.Add(1)
-        go consumer()
+        go consumer(ctx, i)
     }
 }
 
+// On SIGINT, stop producing and let workers finish the file in hand. The
+// WaitGroup then drains normally, so main still prints its summary.
+func handleInterrupt() context.Context {
+    ctx, cancel := context.WithCancel(context.Background())
+
+    interrupts := make(chan os.Signal, 1)
+    signal.Notify(interrupts, os.Interrupt)
+
+    go func() {
+        <-interrupts
+        slog.Warn("Interrupted, finishing in-flight files")
+        signal.Stop(interrupts)
+        cancel()
+    }()
+
+    return ctx
+}
+
 //=============================================================================
 
+func isFlagSet(name string) bool {
+    set := false
+    flag.Visit(func (f *flag.Flag) {
+        if f.Name == name {
+            set = true
+        }
+    })
+    return set
+}
+
 func main() {
-    rand.Seed(time.Now().UTC().UnixNano())
     flag.Parse()
 
-    if *flipVertical {
-        flipOps = append(flipOps, true)
+    if *configPath != "" {
+        if err := loadConfig(*configPath); err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    if err := setupLogging(); err != nil {
+        log.Fatal(err)
+    }
+
+    seed := *shuffleSeed
+    if !isFlagSet("seed") {
+        seed = time.Now().UTC().UnixNano()
+        if (*shufflePaths || *augment) && *serveAddr == "" {
+            slog.Info("Seed", "seed", seed)
+        }
+    }
+    shuffleRand = rand.New(rand.NewSource(seed))
+
+    parseExtensions(*extensions)
+
+    if *shuffleBuffer < 0 {
+        fatal(fmt.Errorf("Shuffle buffer %d out of range, expected 0 or more", *shuffleBuffer))
+    }
+
+    if err := validDupAction(*dupAction); err != nil {
+        fatal(err)
+    }
+
+    if err := validShards(*shards); err != nil {
+        fatal(err)
+    }
+
+    if err := validPlaceSuffix(*placeSuffix); err != nil {
+        fatal(err)
+    }
+
+    if *archiveFormat != "" {
+        if err := validArchive(); err != nil {
+            fatal(err)
+        }
+    }
+
+    if *spriteLayout != "" && *montage {
+        fatal(errors.New("Use -sprite or -montage, not both"))
+    }
+
+    if err := setupGlobs(); err != nil {
+        fatal(err)
+    }
+
+    if *sinceSpec != "" {
+        since, err := parseSince(*sinceSpec, time.Now())
+        if err != nil {
+            fatal(err)
+        }
+        sinceTime = since
+    }
+
+    if *augment && *augmentCount < 1 {
+        fatal(fmt.Errorf("Augment count %d out of range, expected at least 1", *augmentCount))
+    }
+
+    if *minFileSize < 0 {
+        fatal(fmt.Errorf("Min file size %d out of range, expected 0 or more", *minFileSize))
+    }
+
+    if *limit < 0 {
+        fatal(fmt.Errorf("Limit %d out of range, expected 0 or more", *limit))
+    }
+
+    if *nProcessors < 1 {
+        fatal(fmt.Errorf("Workers %d out of range, expected at least 1", *nProcessors))
+    }
+    if *queueSize < 0 {
+        fatal(fmt.Errorf("Queue %d out of range, expected 0 or more", *queueSize))
+    }
+    filePaths = make(chan string, queueCapacity())
+
+    var err error
+    thumbnailer, err = newThumbnailer()
+    if err != nil {
+        fatal(err)
+    }
+    thumbnailer.AugmentSeed = seed
+
+    // Harmless, so only said with -v.
+    if *outputFormat == "png" && isFlagSet("quality") {
+        slog.Debug("-quality has no effect on png output")
+    }
+    if isFlagSet("f") {
+        slog.Warn("-f is deprecated, use -flip-horizontal")
+    }
+    if *embedSum && *outputFormat != "png" && !*matchFormat {
+        slog.Warn("-embed-checksum only applies to png output")
+    }
+    if *optimizePNG && *outputFormat != "png" && !*matchFormat {
+        slog.Warn("-optimize only applies to png output")
+    }
+
+    if *serveAddr != "" {
+        fatal(serve(*serveAddr))
+    }
+
+    if *mapPath != "" {
+        if *readStdin {
+            fatal(errors.New("Use -map or -stdin, not both"))
+        }
+        if err := loadMapping(*mapPath); err != nil {
+            fatal(fmt.Errorf("Loading map: %w", err))
+        }
+    }
+
+    if *dedupeAgainst != "" {
+        if !*deduplicate {
+            fatal(errors.New("-dedupe-against needs -n"))
+        }
+        if err := loadPrior(*dedupeAgainst); err != nil {
+            fatal(fmt.Errorf("Loading -dedupe-against: %w", err))
+        }
+    }
+
+    if *manifestPath != "" {
+        manifest, err = openManifest(*manifestPath, *manifestFmt)
+        if err != nil {
+            fatal(err)
+        }
+    }
+
+    if *archiveFormat != "" && !*dryRun {
+        path := filepath.Clean(*outputDir) + "." + *archiveFormat
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            fatal(err)
+        }
+        archive, err = openArchive(path, *archiveFormat)
+        if err != nil {
+            fatal(err)
+        }
+        thumbnailer.Storage = archiveStorage{}
     }
 
-    produceInputs(*inputDir)
-    receiveInputs()
+    if *mapPath == "" && !*readStdin {
+        inputIsFile = isSingleFile(*inputDir)
+    }
+
+    ctx := handleInterrupt()
+
+    stopMetrics := startMetrics()
+    produceInputs(ctx, *inputDir)
+    receiveInputs(ctx)
 
     wg.Wait()
-    fmt.Printf("Dupes Skipped: %d\n", dupesSkipped)
+    stopMetrics()
+    progress.finish()
+    if *montage && !*dryRun {
+        writeMontages(*montageCols)
+    }
+    if err := manifest.close(); err != nil {
+        slog.Error("Writing manifest", "err", err)
+    }
+    if archive != nil && *manifestPath != "" {
+        if err := archive.addManifest(*manifestPath); err != nil {
+            slog.Error("Archiving manifest", "err", err)
+        }
+    }
+    if err := archive.close(); err != nil {
+        slog.Error("Writing archive", "err", err)
+    }
+    if ctx.Err() != nil {
+        fmt.Println("Interrupted")
+    }
+    if *validateOnly {
+        if err := writeReport(); err != nil {
+            slog.Error("Writing report", "err", err)
+        }
+    }
+    stats.print()
     fmt.Println("Done")
+
+    if stats.allFailed() {
+        os.Exit(1)
+    }
 }
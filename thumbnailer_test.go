@@ -0,0 +1,221 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "image"
+    "image/png"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+//=============================================================================
+
+// The CLI keeps its options in flag globals. Tests set the ones they need
+// with setFlag, which puts them back after.
+
+func setFlag[T any](t *testing.T, p *T, v T) {
+    t.Helper()
+    old := *p
+    *p = v
+    t.Cleanup(func() { *p = old })
+}
+
+// writeFile writes data to name under dir and returns its path.
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// pngImage is a small PNG, big enough for the 16x16 thumbnails run makes.
+func pngImage(t *testing.T) []byte {
+    t.Helper()
+    img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+    for i := range img.Pix {
+        img.Pix[i] = uint8(i)
+    }
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+// run thumbnails -i into -o as main would, but on this goroutine, and
+// returns what's under -o, relative and sorted.
+func run(t *testing.T) []string {
+    t.Helper()
+    setFlag(t, &thumbDim, thumbnail.Dim{16, 16})
+    if len(allowedExts) == 0 {
+        parseExtensions(*extensions)
+    }
+    th, err := newThumbnailer()
+    if err != nil {
+        t.Fatal(err)
+    }
+    setFlag(t, &thumbnailer, th)
+    setFlag(t, &inputIsFile, isSingleFile(*inputDir))
+
+    ctx := context.Background()
+    walkInputs(ctx, *inputDir, func(path string) bool {
+        if finish := processPath(ctx, path); finish != nil {
+            finish()
+        }
+        return true
+    })
+
+    var outputs []string
+    filepath.Walk(*outputDir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() {
+            rel, _ := filepath.Rel(*outputDir, path)
+            outputs = append(outputs, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    sort.Strings(outputs)
+    return outputs
+}
+
+// Inputs right in -i get thumbnails right in -o, like nested ones get
+// theirs in the same place under it.
+func TestTopLevelInputs(t *testing.T) {
+    dir := t.TempDir()
+    writeFile(t, dir, "in/a.png", pngImage(t))
+    writeFile(t, dir, "in/x/b.png", pngImage(t))
+    setFlag(t, inputDir, filepath.Join(dir, "in"))
+    setFlag(t, outputDir, filepath.Join(dir, "out"))
+    setFlag(t, deduplicate, false)
+    setFlag(t, single, true)
+
+    if got := run(t); !reflect.DeepEqual(got, []string{"a.png", "x/b.png"}) {
+        t.Errorf("Got %v", got)
+    }
+}
+
+func TestMirrorPath(t *testing.T) {
+    tests := []struct {
+        root, input string
+        flat        bool
+        want        string
+    }{
+        {"in", "in/a.jpg", false, "out/a.jpg"},
+        {"in", "in/packA/sub/b.png", false, "out/packA/sub/b.png"},
+        {"in", "in/packA/sub/b.png", true, "out/packA__sub__b.png"},
+        {"s3://bucket/in", "s3://bucket/in/x/c.jpg", false, "out/x/c.jpg"},
+    }
+
+    for _, test := range tests {
+        got, err := mirrorPath(test.root, "out", test.input, test.flat)
+        if err != nil || got != filepath.FromSlash(test.want) {
+            t.Errorf("%s under %s, flat %v: got %q, %v, want %q", test.input, test.root, test.flat, got, err, test.want)
+        }
+    }
+
+    for _, input := range []string{"elsewhere/a.jpg", "in/../a.jpg", "s3://bucket/in/a.jpg"} {
+        if _, err := mirrorPath("in", "out", input, false); !errors.Is(err, errOutside) {
+            t.Errorf("%s: got %v, want errOutside", input, err)
+        }
+    }
+}
+
+// -flat names never clash, however the paths spell their separators.
+func TestFlatName(t *testing.T) {
+    if got := flatName(filepath.FromSlash("a_b/x_y.png")); got != "a_5fb__x_5fy.png" {
+        t.Errorf("Got %q", got)
+    }
+    seen := make(map[string]string)
+    for _, rel := range []string{"a b/p.png", "a.b/p.png", "a_b/p.png", "a__b/p.png", "x__y/p.png", "x/y__p.png", "x/y/p.png", "x__y__p.png"} {
+        name := flatName(filepath.FromSlash(rel))
+        if other, found := seen[name]; found {
+            t.Errorf("%s and %s are both %s", other, rel, name)
+        }
+        seen[name] = rel
+    }
+}
+
+func TestOutputPath(t *testing.T) {
+    dir := t.TempDir()
+    in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+    input := writeFile(t, in, "packA/a.jpg", []byte("a"))
+    setFlag(t, inputDir, in)
+    setFlag(t, outputDir, out)
+
+    if got, err := outputPath(input); err != nil || got != filepath.Join(out, "packA", "a.jpg") {
+        t.Errorf("Mirrored: got %q, %v", got, err)
+    }
+
+    setFlag(t, &mappedOutputs, map[string]string{input: "s3://bucket/elsewhere.jpg"})
+    if got, err := outputPath(input); err != nil || got != "s3://bucket/elsewhere.jpg" {
+        t.Errorf("Mapped: got %q, %v", got, err)
+    }
+    mappedOutputs = nil
+
+    setFlag(t, inPlace, true)
+    if got, err := outputPath(input); err != nil || got != input {
+        t.Errorf("In place: got %q, %v", got, err)
+    }
+    *inPlace = false
+
+    if _, err := outputPath(filepath.Join(dir, "other.jpg")); !errors.Is(err, errOutside) {
+        t.Errorf("Outside -i: got %v, want errOutside", err)
+    }
+}
+
+// chdir moves into dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+    t.Helper()
+    old, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(dir); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(old) })
+}
+
+func TestRelativePath(t *testing.T) {
+    dir := t.TempDir()
+    chdir(t, dir)
+    abs := func(p string) string { return filepath.Join(dir, p) }
+
+    tests := []struct {
+        root, input string
+        want        string
+    }{
+        // Nested, to any depth.
+        {"in", "in/a/b/c/d.jpg", "a/b/c/d.jpg"},
+        // Right in the root, and the root being the file itself.
+        {"in", "in/a.jpg", "a.jpg"},
+        {"in/a.jpg", "in/a.jpg", "a.jpg"},
+        // Relative and absolute spellings, either way round.
+        {"in", abs("in/x/a.jpg"), "x/a.jpg"},
+        {abs("in"), "in/x/a.jpg", "x/a.jpg"},
+        {"./in/", "in/./x/../x/a.jpg", "x/a.jpg"},
+        {"s3://bucket/in", "s3://bucket/in/x/a.jpg", "x/a.jpg"},
+    }
+    for _, test := range tests {
+        got, err := relativePath(test.root, test.input)
+        if err != nil || got != filepath.FromSlash(test.want) {
+            t.Errorf("%s under %s: got %q, %v, want %q", test.input, test.root, got, err, test.want)
+        }
+    }
+
+    for _, input := range []string{"in2/a.jpg", "a.jpg", abs("elsewhere/in/a.jpg"), "s3://bucket/in2/a.jpg"} {
+        if _, err := relativePath("in", input); !errors.Is(err, errOutside) {
+            t.Errorf("%s: got %v, want errOutside", input, err)
+        }
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "github.com/jbn/thumbnailer/thumbnail"
+    "log/slog"
+    "sort"
+    "strings"
+    "sync"
+)
+
+//=============================================================================
+
+// -validate audits a dataset instead of thumbnailing it: every input is
+// fully decoded (a dry run only reads headers) and checked against -d and
+// the other inputs, then the lot is summed up in a report on stdout, and
+// with -validate-json in a file too. Nothing is written to -o.
+
+var validateOnly = flag.Bool("validate", false, "decode and check every input, reporting formats, sizes, corrupt files and duplicates instead of thumbnailing")
+var validateJSON = flag.String("validate-json", "", "with -validate, also write the report here (or to s3://bucket/key) as JSON")
+
+type percentiles struct {
+    Min    int `json:"min"`
+    P10    int `json:"p10"`
+    Median int `json:"median"`
+    P90    int `json:"p90"`
+    Max    int `json:"max"`
+}
+
+type validationReport struct {
+    mutex      sync.Mutex
+    Inputs     int                 `json:"inputs"`
+    Valid      int                 `json:"valid"`
+    Formats    map[string]int      `json:"formats"`
+    Width      percentiles         `json:"width"`
+    Height     percentiles         `json:"height"`
+    Corrupt    []string            `json:"corrupt"`
+    Failed     []string            `json:"failed"` // Unreadable, or outside -crop.
+    Undersized []string            `json:"undersized"`
+    TooLarge   []string            `json:"too_large"` // Over -max-pixels.
+    Animated   []string            `json:"animated,omitempty"` // With -reject-animated.
+    Duplicates map[string][]string `json:"duplicates"` // Keyed by the first of each cluster seen.
+
+    widths, heights []int
+}
+
+var report = &validationReport{Formats: make(map[string]int), Duplicates: make(map[string][]string)}
+
+// validatePath is processPath for -validate.
+func validatePath(inputFile string) {
+    result, err := thumbnailer.Inspect(inputFile)
+
+    report.mutex.Lock()
+    defer report.mutex.Unlock()
+
+    report.Inputs += 1
+    if result.Format != "" {
+        report.Formats[result.Format] += 1
+    }
+    if result.Size.X > 0 {
+        report.widths = append(report.widths, result.Size.X)
+        report.heights = append(report.heights, result.Size.Y)
+    }
+
+    var dupe *thumbnail.DuplicateError
+    switch {
+    case err == nil:
+        report.Valid += 1
+        stats.add(&stats.succeeded)
+    case errors.As(err, &dupe):
+        report.Duplicates[dupe.Original] = append(report.Duplicates[dupe.Original], inputFile)
+        stats.add(&stats.dupes)
+    case errors.Is(err, thumbnail.ErrUndersized):
+        report.Undersized = append(report.Undersized, inputFile)
+        stats.add(&stats.undersized)
+    case errors.Is(err, thumbnail.ErrTooLarge):
+        report.TooLarge = append(report.TooLarge, inputFile)
+        stats.add(&stats.skipped)
+    case errors.Is(err, thumbnail.ErrAnimated):
+        report.Animated = append(report.Animated, inputFile)
+        stats.add(&stats.animated)
+    case errors.Is(err, thumbnail.ErrCorrupt):
+        report.Corrupt = append(report.Corrupt, inputFile)
+        stats.add(&stats.corrupt)
+        slog.Debug("Corrupt", "path", inputFile, "err", err)
+    default:
+        report.Failed = append(report.Failed, inputFile)
+        stats.add(&stats.failed)
+        slog.Debug("Failed", "path", inputFile, "err", err)
+    }
+}
+
+// nearestRank is the p'th percentile of sorted values.
+func nearestRank(sorted []int, p int) int {
+    i := (p * len(sorted) + 99) / 100 - 1
+    return sorted[max(i, 0)]
+}
+
+func summarize(values []int) percentiles {
+    if len(values) == 0 {
+        return percentiles{}
+    }
+    sort.Ints(values)
+    return percentiles{
+        Min: values[0],
+        P10: nearestRank(values, 10),
+        Median: nearestRank(values, 50),
+        P90: nearestRank(values, 90),
+        Max: values[len(values) - 1],
+    }
+}
+
+// finish fills in the aggregates, and orders everything so that reports
+// of the same dataset diff cleanly whatever the worker scheduling.
+func (r *validationReport) finish() {
+    r.Width = summarize(r.widths)
+    r.Height = summarize(r.heights)
+    for _, list := range []*[]string{&r.Corrupt, &r.Failed, &r.Undersized, &r.TooLarge, &r.Animated} {
+        if *list == nil {
+            *list = []string{} // [] rather than null in the JSON.
+        }
+        sort.Strings(*list)
+    }
+    for _, dupes := range r.Duplicates {
+        sort.Strings(dupes)
+    }
+}
+
+func printList(title string, paths []string) {
+    fmt.Printf("%s: %d\n", title, len(paths))
+    for _, p := range paths {
+        fmt.Printf("  %s\n", p)
+    }
+}
+
+func (r *validationReport) print() {
+    fmt.Printf("Inputs: %d\n", r.Inputs)
+    fmt.Printf("Valid: %d\n", r.Valid)
+
+    var formats []string
+    for format, n := range r.Formats {
+        formats = append(formats, fmt.Sprintf("%s %d", format, n))
+    }
+    sort.Strings(formats)
+    fmt.Printf("Formats: %s\n", strings.Join(formats, ", "))
+
+    for _, dim := range []struct{ name string; p percentiles }{{"Width", r.Width}, {"Height", r.Height}} {
+        fmt.Printf("%s: min %d, p10 %d, median %d, p90 %d, max %d\n",
+            dim.name, dim.p.Min, dim.p.P10, dim.p.Median, dim.p.P90, dim.p.Max)
+    }
+
+    printList("Corrupt", r.Corrupt)
+    printList("Failed", r.Failed)
+    printList("Undersized", r.Undersized)
+    printList("Too Large", r.TooLarge)
+    if *noAnimated {
+        printList("Animated", r.Animated)
+    }
+
+    var originals []string
+    for original := range r.Duplicates {
+        originals = append(originals, original)
+    }
+    sort.Strings(originals)
+    fmt.Printf("Duplicate clusters: %d\n", len(originals))
+    for _, original := range originals {
+        fmt.Printf("  %s\n", original)
+        for _, dupe := range r.Duplicates[original] {
+            fmt.Printf("    %s\n", dupe)
+        }
+    }
+}
+
+// writeReport prints the report, and writes it to -validate-json if set.
+func writeReport() error {
+    report.finish()
+    report.print()
+
+    if *validateJSON == "" {
+        return nil
+    }
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return err
+    }
+    return thumbnailer.Storage.WriteFile(*validateJSON, append(data, '\n'))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "flag"
+    "os"
+    "path/filepath"
+)
+
+//=============================================================================
+
+// filepath.Walk lstats, so a symlinked directory looks like a file and is
+// never descended. Datasets assembled out of symlinks need the other
+// behavior, hence -follow-symlinks.
+
+var followSymlinks = flag.Bool("follow-symlinks", false, "descend into symlinked directories (each real directory is visited once)")
+
+// walkFollowingLinks is filepath.Walk, but stats through symlinks. Every
+// real directory is walked at most once, which is also what stops link
+// cycles. Dangling links are passed to fn as themselves.
+func walkFollowingLinks(root string, fn filepath.WalkFunc) error {
+    info, err := os.Stat(root)
+    if err != nil {
+        err = fn(root, nil, err)
+    } else {
+        err = walkLinked(root, info, fn, make(map[string]bool))
+    }
+
+    if err == filepath.SkipDir || err == filepath.SkipAll {
+        return nil
+    }
+    return err
+}
+
+func walkLinked(path string, info os.FileInfo, fn filepath.WalkFunc, visited map[string]bool) error {
+    if !info.IsDir() {
+        return fn(path, info, nil)
+    }
+
+    real, err := filepath.EvalSymlinks(path)
+    if err == nil {
+        real, err = filepath.Abs(real)
+    }
+    if err == nil {
+        if visited[real] {
+            return nil
+        }
+        visited[real] = true
+    }
+
+    if err := fn(path, info, nil); err != nil {
+        return err
+    }
+
+    entries, err := os.ReadDir(path)
+    if err != nil {
+        return fn(path, info, err)
+    }
+
+    for _, entry := range entries {
+        child := filepath.Join(path, entry.Name())
+        childInfo, err := os.Stat(child)
+        if err != nil {
+            childInfo, err = os.Lstat(child)
+        }
+        if err != nil {
+            err = fn(child, nil, err)
+        } else {
+            err = walkLinked(child, childInfo, fn, visited)
+        }
+
+        if err == filepath.SkipDir {
+            if childInfo == nil || !childInfo.IsDir() {
+                return nil // Skip the rest of path, like Walk.
+            }
+        } else if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+//=============================================================================
+
+// walked lists what walkInputs finds under root, relative to it and
+// sorted, with the -ext defaults.
+func walked(t *testing.T, root string) []string {
+    t.Helper()
+    if len(allowedExts) == 0 {
+        parseExtensions(*extensions)
+    }
+
+    var got []string
+    walkInputs(context.Background(), root, func(path string) bool {
+        rel, err := filepath.Rel(root, path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, filepath.ToSlash(rel))
+        return true
+    })
+    sort.Strings(got)
+    return got
+}
+
+// imageTree writes a stand-in image (walking doesn't decode) at each of
+// names under a new directory, and returns it.
+func imageTree(t *testing.T, names ...string) string {
+    t.Helper()
+    root := t.TempDir()
+    for _, name := range names {
+        writeFile(t, root, name, []byte("image"))
+    }
+    return root
+}
+
+func TestMaxDepth(t *testing.T) {
+    root := imageTree(t, "a.jpg", "x/b.jpg", "x/y/c.jpg", "x/y/z/d.jpg")
+    tests := []struct {
+        depth int
+        want  []string
+    }{
+        {0, []string{"a.jpg"}},
+        {1, []string{"a.jpg", "x/b.jpg"}},
+        {2, []string{"a.jpg", "x/b.jpg", "x/y/c.jpg"}},
+        {-1, []string{"a.jpg", "x/b.jpg", "x/y/c.jpg", "x/y/z/d.jpg"}},
+    }
+
+    for _, test := range tests {
+        setFlag(t, maxDepth, test.depth)
+        if got := walked(t, root); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("Depth %d: got %v, want %v", test.depth, got, test.want)
+        }
+    }
+}
+
+// Hidden files are only taken with -include-hidden, though dot directories
+// are always walked, and empty ones only with -min-file-size 0.
+func TestHiddenAndEmpty(t *testing.T) {
+    root := imageTree(t, ".a.jpg", "b.jpg", ".dir/c.jpg")
+    writeFile(t, root, "empty.jpg", nil)
+    tests := []struct {
+        hidden bool
+        size   int64
+        want   []string
+    }{
+        {false, 1, []string{".dir/c.jpg", "b.jpg"}},
+        {true, 1, []string{".a.jpg", ".dir/c.jpg", "b.jpg"}},
+        {false, 0, []string{".dir/c.jpg", "b.jpg", "empty.jpg"}},
+        {true, 0, []string{".a.jpg", ".dir/c.jpg", "b.jpg", "empty.jpg"}},
+        {true, 6, nil},
+    }
+
+    for _, test := range tests {
+        setFlag(t, withHidden, test.hidden)
+        setFlag(t, minFileSize, test.size)
+        if got := walked(t, root); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("Hidden %v, min size %d: got %v, want %v", test.hidden, test.size, got, test.want)
+        }
+    }
+}
+
+func TestWalkFollowingLinks(t *testing.T) {
+    root := imageTree(t, "a/x.jpg", "c/y.jpg")
+    // A cycle back to the root, and a second name for a.
+    for link, target := range map[string]string{"a/loop": root, "b": filepath.Join(root, "a")} {
+        if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+            t.Skip("No symlinks here:", err)
+        }
+    }
+
+    seen := make(map[string]int)
+    var files []string
+    err := walkFollowingLinks(root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.IsDir() {
+            real, _ := filepath.EvalSymlinks(path)
+            seen[real]++
+        } else {
+            rel, _ := filepath.Rel(root, path)
+            files = append(files, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for dir, n := range seen {
+        if n != 1 {
+            t.Errorf("%s visited %d times", dir, n)
+        }
+    }
+    if len(seen) != 3 {
+        t.Errorf("Visited %d directories, want the root, a and c", len(seen))
+    }
+    // a's image comes once, under whichever name got there first.
+    sort.Strings(files)
+    if len(files) != 2 || files[1] != "c/y.jpg" {
+        t.Errorf("Got files %v", files)
+    }
+}
+
+// Without -follow-symlinks, a linked directory isn't walked at all.
+func TestWalkSkipsLinks(t *testing.T) {
+    root := imageTree(t, "a.jpg")
+    elsewhere := imageTree(t, "b.jpg")
+    if err := os.Symlink(elsewhere, filepath.Join(root, "linked")); err != nil {
+        t.Skip("No symlinks here:", err)
+    }
+
+    if got := walked(t, root); !reflect.DeepEqual(got, []string{"a.jpg"}) {
+        t.Errorf("Got %v", got)
+    }
+    setFlag(t, followSymlinks, true)
+    if got := walked(t, root); !reflect.DeepEqual(got, []string{"a.jpg", "linked/b.jpg"}) {
+        t.Errorf("Following links: got %v", got)
+    }
+}